@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// AuditLogKey is the path of a JSONL file that audit records are appended to. The
+// github action uploads this file as part of the event artifact.
+const AuditLogKey = "CHECK_ENFORCER_AUDIT_LOG"
+
+var overrideReasonRegex = regexp.MustCompile(`(?is)^\s*/check-enforcer\s+override\b(.*)$`)
+
+// OverrideAudit records who bypassed check enforcer for a commit, and why.
+type OverrideAudit struct {
+	Action     string    `json:"action"`
+	User       string    `json:"user"`
+	Reason     string    `json:"reason"`
+	Repo       string    `json:"repo"`
+	PullNumber int       `json:"pull_number"`
+	HeadSha    string    `json:"head_sha"`
+	CommentUrl string    `json:"comment_url"`
+	Time       time.Time `json:"time"`
+}
+
+// getOverrideReason returns the text following `/check-enforcer override`, with
+// whitespace and newlines collapsed. The command itself is parsed from a sanitized
+// comment, which strips punctuation, so the reason is read from the original text.
+func getOverrideReason(comment string) string {
+	matches := overrideReasonRegex.FindStringSubmatch(comment)
+	if matches == nil {
+		return ""
+	}
+	return strings.Join(strings.Fields(matches[1]), " ")
+}
+
+// OverrideDescriptionPrefix starts the description of every override status.
+const OverrideDescriptionPrefix = "Overridden by @"
+
+func newOverrideDescription(audit OverrideAudit) string {
+	return truncateDescription(fmt.Sprintf("%s%s: %s", OverrideDescriptionPrefix, audit.User, audit.Reason))
+}
+
+func isOverride(status StatusBody) bool {
+	return status.State == CommitStateSuccess && strings.HasPrefix(status.Description, OverrideDescriptionPrefix)
+}
+
+// overrideAuditMarker hides the audit record in the override comment so that it can
+// be collected from the pull request later. json.Marshal escapes '<' and '>', so the
+// reason cannot close the html comment early.
+func overrideAuditMarker(audit OverrideAudit) (string, error) {
+	data, err := json.Marshal(audit)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("<!-- check-enforcer:audit %s -->", string(data)), nil
+}
+
+func newOverrideComment(audit OverrideAudit) (string, error) {
+	marker, err := overrideAuditMarker(audit)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s\nCheck Enforcer was overridden by @%s for commit %s.\n\n> %s\n", marker, audit.User, audit.HeadSha, audit.Reason), nil
+}
+
+// writeAuditRecord logs the audit record and appends it to the audit log, if configured.
+func writeAuditRecord(log *Logger, audit OverrideAudit) error {
+	data, err := json.Marshal(audit)
+	if err != nil {
+		return err
+	}
+	log.Info(fmt.Sprintf("[audit] %s by @%s", audit.Action, audit.User), Fields{"audit": audit})
+
+	auditLog := os.Getenv(AuditLogKey)
+	if auditLog == "" {
+		return nil
+	}
+	f, err := os.OpenFile(auditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
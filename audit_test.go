@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetOverrideReason(t *testing.T) {
+	assert := assert.New(t)
+	for _, tc := range []struct {
+		Comment  string
+		Expected string
+	}{
+		{"/check-enforcer override", ""},
+		{"/check-enforcer override   ", ""},
+		{"/check-enforcer override docs only change", "docs only change"},
+		{"  /Check-Enforcer   OVERRIDE  Flaky test, see #123!  ", "Flaky test, see #123!"},
+		{"/check-enforcer override\nno pipelines\nfor this change", "no pipelines for this change"},
+		{"/check-enforcer overrides", ""},
+		{"/check-enforcer evaluate because", ""},
+	} {
+		assert.Equal(tc.Expected, getOverrideReason(tc.Comment), tc.Comment)
+	}
+}
+
+func TestOverrideDescription(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("Overridden by @octocat: docs only", newOverrideDescription(OverrideAudit{User: "octocat", Reason: "docs only"}))
+	assert.Len(newOverrideDescription(OverrideAudit{User: "octocat", Reason: strings.Repeat("a", 200)}), maxDescriptionLength)
+}
+
+var auditMarkerRegex = regexp.MustCompile(`<!-- check-enforcer:audit (.*) -->`)
+
+func TestOverrideAudit(t *testing.T) {
+	assert := assert.New(t)
+	payloads, err := getPayloads()
+	assert.NoError(err)
+
+	auditLog := filepath.Join(t.TempDir(), "audit.jsonl")
+	if previous, ok := os.LookupEnv(AuditLogKey); ok {
+		defer os.Setenv(AuditLogKey, previous)
+	} else {
+		defer os.Unsetenv(AuditLogKey)
+	}
+	os.Setenv(AuditLogKey, auditLog)
+
+	reasons := []string{"docs only change", "flaky test --> see <#123>"}
+	for _, reason := range reasons {
+		var status StatusBody
+		var comment string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			switch {
+			case isConfigRequest(req):
+				w.WriteHeader(http.StatusNotFound)
+			case strings.HasSuffix(req.URL.Path, "/permission"):
+				w.Write([]byte(`{"permission": "write", "role_name": "write"}`))
+			case req.URL.Path == "/repos/Codertocat/Hello-World/pulls/1":
+				w.Write(payloads.PullRequestResponse)
+			case req.URL.Path == "/repos/octocat/Hello-World/statuses/6dcb09b5b57875f334f61aebed695e2e4193db5e" && req.Method == "POST":
+				status = getStatusBody(assert, req)
+				w.Write(payloads.StatusResponse)
+			case req.URL.Path == "/repos/Codertocat/Hello-World/issues/1/comments" && req.Method == "POST":
+				data, err := ioutil.ReadAll(req.Body)
+				assert.NoError(err)
+				body := IssueCommentBody{}
+				assert.NoError(json.Unmarshal(data, &body))
+				comment = body.Body
+				w.Write(payloads.NewCommentResponse)
+			default:
+				assert.Fail(fmt.Sprintf("Unexpected %s request to '%s'", req.Method, req.URL.String()))
+			}
+		}))
+		defer server.Close()
+
+		gh, err := NewGithubClient(server.URL, "", AzurePipelinesAppName)
+		assert.NoError(err)
+
+		event := strings.ReplaceAll(string(payloads.IssueCommentEvent), "You are totally right! I'll get this fixed right away.", "/check-enforcer override "+reason)
+		assert.NoError(handleEvent(gh, []byte(event)))
+
+		assert.Equal(CommitStateSuccess, status.State)
+		assert.Equal("Overridden by @Codertocat: "+reason, status.Description)
+
+		// The marker must stay hidden no matter what the reason contains
+		matches := auditMarkerRegex.FindAllStringSubmatch(comment, -1)
+		assert.Len(matches, 1, comment)
+		audit := OverrideAudit{}
+		assert.NoError(json.Unmarshal([]byte(matches[0][1]), &audit))
+		assert.Equal("override", audit.Action)
+		assert.Equal("Codertocat", audit.User)
+		assert.Equal(reason, audit.Reason)
+		assert.Equal("Codertocat/Hello-World", audit.Repo)
+		assert.Equal(1347, audit.PullNumber)
+		assert.Equal("6dcb09b5b57875f334f61aebed695e2e4193db5e", audit.HeadSha)
+		assert.False(audit.Time.IsZero())
+	}
+
+	data, err := ioutil.ReadFile(auditLog)
+	assert.NoError(err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	assert.Len(lines, len(reasons))
+	for i, line := range lines {
+		audit := OverrideAudit{}
+		assert.NoError(json.Unmarshal([]byte(line), &audit))
+		assert.Equal(reasons[i], audit.Reason)
+	}
+}
+
+func TestCommentTemplatesRequireOverrideReason(t *testing.T) {
+	assert := assert.New(t)
+	paths, err := filepath.Glob("./comments/*.txt")
+	assert.NoError(err)
+	assert.NotEmpty(paths)
+
+	override := regexp.MustCompile("/check-enforcer override[^`]*`")
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		assert.NoError(err)
+		for _, command := range override.FindAllString(string(data), -1) {
+			assert.Equal("/check-enforcer override <reason>`", command, path)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const GithubAppIdKey = "GITHUB_APP_ID"
+const GithubAppPrivateKeyKey = "GITHUB_APP_PRIVATE_KEY"
+const GithubAppPrivateKeyPathKey = "GITHUB_APP_PRIVATE_KEY_PATH"
+const GithubAppInstallationIdKey = "GITHUB_APP_INSTALLATION_ID"
+
+// Installation tokens are refreshed this long before they expire so that a token
+// cannot expire between being handed out and being used.
+const tokenRefreshMargin = 5 * time.Minute
+
+// Authenticator provides the Authorization header value for github API requests.
+type Authenticator interface {
+	GetAuthorization() (string, error)
+}
+
+// TokenAuthenticator authenticates with a static token, e.g. GITHUB_TOKEN or a PAT.
+type TokenAuthenticator struct {
+	token string
+}
+
+func NewTokenAuthenticator(token string) *TokenAuthenticator {
+	return &TokenAuthenticator{token: token}
+}
+
+func (a *TokenAuthenticator) GetAuthorization() (string, error) {
+	return fmt.Sprintf("token %s", a.token), nil
+}
+
+// AppCredentials signs JWTs for a github app. A single app can be installed in many
+// organizations, so installation authenticators are created and cached per installation.
+// https://docs.github.com/en/apps/creating-github-apps/authenticating-with-a-github-app/authenticating-as-a-github-app-installation
+type AppCredentials struct {
+	appId         string
+	privateKey    *rsa.PrivateKey
+	baseUrl       string
+	client        *http.Client
+	now           func() time.Time
+	mutex         sync.Mutex
+	installations map[int]*AppAuthenticator
+}
+
+func NewAppCredentials(baseUrl string, appId string, privateKeyPem []byte) (*AppCredentials, error) {
+	if appId == "" {
+		return nil, errors.New("A github app id is required")
+	}
+	key, err := ParsePrivateKey(privateKeyPem)
+	if err != nil {
+		return nil, err
+	}
+	return &AppCredentials{
+		appId:         appId,
+		privateKey:    key,
+		baseUrl:       strings.TrimSuffix(baseUrl, "/"),
+		client:        &http.Client{},
+		now:           time.Now,
+		installations: map[int]*AppAuthenticator{},
+	}, nil
+}
+
+// ParsePrivateKey parses a PEM encoded RSA private key in PKCS#1 format, as downloaded
+// from the github app settings page, or PKCS#8 format.
+func ParsePrivateKey(privateKeyPem []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(privateKeyPem)
+	if block == nil {
+		return nil, errors.New("Github app private key is not PEM encoded")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse github app private key: %w", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("Github app private key is not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+// ForInstallation returns the cached authenticator for an installation of the app.
+func (c *AppCredentials) ForInstallation(installationId int) *AppAuthenticator {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if auth, ok := c.installations[installationId]; ok {
+		return auth
+	}
+	auth := &AppAuthenticator{credentials: c, installationId: installationId}
+	c.installations[installationId] = auth
+	return auth
+}
+
+// CreateJWT creates a JWT signed with the app private key, in the format:
+//
+//	base64url(header).base64url(claims).base64url(RS256 signature)
+func (c *AppCredentials) CreateJWT() (string, error) {
+	now := c.now()
+	header := map[string]string{"alg": "RS256", "typ": "JWT"}
+	claims := map[string]interface{}{
+		// Backdate the token to allow for clock drift with github.
+		"iat": now.Add(-time.Minute).Unix(),
+		// Github allows a maximum lifetime of 10 minutes.
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": c.appId,
+	}
+
+	headerJson, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	claimsJson, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(headerJson) + "." + base64.RawURLEncoding.EncodeToString(claimsJson)
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, c.privateKey, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// AppAuthenticator authenticates as a github app installation. The installation access
+// token is cached until shortly before it expires and then exchanged for a new one.
+type AppAuthenticator struct {
+	credentials    *AppCredentials
+	installationId int
+	mutex          sync.Mutex
+	token          string
+	expiresAt      time.Time
+}
+
+type InstallationToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (a *AppAuthenticator) GetAuthorization() (string, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.token == "" || a.credentials.now().Add(tokenRefreshMargin).After(a.expiresAt) {
+		token, err := a.createInstallationToken()
+		if err != nil {
+			return "", err
+		}
+		a.token = token.Token
+		a.expiresAt = token.ExpiresAt
+	}
+
+	return fmt.Sprintf("token %s", a.token), nil
+}
+
+func (a *AppAuthenticator) createInstallationToken() (InstallationToken, error) {
+	jwt, err := a.credentials.CreateJWT()
+	if err != nil {
+		return InstallationToken{}, err
+	}
+
+	target := fmt.Sprintf("%s/app/installations/%d/access_tokens", a.credentials.baseUrl, a.installationId)
+	req, err := http.NewRequest("POST", target, nil)
+	if err != nil {
+		return InstallationToken{}, err
+	}
+	req.Header.Add("Accept", "application/vnd.github.v3+json")
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", jwt))
+
+	logger.Info("[github] request", Fields{"method": "POST", "url": target})
+	resp, err := a.credentials.client.Do(req)
+	if baseUrl, err := url.Parse(a.credentials.baseUrl); err == nil {
+		observeApiRequest(baseUrl.Path, req, resp)
+	}
+	if err != nil {
+		return InstallationToken{}, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return InstallationToken{}, err
+	}
+	if resp.StatusCode != http.StatusCreated {
+		logger.Error(fmt.Sprintf("[github] POST %s returned %d", target, resp.StatusCode),
+			Fields{"status": resp.StatusCode, "response": string(data)})
+		return InstallationToken{}, fmt.Errorf("Unable to create installation token for installation %d: %w",
+			a.installationId, &HttpError{StatusCode: resp.StatusCode})
+	}
+
+	token := InstallationToken{}
+	if err = json.Unmarshal(data, &token); err != nil {
+		return InstallationToken{}, err
+	}
+	if token.Token == "" {
+		return InstallationToken{}, errors.New("Installation token response did not contain a token")
+	}
+
+	logger.Info(fmt.Sprintf("Created installation token for installation %d, expires at %s",
+		a.installationId, token.ExpiresAt.Format(time.RFC3339)))
+	return token, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestPrivateKey(assert *assert.Assertions) (*rsa.PrivateKey, []byte) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(err)
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return key, keyPem
+}
+
+// verifyJWT checks the JWT signature against the public key and returns the decoded claims.
+func verifyJWT(assert *assert.Assertions, key *rsa.PublicKey, jwt string) map[string]interface{} {
+	parts := strings.Split(jwt, ".")
+	assert.Len(parts, 3)
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	assert.NoError(err)
+	assert.NoError(rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature))
+
+	claimsJson, err := base64.RawURLEncoding.DecodeString(parts[1])
+	assert.NoError(err)
+	claims := map[string]interface{}{}
+	assert.NoError(json.Unmarshal(claimsJson, &claims))
+	return claims
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	assert := assert.New(t)
+	key, pkcs1Pem := newTestPrivateKey(assert)
+
+	parsed, err := ParsePrivateKey(pkcs1Pem)
+	assert.NoError(err)
+	assert.True(key.Equal(parsed))
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.NoError(err)
+	parsed, err = ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}))
+	assert.NoError(err)
+	assert.True(key.Equal(parsed))
+
+	_, err = ParsePrivateKey([]byte("not a key"))
+	assert.Error(err)
+}
+
+func TestAppAuthenticator(t *testing.T) {
+	assert := assert.New(t)
+	key, keyPem := newTestPrivateKey(assert)
+	now := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	exchanges := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/app/installations/42/access_tokens" && req.Method == "POST" {
+			exchanges++
+			assert.True(strings.HasPrefix(req.Header.Get("Authorization"), "Bearer "))
+			claims := verifyJWT(assert, &key.PublicKey, strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "))
+			assert.Equal("1234", claims["iss"])
+			assert.Equal(float64(now.Add(-time.Minute).Unix()), claims["iat"])
+			assert.Equal(float64(now.Add(9*time.Minute).Unix()), claims["exp"])
+
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(InstallationToken{
+				Token:     fmt.Sprintf("installation-token-%d", exchanges),
+				ExpiresAt: now.Add(time.Hour),
+			})
+		} else if req.URL.Path == "/repos/test/test/pulls/1" && req.Method == "GET" {
+			assert.Equal(fmt.Sprintf("token installation-token-%d", exchanges), req.Header.Get("Authorization"))
+			w.Write([]byte(`{"number": 1}`))
+		} else {
+			assert.Fail(fmt.Sprintf("Unexpected %s request to '%s'", req.Method, req.URL.String()))
+		}
+	}))
+	defer server.Close()
+
+	credentials, err := NewAppCredentials(server.URL+"/", "1234", keyPem)
+	assert.NoError(err)
+	credentials.now = func() time.Time { return now }
+	auth := credentials.ForInstallation(42)
+	assert.Same(auth, credentials.ForInstallation(42))
+
+	gh, err := NewGithubClientWithAuth(server.URL, auth)
+	assert.NoError(err)
+
+	// The first request exchanges a JWT for an installation token, later requests reuse it.
+	for i := 0; i < 3; i++ {
+		pr, err := gh.GetPullRequest(server.URL + "/repos/test/test/pulls/1")
+		assert.NoError(err)
+		assert.Equal(1, pr.Number)
+	}
+	assert.Equal(1, exchanges)
+
+	// The token is refreshed when it is close to expiring.
+	now = now.Add(time.Hour - tokenRefreshMargin + time.Second)
+	_, err = gh.GetPullRequest(server.URL + "/repos/test/test/pulls/1")
+	assert.NoError(err)
+	assert.Equal(2, exchanges)
+}
+
+func TestAppAuthenticatorError(t *testing.T) {
+	assert := assert.New(t)
+	_, keyPem := newTestPrivateKey(assert)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	credentials, err := NewAppCredentials(server.URL, "1234", keyPem)
+	assert.NoError(err)
+	_, err = credentials.ForInstallation(1).GetAuthorization()
+	assert.True(IsNotFound(err))
+
+	_, err = NewAppCredentials(server.URL, "", keyPem)
+	assert.Error(err)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Repository permission levels, ordered from least to most privileged.
+// https://docs.github.com/en/organizations/managing-user-access-to-your-organizations-repositories/managing-repository-roles/repository-roles-for-an-organization
+var permissionLevels = []string{"none", "read", "triage", "write", "maintain", "admin"}
+
+var codeownersPaths = []string{".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS"}
+
+// OverrideConfig controls who can run `/check-enforcer override`. The commenter must
+// have at least Permission on the repository. If any of Users, Teams or Codeowners
+// are set, the commenter must also be in one of those allowlists.
+type OverrideConfig struct {
+	Permission string   `yaml:"permission"`
+	Users      []string `yaml:"users"`
+	// Teams are in the format "<org>/<team slug>". Checking team membership requires
+	// a token with read:org scope, which GITHUB_TOKEN in github actions does not have.
+	Teams []string `yaml:"teams"`
+	// Codeowners allows any user or team member listed in the CODEOWNERS file.
+	Codeowners bool `yaml:"codeowners"`
+}
+
+func (o OverrideConfig) validate() error {
+	if getPermissionLevel(o.Permission) < 0 {
+		return fmt.Errorf("invalid 'override.permission' '%s', expected one of %s", o.Permission, strings.Join(permissionLevels, ", "))
+	}
+	for _, team := range o.Teams {
+		if parts := strings.Split(team, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("invalid 'override.teams' entry '%s', expected '<org>/<team>'", team)
+		}
+	}
+	return nil
+}
+
+func (o OverrideConfig) hasAllowlist() bool {
+	return len(o.Users) > 0 || len(o.Teams) > 0 || o.Codeowners
+}
+
+func getPermissionLevel(permission string) int {
+	for i, level := range permissionLevels {
+		if level == permission {
+			return i
+		}
+	}
+	return -1
+}
+
+type CollaboratorPermission struct {
+	// Permission is the legacy permission, which maps triage to read and maintain to write.
+	Permission string `json:"permission"`
+	RoleName   string `json:"role_name"`
+}
+
+func (p CollaboratorPermission) getLevel() int {
+	if level := getPermissionLevel(p.RoleName); level >= 0 {
+		return level
+	}
+	return getPermissionLevel(p.Permission)
+}
+
+// authorizeOverride returns whether user may override check enforcer, and if not, why.
+func authorizeOverride(gh *GithubClient, repo Repo, user string) (bool, string, error) {
+	policy := gh.Config.Override
+
+	permission, err := gh.GetCollaboratorPermission(repo.GetCollaboratorPermissionUrl(user))
+	if IsNotFound(err) {
+		// Users that are not collaborators are not found, rather than having no permission
+		permission, err = CollaboratorPermission{Permission: "none"}, nil
+	}
+	if err != nil {
+		return false, "", err
+	}
+	gh.Log.Info(fmt.Sprintf("User '%s' has '%s' permission (role '%s').", user, permission.Permission, permission.RoleName))
+	if permission.getLevel() < getPermissionLevel(policy.Permission) {
+		return false, fmt.Sprintf("Overrides require '%s' permission on this repository.", policy.Permission), nil
+	}
+
+	if !policy.hasAllowlist() {
+		return true, "", nil
+	}
+
+	users := append([]string{}, policy.Users...)
+	teams := append([]string{}, policy.Teams...)
+	if policy.Codeowners {
+		ownerUsers, ownerTeams, err := getCodeowners(gh, repo)
+		if err != nil {
+			return false, "", err
+		}
+		users = append(users, ownerUsers...)
+		teams = append(teams, ownerTeams...)
+	}
+
+	for _, allowed := range users {
+		if strings.EqualFold(allowed, user) {
+			return true, "", nil
+		}
+	}
+	for _, team := range teams {
+		member, err := gh.IsTeamMember(team, user)
+		if err != nil {
+			return false, "", err
+		}
+		if member {
+			return true, "", nil
+		}
+	}
+
+	allowlist := []string{}
+	if len(policy.Users) > 0 || len(policy.Teams) > 0 {
+		allowlist = append(allowlist, "the users and teams listed in the check enforcer config")
+	}
+	if policy.Codeowners {
+		allowlist = append(allowlist, "the CODEOWNERS file")
+	}
+	return false, fmt.Sprintf("Overrides are limited to %s.", strings.Join(allowlist, " and ")), nil
+}
+
+// getCodeowners returns the users and teams listed as owners in the first CODEOWNERS
+// file found in the repository.
+func getCodeowners(gh *GithubClient, repo Repo) ([]string, []string, error) {
+	for _, path := range codeownersPaths {
+		data, err := gh.GetFileContents(repo.GetContentsUrl(path))
+		if IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		users, teams := parseCodeowners(string(data))
+		return users, teams, nil
+	}
+	gh.Log.Info("No CODEOWNERS file found.")
+	return []string{}, []string{}, nil
+}
+
+// parseCodeowners parses owners from lines in the format:
+//
+//	/sdk/storage/ @user @org/team
+func parseCodeowners(codeowners string) ([]string, []string) {
+	users := []string{}
+	teams := []string{}
+	seen := map[string]bool{}
+
+	for _, line := range strings.Split(codeowners, "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		for _, owner := range fields[1:] {
+			if !strings.HasPrefix(owner, "@") || seen[strings.ToLower(owner)] {
+				continue
+			}
+			seen[strings.ToLower(owner)] = true
+			if strings.Contains(owner, "/") {
+				teams = append(teams, strings.TrimPrefix(owner, "@"))
+			} else {
+				users = append(users, strings.TrimPrefix(owner, "@"))
+			}
+		}
+	}
+
+	return users, teams
+}
+
+func (gh *GithubClient) GetCollaboratorPermission(permissionUrl string) (CollaboratorPermission, error) {
+	target, err := gh.getUrl(permissionUrl)
+	if err != nil {
+		return CollaboratorPermission{}, err
+	}
+
+	req, err := http.NewRequest("GET", target.String(), nil)
+	if err != nil {
+		return CollaboratorPermission{}, err
+	}
+
+	gh.setHeaders(req)
+
+	data, err := gh.request(req)
+	if err != nil {
+		return CollaboratorPermission{}, err
+	}
+
+	permission := CollaboratorPermission{}
+	if err = json.Unmarshal(data, &permission); err != nil {
+		return CollaboratorPermission{}, err
+	}
+
+	return permission, nil
+}
+
+// IsTeamMember returns whether user is an active member of team, in the format "<org>/<team slug>".
+func (gh *GithubClient) IsTeamMember(team string, user string) (bool, error) {
+	parts := strings.SplitN(team, "/", 2)
+	if len(parts) != 2 {
+		return false, fmt.Errorf("Invalid team '%s', expected '<org>/<team>'", team)
+	}
+
+	target := gh.BaseUrl
+	target.Path = strings.TrimSuffix(target.Path, "/") + fmt.Sprintf("/orgs/%s/teams/%s/memberships/%s", parts[0], parts[1], user)
+
+	req, err := http.NewRequest("GET", target.String(), nil)
+	if err != nil {
+		return false, err
+	}
+
+	gh.setHeaders(req)
+
+	data, err := gh.request(req)
+	if IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	membership := struct {
+		State string `json:"state"`
+	}{}
+	if err = json.Unmarshal(data, &membership); err != nil {
+		return false, err
+	}
+
+	return membership.State == "active", nil
+}
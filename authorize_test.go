@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCodeowners(t *testing.T) {
+	assert := assert.New(t)
+	users, teams := parseCodeowners(`
+# Catch all
+* @azure-sdk-owner
+
+/sdk/storage/ @StorageOwner @Azure/azure-sdk-storage # storage team
+/sdk/core/    @azure-sdk-owner @Azure/azure-sdk-core
+/sdk/orphan/
+`)
+	assert.Equal([]string{"azure-sdk-owner", "StorageOwner"}, users)
+	assert.Equal([]string{"Azure/azure-sdk-storage", "Azure/azure-sdk-core"}, teams)
+}
+
+func TestOverrideConfig(t *testing.T) {
+	assert := assert.New(t)
+	defaults := NewDefaultConfig(AzurePipelinesAppName)
+
+	config, err := ParseConfig([]byte("version: 1\noverride:\n  permission: maintain\n  teams: [Azure/azure-sdk-eng]"), defaults)
+	assert.NoError(err)
+	assert.Equal("maintain", config.Override.Permission)
+	assert.Equal("write", defaults.Override.Permission)
+
+	_, err = ParseConfig([]byte("version: 1\noverride:\n  permission: owner"), defaults)
+	assert.Error(err)
+	_, err = ParseConfig([]byte("version: 1\noverride:\n  teams: [azure-sdk-eng]"), defaults)
+	assert.Error(err)
+}
+
+type TestOverrideCase struct {
+	Description     string
+	Config          string
+	Permission      string
+	Codeowners      string
+	Teams           []string
+	ShouldOverride  bool
+	ExpectedComment string
+}
+
+func TestOverrideAuthorization(t *testing.T) {
+	assert := assert.New(t)
+	payloads, err := getPayloads()
+	assert.NoError(err)
+
+	for _, tc := range []TestOverrideCase{
+		{"write permission", "version: 1", "write", "", nil, true, ""},
+		{"admin permission", "version: 1", "admin", "", nil, true, ""},
+		{"read permission", "version: 1", "read", "", nil, false, "Overrides require 'write' permission"},
+		{"triage permission", "version: 1", "triage", "", nil, false, "Overrides require 'write' permission"},
+		{"not a collaborator", "version: 1", "", "", nil, false, "Overrides require 'write' permission"},
+		{"maintain required", "version: 1\noverride:\n  permission: maintain", "write", "", nil, false, "Overrides require 'maintain' permission"},
+		{"read permission allowed", "version: 1\noverride:\n  permission: read", "read", "", nil, true, ""},
+		{"user allowlist", "version: 1\noverride:\n  users: [codertocat]", "write", "", nil, true, ""},
+		{"user not in allowlist", "version: 1\noverride:\n  users: [octocat]", "write", "", nil, false, "users and teams listed"},
+		{"user allowlist without permission", "version: 1\noverride:\n  users: [Codertocat]", "read", "", nil, false, "Overrides require 'write' permission"},
+		{"team member", "version: 1\noverride:\n  teams: [Azure/azure-sdk-eng]", "write", "", []string{"Azure/azure-sdk-eng"}, true, ""},
+		{"not team member", "version: 1\noverride:\n  teams: [Azure/azure-sdk-eng]", "write", "", nil, false, "users and teams listed"},
+		{"codeowner", "version: 1\noverride:\n  codeowners: true", "write", "* @Codertocat", nil, true, ""},
+		{"codeowner team member", "version: 1\noverride:\n  codeowners: true", "write", "/sdk/ @Azure/azure-sdk-storage", []string{"Azure/azure-sdk-storage"}, true, ""},
+		{"not codeowner", "version: 1\noverride:\n  codeowners: true", "write", "* @octocat", nil, false, "the CODEOWNERS file"},
+		{"no codeowners file", "version: 1\noverride:\n  codeowners: true", "write", "", nil, false, "the CODEOWNERS file"},
+	} {
+		postedStatus := false
+		postedComment := ""
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			switch {
+			case isConfigRequest(req):
+				w.Write(newConfigResponse(tc.Config))
+			case req.URL.Path == "/repos/Codertocat/Hello-World/collaborators/Codertocat/permission" && tc.Permission == "":
+				w.WriteHeader(http.StatusNotFound)
+			case req.URL.Path == "/repos/Codertocat/Hello-World/collaborators/Codertocat/permission":
+				w.Write([]byte(fmt.Sprintf(`{"permission": "%s", "role_name": "%s"}`, tc.Permission, tc.Permission)))
+			case req.URL.Path == "/repos/Codertocat/Hello-World/contents/.github/CODEOWNERS" && tc.Codeowners != "":
+				body, _ := json.Marshal(FileContent{Encoding: "base64", Content: base64.StdEncoding.EncodeToString([]byte(tc.Codeowners))})
+				w.Write(body)
+			case strings.HasPrefix(req.URL.Path, "/repos/Codertocat/Hello-World/contents/"):
+				w.WriteHeader(http.StatusNotFound)
+			case strings.HasPrefix(req.URL.Path, "/orgs/"):
+				for _, team := range tc.Teams {
+					parts := strings.Split(team, "/")
+					if req.URL.Path == fmt.Sprintf("/orgs/%s/teams/%s/memberships/Codertocat", parts[0], parts[1]) {
+						w.Write([]byte(`{"state": "active", "role": "member"}`))
+						return
+					}
+				}
+				w.WriteHeader(http.StatusNotFound)
+			case req.URL.Path == "/repos/Codertocat/Hello-World/pulls/1":
+				w.Write(payloads.PullRequestResponse)
+			case req.URL.Path == "/repos/octocat/Hello-World/statuses/6dcb09b5b57875f334f61aebed695e2e4193db5e" && req.Method == "POST":
+				postedStatus = true
+				assert.Equal(CommitStateSuccess, getStatusBody(assert, req).State, tc.Description)
+				w.Write(payloads.StatusResponse)
+			case req.URL.Path == "/repos/Codertocat/Hello-World/issues/1/comments" && req.Method == "POST":
+				data, err := ioutil.ReadAll(req.Body)
+				assert.NoError(err)
+				body := IssueCommentBody{}
+				assert.NoError(json.Unmarshal(data, &body))
+				postedComment = body.Body
+				w.Write(payloads.NewCommentResponse)
+			default:
+				assert.Fail(fmt.Sprintf("%s: Unexpected %s request to '%s'", tc.Description, req.Method, req.URL.String()))
+			}
+		}))
+		defer server.Close()
+
+		gh, err := NewGithubClient(server.URL, "", AzurePipelinesAppName)
+		assert.NoError(err)
+
+		event := strings.ReplaceAll(string(payloads.IssueCommentEvent), "You are totally right! I'll get this fixed right away.", "/check-enforcer override docs only change")
+		err = handleEvent(gh, []byte(event))
+		assert.NoError(err, tc.Description)
+		assert.Equal(tc.ShouldOverride, postedStatus, tc.Description)
+		if tc.ShouldOverride {
+			assert.Contains(postedComment, "<!-- check-enforcer:audit ", tc.Description)
+		} else {
+			assert.Contains(postedComment, tc.ExpectedComment, tc.Description)
+		}
+	}
+}
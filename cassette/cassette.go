@@ -0,0 +1,267 @@
+// Package cassette records the http requests and responses of a client to a file, and
+// replays them offline, so that scenarios against the github API can be captured once
+// and run deterministically in CI.
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sync"
+)
+
+type Mode string
+
+const (
+	ModeRecord Mode = "record"
+	ModeReplay Mode = "replay"
+)
+
+// Redacted replaces secrets in recorded interactions.
+const Redacted = "REDACTED"
+
+// redactedHeaders are replaced in recorded responses. Request headers are not recorded.
+var redactedHeaders = []string{"Authorization", "Cookie", "Set-Cookie"}
+
+// tokenRegex matches github tokens: personal access tokens, oauth, app installation and
+// refresh tokens, fine-grained personal access tokens, and JWTs signed by github apps.
+var tokenRegex = regexp.MustCompile(`\b(gh[pousr]_[A-Za-z0-9]{20,}|github_pat_[A-Za-z0-9_]{20,}|v1\.[0-9a-f]{40}|eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+)`)
+
+// Cassette is the file format of recorded interactions, in the order they were made.
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+type Request struct {
+	Method string `json:"method"`
+	Url    string `json:"url"`
+	Body   string `json:"body,omitempty"`
+}
+
+type Response struct {
+	StatusCode int         `json:"status"`
+	Header     http.Header `json:"headers,omitempty"`
+	Body       string      `json:"body"`
+}
+
+// Recorder is an http.RoundTripper that records interactions through another transport,
+// or replays the interactions of a cassette. Replayed requests must be made in the
+// recorded order, with the same method, path, query and body. The host is not compared,
+// so a cassette recorded against api.github.com replays against any base url.
+type Recorder struct {
+	mutex     sync.Mutex
+	mode      Mode
+	path      string
+	transport http.RoundTripper
+	cassette  Cassette
+	next      int
+	err       error
+	// MatchBody compares a recorded request body with the body of a replayed request.
+	// By default json bodies are compared by value and other bodies byte for byte.
+	MatchBody func(recorded string, actual string) bool
+}
+
+// NewRecorder records the interactions made through transport, or the default transport
+// if it is nil. The cassette is written to path by Close.
+func NewRecorder(path string, transport http.RoundTripper) *Recorder {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &Recorder{mode: ModeRecord, path: path, transport: transport, MatchBody: MatchJsonBody}
+}
+
+// Load replays the interactions of the cassette at path.
+func Load(path string) (*Recorder, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &Recorder{mode: ModeReplay, path: path, MatchBody: MatchJsonBody}
+	if err = json.Unmarshal(data, &r.cassette); err != nil {
+		return nil, fmt.Errorf("Invalid cassette '%s': %w", path, err)
+	}
+	return r, nil
+}
+
+func (r *Recorder) Mode() Mode {
+	return r.mode
+}
+
+// Interactions returns the recorded interactions, or the interactions of the cassette
+// being replayed.
+func (r *Recorder) Interactions() []Interaction {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]Interaction{}, r.cassette.Interactions...)
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.mode == ModeReplay {
+		return r.replay(req, body)
+	}
+
+	resp, err := r.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.cassette.Interactions = append(r.cassette.Interactions, Interaction{
+		Request: Request{Method: req.Method, Url: Redact(req.URL.String()), Body: Redact(body)},
+		Response: Response{
+			StatusCode: resp.StatusCode,
+			Header:     redactHeader(resp.Header),
+			Body:       Redact(string(data)),
+		},
+	})
+	return resp, nil
+}
+
+func (r *Recorder) replay(req *http.Request, body string) (*http.Response, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.next >= len(r.cassette.Interactions) {
+		return nil, r.fail(fmt.Sprintf("Unexpected request %d '%s %s', the cassette has %d interaction(s)",
+			r.next+1, req.Method, req.URL.RequestURI(), len(r.cassette.Interactions)))
+	}
+
+	interaction := r.cassette.Interactions[r.next]
+	recorded := interaction.Request
+	recordedUri := recorded.Url
+	if u, err := req.URL.Parse(recorded.Url); err == nil {
+		recordedUri = u.RequestURI()
+	}
+	actualUri := Redact(req.URL.RequestURI())
+	if recorded.Method != req.Method || recordedUri != actualUri {
+		return nil, r.fail(fmt.Sprintf("Request %d is '%s %s', expected '%s %s'",
+			r.next+1, req.Method, actualUri, recorded.Method, recordedUri))
+	}
+	if !r.MatchBody(recorded.Body, Redact(body)) {
+		return nil, r.fail(fmt.Sprintf("Request %d '%s %s' has body:\n%s\nexpected:\n%s",
+			r.next+1, req.Method, req.URL.RequestURI(), body, recorded.Body))
+	}
+	r.next++
+
+	header := http.Header{}
+	for name, values := range interaction.Response.Header {
+		header[name] = append([]string{}, values...)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", interaction.Response.StatusCode, http.StatusText(interaction.Response.StatusCode)),
+		StatusCode:    interaction.Response.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader([]byte(interaction.Response.Body))),
+		ContentLength: int64(len(interaction.Response.Body)),
+		Request:       req,
+	}, nil
+}
+
+// fail keeps the first replay error, so that Close reports it even if the client
+// handled the request error.
+func (r *Recorder) fail(message string) error {
+	err := errors.New(fmt.Sprintf("cassette '%s': %s", r.path, message))
+	if r.err == nil {
+		r.err = err
+	}
+	return err
+}
+
+// Close writes the recorded cassette. When replaying, it returns the first mismatched
+// request, or an error if any interactions were not replayed.
+func (r *Recorder) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.mode == ModeRecord {
+		data, err := json.MarshalIndent(r.cassette, "", "  ")
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(r.path, append(data, '\n'), 0644)
+	}
+
+	if r.err != nil {
+		return r.err
+	}
+	if r.next < len(r.cassette.Interactions) {
+		missing := r.cassette.Interactions[r.next].Request
+		return errors.New(fmt.Sprintf("cassette '%s': %d of %d interaction(s) were not replayed, starting with '%s %s'",
+			r.path, len(r.cassette.Interactions)-r.next, len(r.cassette.Interactions), missing.Method, missing.Url))
+	}
+	return nil
+}
+
+// Redact replaces github tokens in text.
+func Redact(text string) string {
+	return tokenRegex.ReplaceAllString(text, Redacted)
+}
+
+func redactHeader(header http.Header) http.Header {
+	redacted := http.Header{}
+	for name, values := range header {
+		for _, value := range values {
+			redacted.Add(name, Redact(value))
+		}
+	}
+	for _, name := range redactedHeaders {
+		if redacted.Get(name) != "" {
+			redacted.Set(name, Redacted)
+		}
+	}
+	return redacted
+}
+
+// MatchJsonBody compares json bodies by value, so that formatting and key order do not
+// matter, and other bodies byte for byte.
+func MatchJsonBody(recorded string, actual string) bool {
+	if recorded == actual {
+		return true
+	}
+	var recordedValue, actualValue interface{}
+	if json.Unmarshal([]byte(recorded), &recordedValue) != nil || json.Unmarshal([]byte(actual), &actualValue) != nil {
+		return false
+	}
+	recordedJson, _ := json.Marshal(recordedValue)
+	actualJson, _ := json.Marshal(actualValue)
+	return bytes.Equal(recordedJson, actualJson)
+}
+
+// readRequestBody reads the request body without consuming it.
+func readRequestBody(req *http.Request) (string, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return "", nil
+	}
+	data, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return "", err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(data))
+	return string(data), nil
+}
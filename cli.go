@@ -0,0 +1,341 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// commands are run with the options and the arguments following the command name. A
+// first argument that is not a command is the path of a payload file to replay.
+var commands = map[string]func(options *Options, args []string) error{
+	"serve":     runServe,
+	"reconcile": runReconcile,
+	"evaluate":  runEvaluate,
+	"override":  runOverride,
+	"status":    runStatus,
+	"replay":    runReplay,
+	"simulate":  runSimulate,
+	"help":      runHelp,
+}
+
+// parseCommandFlags parses the flags of a command. Options can be passed before or
+// after the command name, so they are added to every command.
+func parseCommandFlags(flags *flag.FlagSet, options *Options, args []string) error {
+	flags.Usage = help
+	options.AddFlags(flags)
+	flags.Parse(args)
+	if err := options.setLogger(); err != nil {
+		return err
+	}
+	return options.openCassette()
+}
+
+// PullRequestFlags select a pull request for the evaluate, override and status commands.
+type PullRequestFlags struct {
+	Repo   string
+	Number int
+}
+
+func addPullRequestFlags(flags *flag.FlagSet) *PullRequestFlags {
+	f := &PullRequestFlags{}
+	flags.StringVar(&f.Repo, "repo", os.Getenv("GITHUB_REPOSITORY"), "Repository of the pull request, e.g. Azure/azure-sdk-for-go. Defaults to GITHUB_REPOSITORY")
+	flags.IntVar(&f.Number, "pr", 0, "Pull request number")
+	return f
+}
+
+// getPullRequest creates a client for the repository, loads its config and fetches
+// the pull request.
+func (f *PullRequestFlags) getPullRequest(options *Options, event string) (*GithubClient, PullRequest, error) {
+	if f.Repo == "" || f.Number <= 0 {
+		return nil, PullRequest{}, errors.New("Error: -repo and -pr are required.")
+	}
+
+	newClient, err := newClientFactory(options)
+	if err != nil {
+		return nil, PullRequest{}, err
+	}
+	gh, err := newClient(0)
+	if err != nil {
+		return nil, PullRequest{}, err
+	}
+	gh.Log = gh.Log.With(Fields{"event": event, "repo": f.Repo, "pr": f.Number})
+
+	repo, err := gh.GetRepo(f.Repo)
+	if err != nil {
+		return nil, PullRequest{}, err
+	}
+	if err = gh.LoadConfig(repo); err != nil {
+		return nil, PullRequest{}, err
+	}
+	target := CommitTarget{Repo: repo, PullNumber: f.Number}
+	pr, err := gh.GetPullRequest(target.GetPullUrl())
+	if err != nil {
+		return nil, PullRequest{}, err
+	}
+	return gh, pr, nil
+}
+
+func logDryRunSummary(gh *GithubClient) {
+	if gh.DryRun != nil {
+		gh.Log.Info(gh.DryRun.Summary(), Fields{"mutations": len(gh.DryRun.Mutations())})
+	}
+}
+
+func runServe(options *Options, args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", ":8080", "Address to listen on for webhook deliveries")
+	reconcileInterval := flags.Duration("reconcile-interval", 0, "How often to reconcile the open pull requests of -reconcile-repos")
+	reconcileRepoList := flags.String("reconcile-repos", "", "Comma separated repositories to reconcile, e.g. Azure/azure-sdk-for-go")
+	if err := parseCommandFlags(flags, options, args); err != nil {
+		return err
+	}
+	if options.cassette != nil {
+		return errors.New("Error: Cassettes are not supported in server mode.")
+	}
+
+	newClient, err := newClientFactory(options)
+	if err != nil {
+		return err
+	}
+	if *reconcileInterval > 0 && *reconcileRepoList != "" {
+		reconcileEvery(*reconcileInterval, strings.Split(*reconcileRepoList, ","), newClient)
+	}
+	return serve(*addr, os.Getenv(WebhookSecretKey), newClient)
+}
+
+func runReconcile(options *Options, args []string) error {
+	flags := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	if err := parseCommandFlags(flags, options, args); err != nil {
+		return err
+	}
+
+	repoNames := flags.Args()
+	if len(repoNames) == 0 && os.Getenv("GITHUB_REPOSITORY") != "" {
+		repoNames = []string{os.Getenv("GITHUB_REPOSITORY")}
+	}
+	if len(repoNames) == 0 {
+		return errors.New("Error: No repositories to reconcile, pass them as arguments or set GITHUB_REPOSITORY.")
+	}
+
+	newClient, err := newClientFactory(options)
+	if err != nil {
+		return err
+	}
+	return reconcileRepos(repoNames, newClient)
+}
+
+// runEvaluate evaluates a pull request the same as a `/check-enforcer evaluate` comment.
+func runEvaluate(options *Options, args []string) error {
+	flags := flag.NewFlagSet("evaluate", flag.ExitOnError)
+	pull := addPullRequestFlags(flags)
+	if err := parseCommandFlags(flags, options, args); err != nil {
+		return err
+	}
+
+	gh, pr, err := pull.getPullRequest(options, "evaluate")
+	if err != nil {
+		return err
+	}
+	target := CommitTarget{Repo: pr.Base.Repo, PullNumber: pr.Number}
+	if err = evaluatePullRequest(gh, pr, target.GetCommentsUrl()); err != nil {
+		return err
+	}
+	logDryRunSummary(gh)
+	return nil
+}
+
+// runOverride overrides a pull request the same as a `/check-enforcer override` comment,
+// on behalf of the user the token belongs to. The user must be allowed to override check
+// enforcer by the repository config.
+func runOverride(options *Options, args []string) error {
+	flags := flag.NewFlagSet("override", flag.ExitOnError)
+	pull := addPullRequestFlags(flags)
+	reason := flags.String("reason", "", "Why check enforcer is overridden, recorded in the status, comment and audit log")
+	if err := parseCommandFlags(flags, options, args); err != nil {
+		return err
+	}
+
+	overrideReason := strings.Join(strings.Fields(*reason), " ")
+	if overrideReason == "" {
+		metrics.Inc(MetricOverrides, Labels{"result": "missing_reason"})
+		return errors.New("Error: Overrides require a reason, pass it with -reason.")
+	}
+
+	gh, pr, err := pull.getPullRequest(options, "override")
+	if err != nil {
+		return err
+	}
+
+	// Overrides are only made for the user of the token, so that the audit trail
+	// records who actually overrode check enforcer.
+	authenticated, err := gh.GetAuthenticatedUser()
+	if err != nil {
+		return fmt.Errorf("Unable to look up the user of the token, overrides require a user token: %w", err)
+	}
+	login := authenticated.Login
+
+	allowed, denial, err := authorizeOverride(gh, pr.Base.Repo, login)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		metrics.Inc(MetricOverrides, Labels{"result": "denied"})
+		return errors.New(fmt.Sprintf("Error: @%s is not allowed to override check enforcer. %s", login, denial))
+	}
+
+	target := CommitTarget{Repo: pr.Base.Repo, PullNumber: pr.Number}
+	audit := OverrideAudit{User: login, Reason: overrideReason, Repo: pull.Repo}
+	if err = applyOverride(gh, pr, audit, target.GetCommentsUrl()); err != nil {
+		return err
+	}
+	logDryRunSummary(gh)
+	return nil
+}
+
+// runStatus prints the status check enforcer published for a pull request next to the
+// status it would publish now. Nothing is written, comments are logged as in dry-run mode.
+func runStatus(options *Options, args []string) error {
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	pull := addPullRequestFlags(flags)
+	if err := parseCommandFlags(flags, options, args); err != nil {
+		return err
+	}
+
+	gh, pr, err := pull.getPullRequest(options, "status")
+	if err != nil {
+		return err
+	}
+	return printPullRequestStatus(os.Stdout, gh, pr)
+}
+
+func printPullRequestStatus(w io.Writer, gh *GithubClient, pr PullRequest) error {
+	if gh.DryRun == nil {
+		gh.DryRun = NewDryRun()
+	}
+	gh.Log = gh.Log.With(Fields{"sha": pr.Head.Sha})
+
+	target := CommitTarget{Repo: pr.Base.Repo, HeadSha: pr.Head.Sha, StatusesUrl: pr.StatusesUrl, PullNumber: pr.Number}
+	published, err := getPublishedStatus(gh, target)
+	if err != nil {
+		return err
+	}
+	checkSuites, err := gh.GetCheckSuiteStatuses(pr.GetCheckSuiteUrl())
+	if err != nil {
+		return err
+	}
+	status, evaluation, err := getStatusForCheckSuiteConclusions(gh, checkSuites, target)
+	if err != nil {
+		return err
+	}
+
+	report := strings.Builder{}
+	report.WriteString(fmt.Sprintf("Pull request  %s#%d (%s)\n", pr.Base.Repo.FullName, pr.Number, pr.Head.Sha))
+	if published == nil {
+		report.WriteString("Published     none\n")
+	} else {
+		report.WriteString(fmt.Sprintf("Published     %s: %s\n", published.State, published.Description))
+	}
+	report.WriteString(fmt.Sprintf("Evaluated     %s: %s\n", status.State, status.Description))
+	switch {
+	case published != nil && isOverride(*published):
+		report.WriteString("\nCheck enforcer was overridden for this commit.\n")
+	case !isPublished(gh.Config, published, status):
+		report.WriteString(fmt.Sprintf("\nThe published status is stale, run `evaluate -repo %s -pr %d` to update it.\n", pr.Base.Repo.FullName, pr.Number))
+	}
+	if len(evaluation.Trace) > 0 {
+		report.WriteString("\nTrace\n")
+		for _, line := range evaluation.Trace {
+			report.WriteString("  " + line + "\n")
+		}
+	}
+
+	_, err = io.WriteString(w, report.String())
+	return err
+}
+
+// runReplay handles a webhook payload file, as the github action does with the event
+// payload. The event type is detected from the payload unless -event is passed.
+func runReplay(options *Options, args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	eventType := flags.String("event", "", "Webhook event type of the payload, e.g. check_suite. Detected from the payload if not set")
+	if err := parseCommandFlags(flags, options, args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New("Error: No payload file to replay.")
+	}
+
+	newClient, err := newClientFactory(options)
+	if err != nil {
+		return err
+	}
+	payload, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	gh, err := newClient(GetInstallationId(payload))
+	if err != nil {
+		return err
+	}
+	// Timers are not set, so the no pipelines grace period is skipped. An action job
+	// handles a single event, and waiting out the grace period would keep the runner
+	// busy on every pull request. `/check-enforcer evaluate` reports missing pipelines.
+	event := *eventType
+	if event == "" {
+		event = os.Getenv("GITHUB_EVENT_NAME")
+	}
+	gh.Log = gh.Log.With(Fields{"event": event})
+
+	if *eventType != "" {
+		err = handleEventType(gh, *eventType, payload)
+	} else {
+		err = handleEvent(gh, payload)
+	}
+	if err != nil {
+		return err
+	}
+
+	logDryRunSummary(gh)
+	return nil
+}
+
+func runHelp(options *Options, args []string) error {
+	help()
+	return nil
+}
+
+// GetAuthenticatedUser fetches the user the token belongs to. Tokens of github app
+// installations do not belong to a user.
+func (gh *GithubClient) GetAuthenticatedUser() (User, error) {
+	target, err := gh.getUrl(strings.TrimSuffix(gh.BaseUrl.String(), "/") + "/user")
+	if err != nil {
+		return User{}, err
+	}
+
+	req, err := http.NewRequest("GET", target.String(), nil)
+	if err != nil {
+		return User{}, err
+	}
+
+	gh.setHeaders(req)
+
+	data, err := gh.request(req)
+	if err != nil {
+		return User{}, err
+	}
+
+	user := User{}
+	if err = json.Unmarshal(data, &user); err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
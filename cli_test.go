@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type TestCommandCase struct {
+	Description     string
+	Command         string
+	Args            []string
+	Permission      string
+	ExpectedState   CommitState
+	ExpectedComment string
+	ExpectedError   string
+}
+
+type CommandTestServer struct {
+	*httptest.Server
+	Permission    string
+	Published     StatusBody
+	PostedStatus  *StatusBody
+	PostedComment string
+}
+
+func NewCommandTestServer(assert *assert.Assertions, payloads Payloads) *CommandTestServer {
+	pr := PullRequest{}
+	assert.NoError(json.Unmarshal(payloads.PullRequestResponse, &pr))
+	commitPath := "/repos/octocat/Hello-World/commits/" + pr.Head.Sha
+
+	s := &CommandTestServer{Permission: "write"}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case isConfigRequest(req):
+			w.WriteHeader(http.StatusNotFound)
+		case req.URL.Path == "/repos/octocat/Hello-World":
+			json.NewEncoder(w).Encode(pr.Base.Repo)
+		case req.URL.Path == "/repos/octocat/Hello-World/pulls/1347":
+			w.Write(payloads.PullRequestResponse)
+		case req.URL.Path == commitPath+"/check-suites":
+			w.Write([]byte(`{"total_count": 1, "check_suites": [{"id": 1, "status": "completed", "conclusion": "failure", "latest_check_runs_count": 1, "app": {"name": "Azure Pipelines"}}]}`))
+		case req.URL.Path == commitPath+"/status":
+			json.NewEncoder(w).Encode(CombinedStatus{Sha: pr.Head.Sha, Statuses: []StatusBody{s.Published}})
+		case req.URL.Path == "/user":
+			w.Write([]byte(`{"login": "octocat"}`))
+		case req.URL.Path == "/repos/octocat/Hello-World/collaborators/octocat/permission":
+			w.Write([]byte(fmt.Sprintf(`{"permission": "%s"}`, s.Permission)))
+		case strings.Contains(req.URL.Path, "/statuses/") && req.Method == "POST":
+			status := getStatusBody(assert, req)
+			s.PostedStatus = &status
+			w.Write(payloads.StatusResponse)
+		case req.URL.Path == "/repos/octocat/Hello-World/issues/1347/comments" && req.Method == "POST":
+			data, err := ioutil.ReadAll(req.Body)
+			assert.NoError(err)
+			body := IssueCommentBody{}
+			assert.NoError(json.Unmarshal(data, &body))
+			s.PostedComment = body.Body
+			w.Write(payloads.NewCommentResponse)
+		default:
+			assert.Fail(fmt.Sprintf("Unexpected %s request to '%s'", req.Method, req.URL.String()))
+		}
+	}))
+	return s
+}
+
+func TestCommands(t *testing.T) {
+	assert := assert.New(t)
+	payloads, err := getPayloads()
+	assert.NoError(err)
+
+	for _, tc := range []TestCommandCase{
+		{"evaluate", "evaluate", []string{"--repo", "octocat/Hello-World", "--pr", "1347"}, "write", CommitStatePending, "", ""},
+		{"evaluate without pr", "evaluate", []string{"-repo", "octocat/Hello-World"}, "write", "", "", "-repo and -pr are required"},
+		{"override", "override", []string{"-repo", "octocat/Hello-World", "-pr", "1347", "-reason", "pipelines are down"}, "write", CommitStateSuccess, "overridden by @octocat", ""},
+		{"override as admin", "override", []string{"-repo", "octocat/Hello-World", "-pr", "1347", "-reason", "flaky"}, "admin", CommitStateSuccess, "overridden by @octocat", ""},
+		{"override without reason", "override", []string{"-repo", "octocat/Hello-World", "-pr", "1347"}, "write", "", "", "Overrides require a reason"},
+		{"override denied", "override", []string{"-repo", "octocat/Hello-World", "-pr", "1347", "-reason", "flaky"}, "read", "", "", "@octocat is not allowed to override check enforcer. Overrides require 'write' permission"},
+	} {
+		server := NewCommandTestServer(assert, payloads)
+		server.Permission = tc.Permission
+
+		options := NewDefaultOptions()
+		options.ApiUrl = server.URL
+		err := commands[tc.Command](options, tc.Args)
+		server.Close()
+
+		if tc.ExpectedError != "" {
+			assert.Error(err, tc.Description)
+			assert.Contains(fmt.Sprint(err), tc.ExpectedError, tc.Description)
+		} else {
+			assert.NoError(err, tc.Description)
+		}
+		if tc.ExpectedState == "" {
+			assert.Nil(server.PostedStatus, tc.Description)
+		} else if assert.NotNil(server.PostedStatus, tc.Description) {
+			assert.Equal(tc.ExpectedState, server.PostedStatus.State, tc.Description)
+		}
+		if tc.ExpectedComment == "" {
+			assert.Empty(server.PostedComment, tc.Description)
+		} else {
+			assert.Contains(server.PostedComment, tc.ExpectedComment, tc.Description)
+		}
+	}
+}
+
+func TestStatusCommand(t *testing.T) {
+	assert := assert.New(t)
+	payloads, err := getPayloads()
+	assert.NoError(err)
+	pr := PullRequest{}
+	assert.NoError(json.Unmarshal(payloads.PullRequestResponse, &pr))
+
+	server := NewCommandTestServer(assert, payloads)
+	defer server.Close()
+	server.Published = newSucceededBody(CommitStatusContext)
+
+	gh, err := NewGithubClient(server.URL, "", AzurePipelinesAppName)
+	assert.NoError(err)
+	out := bytes.Buffer{}
+	assert.NoError(printPullRequestStatus(&out, gh, pr))
+
+	report := out.String()
+	assert.Contains(report, "Pull request  octocat/Hello-World#1347 (6dcb09b5b57875f334f61aebed695e2e4193db5e)\n")
+	assert.Contains(report, "Published     success: All checks passed\n")
+	assert.Contains(report, "Evaluated     pending: Waiting for all checks to succeed\n")
+	assert.Contains(report, "The published status is stale, run `evaluate -repo octocat/Hello-World -pr 1347`")
+	assert.Contains(report, "\nTrace\n  Check suite conclusion for 'Azure Pipelines' is 'failure'.\n")
+	assert.Nil(server.PostedStatus, "The status command does not publish")
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigPath is the location of the check enforcer config file, relative to the root of the base repository.
+const ConfigPath = ".github/check-enforcer.yml"
+
+const (
+	FailureStatePending = "pending"
+	FailureStateFailure = "failure"
+)
+
+// ConfigVersion is the only config schema version currently supported.
+const ConfigVersion = 1
+
+const HelpCommentPath = "./comments/help.txt"
+const NoPipelinesCommentPath = "./comments/no_pipelines.txt"
+
+// Config holds the check enforcer policy for a repository. Any field not set in the
+// repository config file keeps the value from NewDefaultConfig.
+type Config struct {
+	Version       int                `yaml:"version"`
+	AppTargets    []string           `yaml:"apps"`
+	SkipBranches  []string           `yaml:"skip_branches"`
+	StatusContext string             `yaml:"status_context"`
+	Comments      CommentTemplates   `yaml:"comments"`
+	Conclusions   ConclusionOutcomes `yaml:"conclusions"`
+	// AppConclusions overrides Conclusions for the check suites and check runs of single apps.
+	AppConclusions map[string]ConclusionOutcomes `yaml:"app_conclusions"`
+	// NoPipelinesGrace is how long after a pull request is opened or updated to wait for
+	// check suites from the targeted apps before commenting that no pipelines were triggered.
+	NoPipelinesGrace time.Duration `yaml:"no_pipelines_grace"`
+	// MaxPendingAge is how long a check run can be queued or in progress before it is
+	// reported as stuck. Zero disables stuck check detection.
+	MaxPendingAge time.Duration `yaml:"max_pending_age"`
+	// FailureState is the commit state posted when a targeted check suite fails.
+	FailureState string `yaml:"failure_state"`
+	// CheckRuns maps app names to a policy for the check runs in that app's check suites.
+	CheckRuns map[string]CheckRunPolicy `yaml:"check_runs"`
+	// ExpectedChecks lists the check runs that must be posted when matching files are changed.
+	ExpectedChecks []ExpectedChecks `yaml:"expected_checks"`
+	// Override restricts who can run `/check-enforcer override`.
+	Override OverrideConfig `yaml:"override"`
+	// Output selects whether results are published as a commit status, a check run, or both.
+	Output       string `yaml:"output"`
+	CheckRunName string `yaml:"check_run_name"`
+}
+
+// CommentTemplates overrides the markdown posted as pull request comments. Empty values
+// fall back to the files in the ./comments directory.
+type CommentTemplates struct {
+	Help          string `yaml:"help"`
+	NoPipelines   string `yaml:"no_pipelines"`
+	MissingChecks string `yaml:"missing_checks"`
+	StuckChecks   string `yaml:"stuck_checks"`
+}
+
+// ConclusionOutcomes maps check suite and check run conclusions to how they count
+// towards the check enforcer status. Conclusions that are not mapped are pending.
+type ConclusionOutcomes map[CheckSuiteConclusion]Outcome
+
+var knownConclusions = []CheckSuiteConclusion{
+	CheckSuiteConclusionSuccess,
+	CheckSuiteConclusionFailure,
+	CheckSuiteConclusionNeutral,
+	CheckSuiteConclusionCancelled,
+	CheckSuiteConclusionTimedOut,
+	CheckSuiteConclusionActionRequired,
+	CheckSuiteConclusionStale,
+	CheckSuiteConclusionSkipped,
+}
+
+var knownOutcomes = []Outcome{OutcomePass, OutcomeFail, OutcomePending, OutcomeIgnore}
+
+func NewDefaultConfig(appTargets ...string) *Config {
+	return &Config{
+		Version:       ConfigVersion,
+		AppTargets:    appTargets,
+		SkipBranches:  []string{"main"},
+		StatusContext: CommitStatusContext,
+		Conclusions: ConclusionOutcomes{
+			CheckSuiteConclusionSuccess:        OutcomePass,
+			CheckSuiteConclusionFailure:        OutcomeFail,
+			CheckSuiteConclusionTimedOut:       OutcomeFail,
+			CheckSuiteConclusionNeutral:        OutcomePending,
+			CheckSuiteConclusionCancelled:      OutcomePending,
+			CheckSuiteConclusionActionRequired: OutcomePending,
+			CheckSuiteConclusionStale:          OutcomePending,
+			CheckSuiteConclusionSkipped:        OutcomePending,
+		},
+		FailureState:     FailureStatePending,
+		NoPipelinesGrace: 10 * time.Minute,
+		Override:         OverrideConfig{Permission: "write"},
+		Output:           OutputStatus,
+		CheckRunName:     "Check Enforcer",
+	}
+}
+
+// ParseConfig decodes a config file on top of the given defaults. Unknown keys are
+// rejected so that typos in the config file do not silently fall back to defaults.
+func ParseConfig(data []byte, defaults *Config) (*Config, error) {
+	config := *defaults
+	// Mappings in the config file are merged into the default conclusions, so decode
+	// into a copy to leave the defaults untouched.
+	config.Conclusions = ConclusionOutcomes{}
+	for conclusion, outcome := range defaults.Conclusions {
+		config.Conclusions[conclusion] = outcome
+	}
+	// An empty file is valid and means "use the defaults".
+	if len(bytes.TrimSpace(data)) == 0 {
+		return &config, nil
+	}
+
+	// The version is not defaulted, so that the schema of every config file is explicit.
+	config.Version = 0
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("Invalid config file '%s': %w", ConfigPath, err)
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("Invalid config file '%s': %w", ConfigPath, err)
+	}
+
+	return &config, nil
+}
+
+func (c *Config) Validate() error {
+	if c.Version == 0 {
+		return errors.New("'version' is required")
+	}
+	if c.Version != ConfigVersion {
+		return fmt.Errorf("unsupported version %d, expected %d", c.Version, ConfigVersion)
+	}
+	if len(c.AppTargets) == 0 {
+		return errors.New("'apps' must contain at least one github app name")
+	}
+	if c.StatusContext == "" {
+		return errors.New("'status_context' must not be empty")
+	}
+	for _, pattern := range c.SkipBranches {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid 'skip_branches' pattern '%s': %w", pattern, err)
+		}
+	}
+	for app, policy := range c.CheckRuns {
+		if !c.IsTargetApp(app) {
+			return fmt.Errorf("'check_runs' app '%s' is not listed in 'apps'", app)
+		}
+		for _, pattern := range append(append([]string{}, policy.Required...), policy.Ignore...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid 'check_runs' pattern '%s' for app '%s': %w", pattern, app, err)
+			}
+		}
+	}
+	for _, entry := range c.ExpectedChecks {
+		if err := entry.validate(); err != nil {
+			return err
+		}
+	}
+	if c.NoPipelinesGrace < 0 {
+		return errors.New("'no_pipelines_grace' must not be negative")
+	}
+	if c.MaxPendingAge < 0 {
+		return errors.New("'max_pending_age' must not be negative")
+	}
+	if c.FailureState != FailureStatePending && c.FailureState != FailureStateFailure {
+		return fmt.Errorf("invalid 'failure_state' '%s', expected %s or %s", c.FailureState, FailureStatePending, FailureStateFailure)
+	}
+	if c.Output != OutputStatus && c.Output != OutputCheckRun && c.Output != OutputBoth {
+		return fmt.Errorf("invalid 'output' '%s', expected one of %s, %s, %s", c.Output, OutputStatus, OutputCheckRun, OutputBoth)
+	}
+	if c.CheckRunName == "" {
+		return errors.New("'check_run_name' must not be empty")
+	}
+	if err := c.Override.validate(); err != nil {
+		return err
+	}
+	if err := c.Conclusions.validate("conclusions"); err != nil {
+		return err
+	}
+	passes := false
+	for _, outcome := range c.Conclusions {
+		passes = passes || outcome == OutcomePass
+	}
+	if !passes {
+		return errors.New("'conclusions' must map at least one conclusion to 'pass'")
+	}
+	for app, conclusions := range c.AppConclusions {
+		if !c.IsTargetApp(app) {
+			return fmt.Errorf("'app_conclusions' app '%s' is not listed in 'apps'", app)
+		}
+		if err := conclusions.validate(fmt.Sprintf("app_conclusions.%s", app)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsSkippedBranch returns whether check suites for a branch are never evaluated. Merge
+// queue branches are never skipped, as the merge queue waits for the required status.
+func (c *Config) IsSkippedBranch(branch string) bool {
+	if isMergeQueueBranch(branch) {
+		return false
+	}
+	for _, pattern := range c.SkipBranches {
+		if matched, _ := path.Match(pattern, branch); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Config) IsTargetApp(appName string) bool {
+	for _, app := range c.AppTargets {
+		if app == appName {
+			return true
+		}
+	}
+	return false
+}
+
+// GetConclusionOutcome returns how a conclusion of a check suite or check run from an
+// app counts towards the status. An empty conclusion means the check has not finished.
+func (c *Config) GetConclusionOutcome(app string, conclusion CheckSuiteConclusion) Outcome {
+	if conclusion == CheckSuiteConclusionEmpty {
+		return OutcomePending
+	}
+	if outcome, ok := c.AppConclusions[app][conclusion]; ok {
+		return outcome
+	}
+	if outcome, ok := c.Conclusions[conclusion]; ok {
+		return outcome
+	}
+	return OutcomePending
+}
+
+func (c *Config) GetHelpComment() (string, error) {
+	return getCommentText(c.Comments.Help, HelpCommentPath)
+}
+
+func (c *Config) GetNoPipelinesComment() (string, error) {
+	return getCommentText(c.Comments.NoPipelines, NoPipelinesCommentPath)
+}
+
+func (c *Config) GetMissingChecksComment() (string, error) {
+	return getCommentText(c.Comments.MissingChecks, MissingChecksCommentPath)
+}
+
+func (c *Config) GetStuckChecksComment() (string, error) {
+	return getCommentText(c.Comments.StuckChecks, StuckChecksCommentPath)
+}
+
+func getCommentText(template string, defaultPath string) (string, error) {
+	if template != "" {
+		return template, nil
+	}
+	text, err := ioutil.ReadFile(defaultPath)
+	if err != nil {
+		return "", err
+	}
+	return string(text), nil
+}
+
+func (o ConclusionOutcomes) validate(key string) error {
+	for conclusion, outcome := range o {
+		if !containsConclusion(knownConclusions, conclusion) {
+			return fmt.Errorf("unknown check suite conclusion '%s' in '%s'", conclusion, key)
+		}
+		if !containsOutcome(knownOutcomes, outcome) {
+			return fmt.Errorf("invalid outcome '%s' for conclusion '%s' in '%s', expected pass, fail, pending or ignore", outcome, conclusion, key)
+		}
+	}
+	return nil
+}
+
+func containsConclusion(conclusions []CheckSuiteConclusion, conclusion CheckSuiteConclusion) bool {
+	for _, c := range conclusions {
+		if c == conclusion {
+			return true
+		}
+	}
+	return false
+}
+
+func containsOutcome(outcomes []Outcome, outcome Outcome) bool {
+	for _, o := range outcomes {
+		if o == outcome {
+			return true
+		}
+	}
+	return false
+}
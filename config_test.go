@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseConfig(t *testing.T) {
+	assert := assert.New(t)
+	defaults := NewDefaultConfig(AzurePipelinesAppName, GithubActionsAppName)
+
+	config, err := ParseConfig([]byte(""), defaults)
+	assert.NoError(err)
+	assert.Equal(*defaults, *config)
+
+	config, err = ParseConfig([]byte(`
+version: 1
+apps: [Azure Pipelines]
+skip_branches: [main, release/*]
+status_context: custom-context
+comments:
+  help: custom help
+conclusions:
+  neutral: pass
+  timed_out: pending
+app_conclusions:
+  Azure Pipelines:
+    skipped: ignore
+`), defaults)
+	assert.NoError(err)
+	assert.Equal([]string{AzurePipelinesAppName}, config.AppTargets)
+	assert.Equal("custom-context", config.StatusContext)
+	assert.True(config.IsSkippedBranch("main"))
+	assert.True(config.IsSkippedBranch("release/1.0"))
+	assert.False(config.IsSkippedBranch("feature/foo"))
+	assert.Equal(OutcomePass, config.GetConclusionOutcome(GithubActionsAppName, CheckSuiteConclusionNeutral))
+	assert.Equal(OutcomePending, config.GetConclusionOutcome(GithubActionsAppName, CheckSuiteConclusionTimedOut))
+	assert.Equal(OutcomeFail, config.GetConclusionOutcome(GithubActionsAppName, CheckSuiteConclusionFailure))
+	assert.Equal(OutcomeIgnore, config.GetConclusionOutcome(AzurePipelinesAppName, CheckSuiteConclusionSkipped))
+	assert.Equal(OutcomePending, config.GetConclusionOutcome(GithubActionsAppName, CheckSuiteConclusionSkipped))
+	help, err := config.GetHelpComment()
+	assert.NoError(err)
+	assert.Equal("custom help", help)
+	noPipelines, err := config.GetNoPipelinesComment()
+	assert.NoError(err)
+	assert.Contains(noPipelines, "no Azure Pipelines or Github Actions have been triggered")
+
+	// Parsing must not modify the defaults
+	assert.Equal([]string{AzurePipelinesAppName, GithubActionsAppName}, defaults.AppTargets)
+	assert.Equal(CommitStatusContext, defaults.StatusContext)
+	assert.Equal(OutcomePending, defaults.GetConclusionOutcome(GithubActionsAppName, CheckSuiteConclusionNeutral))
+	assert.Equal(OutcomeFail, defaults.GetConclusionOutcome(GithubActionsAppName, CheckSuiteConclusionTimedOut))
+
+	for _, invalid := range []string{
+		"version: 2",
+		"apps: [Azure Pipelines]",
+		"version: 1\nunknown_key: true",
+		"version: 1\napps: []",
+		"version: 1\nstatus_context: ''",
+		"version: 1\nskip_branches: ['[']",
+		"version: 1\nconclusions:\n  passed: pass",
+		"version: 1\nconclusions:\n  success: succeeded",
+		"version: 1\nconclusions:\n  success: [pass]",
+		"version: 1\nconclusions:\n  success: pending",
+		"version: 1\napp_conclusions:\n  Unknown App:\n    skipped: ignore",
+		"version: 1\napp_conclusions:\n  Azure Pipelines:\n    skipped: skip",
+		"apps: not-a-list",
+	} {
+		_, err := ParseConfig([]byte(invalid), defaults)
+		assert.Error(err, invalid)
+	}
+}
+
+func newConfigResponse(config string) []byte {
+	body, _ := json.Marshal(FileContent{
+		Path:     ConfigPath,
+		Encoding: "base64",
+		Content:  base64.StdEncoding.EncodeToString([]byte(config)),
+	})
+	return body
+}
+
+type TestConfigCase struct {
+	Description      string
+	Config           string
+	Event            []byte
+	ShouldPostStatus bool
+	ExpectedState    CommitState
+	ExpectedContext  string
+}
+
+func TestCheckSuiteWithConfig(t *testing.T) {
+	assert := assert.New(t)
+	payloads, err := getPayloads()
+	assert.NoError(err)
+	failedEvent := []byte(strings.ReplaceAll(string(payloads.CheckSuiteEvent), `"conclusion": "success"`, `"conclusion": "failure"`))
+	releaseBranchEvent := []byte(strings.ReplaceAll(string(payloads.CheckSuiteEvent), `"head_branch": "changes"`, `"head_branch": "release/1.0"`))
+
+	for _, tc := range []TestConfigCase{
+		{"config app target", "version: 1\napps: [octocoders-linter]", payloads.CheckSuiteEvent, true, CommitStateSuccess, CommitStatusContext},
+		{"config app target no match", "version: 1\napps: [no-match]", payloads.CheckSuiteEvent, true, CommitStatePending, CommitStatusContext},
+		{"config status context", "version: 1\nstatus_context: custom", payloads.CheckSuiteEvent, true, CommitStateSuccess, "custom"},
+		{"config skip branch", "version: 1\nskip_branches: [release/*]", releaseBranchEvent, false, "", ""},
+		{"config skip branch default", "version: 1", releaseBranchEvent, true, CommitStateSuccess, CommitStatusContext},
+		{"config conclusion", "version: 1\nconclusions:\n  success: pending\n  neutral: pass", payloads.CheckSuiteEvent, true, CommitStatePending, CommitStatusContext},
+		{"config failure state pending", "version: 1\napps: [octocoders-linter]", failedEvent, true, CommitStatePending, CommitStatusContext},
+		{"config failure state failure", "version: 1\napps: [octocoders-linter]\nfailure_state: failure", failedEvent, true, CommitStateFailure, CommitStatusContext},
+	} {
+		var posted StatusBody
+		var postedStatus bool
+		checkSuite := NewCheckSuiteWebhook(tc.Event)
+		assert.NotEmpty(checkSuite)
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if isConfigRequest(req) {
+				assert.Contains(checkSuite.Repo.GetContentsUrl(ConfigPath), req.URL.Path, tc.Description)
+				w.Write(newConfigResponse(tc.Config))
+			} else if strings.Contains(checkSuite.GetStatusesUrl(), req.URL.String()) && req.Method == "POST" {
+				posted = getStatusBody(assert, req)
+				postedStatus = true
+				w.Write(payloads.StatusResponse)
+			} else {
+				assert.Fail(fmt.Sprintf("%s: Unexpected %s request to '%s'", tc.Description, req.Method, req.URL.String()))
+			}
+		}))
+		defer server.Close()
+
+		gh, err := NewGithubClient(server.URL, "", "octocoders-linter")
+		assert.NoError(err)
+		err = handleEvent(gh, tc.Event)
+		assert.NoError(err, tc.Description)
+		assert.Equal(tc.ShouldPostStatus, postedStatus, tc.Description)
+		assert.Equal(tc.ExpectedState, posted.State, tc.Description)
+		assert.Equal(tc.ExpectedContext, posted.Context, tc.Description)
+	}
+}
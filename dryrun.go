@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const DryRunKey = "CHECK_ENFORCER_DRY_RUN"
+
+// Mutation is a write request that was not sent to github in dry-run mode.
+type Mutation struct {
+	Method      string
+	Url         string
+	Body        string
+	Description string
+}
+
+// DryRun records the writes a run would have made instead of sending them, so that a
+// policy change can be tried on live pull requests. Reads are still sent to the API.
+type DryRun struct {
+	mutex     sync.Mutex
+	mutations []Mutation
+}
+
+func NewDryRun() *DryRun {
+	return &DryRun{}
+}
+
+// getDefaultDryRun returns whether CHECK_ENFORCER_DRY_RUN is set to a true value.
+func getDefaultDryRun() bool {
+	dryRun, err := strconv.ParseBool(os.Getenv(DryRunKey))
+	return err == nil && dryRun
+}
+
+// Record adds a write that was skipped to the summary.
+func (d *DryRun) Record(method string, url string, body []byte, description string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.mutations = append(d.mutations, Mutation{Method: method, Url: url, Body: string(body), Description: description})
+}
+
+func (d *DryRun) Mutations() []Mutation {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return append([]Mutation{}, d.mutations...)
+}
+
+// Summary lists every write that would have been made, in order.
+func (d *DryRun) Summary() string {
+	mutations := d.Mutations()
+	summary := strings.Builder{}
+	summary.WriteString(fmt.Sprintf("[dry-run] %d write(s) would have been made:", len(mutations)))
+	for i, mutation := range mutations {
+		summary.WriteString(fmt.Sprintf("\n  %d. %s %s: %s", i+1, mutation.Method, mutation.Url, mutation.Description))
+	}
+	return summary.String()
+}
+
+// skipWrite logs and records the write, with its exact request body, and returns
+// true if the client is in dry-run mode.
+func (gh *GithubClient) skipWrite(method string, target string, body []byte, description string) bool {
+	if gh.DryRun == nil {
+		return false
+	}
+	gh.Log.Info(fmt.Sprintf("[dry-run] %s", description), Fields{"method": method, "url": target, "body": string(body)})
+	gh.DryRun.Record(method, target, body, description)
+	return true
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDryRun(t *testing.T) {
+	assert := assert.New(t)
+	payloads, err := getPayloads()
+	assert.NoError(err)
+	pullRequestEvent, err := ioutil.ReadFile("./testpayloads/pull_request_event.json")
+	assert.NoError(err)
+
+	config := "version: 1\noutput: both\napps: [octocoders-linter]"
+	reads := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "GET" {
+			assert.Fail(fmt.Sprintf("Unexpected %s request to '%s' in dry-run mode", req.Method, req.URL.String()))
+			return
+		}
+		reads = append(reads, req.URL.Path)
+		switch {
+		case isConfigRequest(req):
+			w.Write(newConfigResponse(config))
+		case strings.HasSuffix(req.URL.Path, "/check-runs"):
+			json.NewEncoder(w).Encode(CheckRuns{})
+		case strings.HasSuffix(req.URL.Path, "/check-suites"):
+			w.Write(payloads.CheckSuiteResponse)
+		case strings.HasSuffix(req.URL.Path, "/comments"):
+			w.Write([]byte("[]"))
+		default:
+			assert.Fail(fmt.Sprintf("Unexpected request to '%s'", req.URL.String()))
+		}
+	}))
+	defer server.Close()
+
+	gh, err := NewGithubClient(server.URL, "", "octocoders-linter")
+	assert.NoError(err)
+	gh.DryRun = NewDryRun()
+	assert.NoError(handleEvent(gh, payloads.CheckSuiteEvent))
+
+	mutations := gh.DryRun.Mutations()
+	assert.Len(mutations, 2)
+	assert.Equal("POST", mutations[0].Method)
+	assert.True(strings.HasSuffix(mutations[0].Url, "/statuses/ec26c3e57ca3a959ca5aad62de7213c562f8c821"), mutations[0].Url)
+	assert.Equal("Status 'https://aka.ms/azsdk/checkenforcer' would be 'success': All checks passed", mutations[0].Description)
+	status := StatusBody{}
+	assert.NoError(json.Unmarshal([]byte(mutations[0].Body), &status))
+	assert.Equal(CommitStateSuccess, status.State)
+	assert.True(strings.HasSuffix(mutations[1].Url, "/check-runs"), mutations[1].Url)
+	assert.Contains(mutations[1].Description, "Check run would be 'completed' with conclusion 'success'")
+	assert.Contains(reads, "/repos/Codertocat/Hello-World/commits/ec26c3e57ca3a959ca5aad62de7213c562f8c821/check-runs")
+
+	config = "version: 1\napps: [no-match]\nno_pipelines_grace: 1ms"
+	gh, err = NewGithubClient(server.URL, "", "no-match")
+	assert.NoError(err)
+	gh.DryRun = NewDryRun()
+	gh.Timers = NewTimers()
+	assert.NoError(handleEvent(gh, pullRequestEvent))
+	gh.Timers.Wait()
+
+	mutations = gh.DryRun.Mutations()
+	assert.Len(mutations, 3)
+	assert.Equal("Issue comment would be created", mutations[1].Description)
+	comment := IssueCommentBody{}
+	assert.NoError(json.Unmarshal([]byte(mutations[1].Body), &comment))
+	assert.True(strings.HasPrefix(comment.Body, noPipelinesMarker("6dcb09b5b57875f334f61aebed695e2e4193db5e")))
+
+	summary := gh.DryRun.Summary()
+	assert.True(strings.HasPrefix(summary, "[dry-run] 3 write(s) would have been made:"), summary)
+	assert.Contains(summary, "\n  2. POST "+server.URL+"/repos/octocat/Hello-World/issues/1347/comments: Issue comment would be created")
+}
@@ -0,0 +1,103 @@
+package fakegithub
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// The methods below build webhook payloads from the current state of the repository,
+// with urls pointing at the fake server.
+// https://docs.github.com/en/webhooks/webhook-events-and-payloads
+
+// PullRequestEvent returns a pull_request payload, e.g. for the opened or synchronize action.
+func (r *Repo) PullRequestEvent(action string, number int) ([]byte, error) {
+	r.server.mutex.Lock()
+	defer r.server.mutex.Unlock()
+	pr, ok := r.pulls[number]
+	if !ok {
+		return nil, fmt.Errorf("Pull request %s#%d not found", r.FullName(), number)
+	}
+	return json.Marshal(object{
+		"action":       action,
+		"number":       number,
+		"pull_request": r.pullToJson(pr),
+		"repository":   r.toJson(),
+	})
+}
+
+// CheckSuiteEvent returns a check_suite payload, e.g. for the completed action.
+func (r *Repo) CheckSuiteEvent(action string, id int) ([]byte, error) {
+	r.server.mutex.Lock()
+	defer r.server.mutex.Unlock()
+	suite := r.findCheckSuite(id)
+	if suite == nil {
+		return nil, fmt.Errorf("Check suite %d not found in %s", id, r.FullName())
+	}
+	return json.Marshal(object{
+		"action":      action,
+		"check_suite": r.suiteToJson(suite),
+		"repository":  r.toJson(),
+	})
+}
+
+// WorkflowRunEvent returns a workflow_run payload for a github actions run of the
+// commit, triggered by event, e.g. pull_request.
+func (r *Repo) WorkflowRunEvent(action string, sha string, event string) ([]byte, error) {
+	r.server.mutex.Lock()
+	defer r.server.mutex.Unlock()
+	branch := ""
+	for _, pr := range r.pullsForSha(sha) {
+		branch = pr.HeadRef
+		break
+	}
+	id := r.server.newId()
+	return json.Marshal(object{
+		"action": action,
+		"workflow_run": object{
+			"id":            id,
+			"html_url":      fmt.Sprintf("%s/actions/runs/%d", r.htmlUrl(), id),
+			"head_sha":      sha,
+			"head_branch":   branch,
+			"event":         event,
+			"repository":    r.toJson(),
+			"pull_requests": r.pullRefsToJson(sha),
+		},
+		"repository": r.toJson(),
+	})
+}
+
+// IssueCommentEvent adds a comment by user to a pull request, and returns the
+// issue_comment payload for it.
+func (r *Repo) IssueCommentEvent(number int, user string, body string) ([]byte, error) {
+	r.server.mutex.Lock()
+	defer r.server.mutex.Unlock()
+	pr, ok := r.pulls[number]
+	if !ok {
+		return nil, fmt.Errorf("Pull request %s#%d not found", r.FullName(), number)
+	}
+	comment := r.addComment(number, user, body)
+	return json.Marshal(object{
+		"action": "created",
+		"issue": object{
+			"url":          fmt.Sprintf("%s/issues/%d", r.url(), number),
+			"number":       number,
+			"title":        pr.Title,
+			"state":        pr.State,
+			"comments_url": fmt.Sprintf("%s/issues/%d/comments", r.url(), number),
+			"pull_request": object{"url": fmt.Sprintf("%s/pulls/%d", r.url(), number)},
+		},
+		"comment":    r.commentToJson(comment),
+		"repository": r.toJson(),
+	})
+}
+
+// ScheduleEvent returns the payload of a github actions workflow run by a schedule
+// trigger with the cron expression.
+func (r *Repo) ScheduleEvent(cron string) ([]byte, error) {
+	r.server.mutex.Lock()
+	defer r.server.mutex.Unlock()
+	return json.Marshal(object{
+		"schedule":   cron,
+		"repository": r.toJson(),
+	})
+}
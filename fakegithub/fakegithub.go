@@ -0,0 +1,510 @@
+// Package fakegithub is an in-memory github REST API for scenario tests. It models
+// repositories, pull requests, check suites, check runs, commit statuses and comments,
+// and serves the endpoints used by check enforcer's github client. Tests seed the
+// state, send the webhook payloads built by the server to check enforcer, and assert
+// the statuses, check runs and comments it wrote.
+package fakegithub
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultUser is the login of the authenticated user, who comments and publishes.
+const DefaultUser = "check-enforcer[bot]"
+
+// DefaultApp is the name of the app check runs created through the API belong to.
+const DefaultApp = "Check Enforcer"
+
+const (
+	StatusQueued     = "queued"
+	StatusInProgress = "in_progress"
+	StatusCompleted  = "completed"
+)
+
+// Server serves the fake API over http. The state is shared by every request, and
+// can be seeded and inspected concurrently with requests.
+type Server struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	repos    map[string]*Repo
+	teams    map[string][]string
+	user     string
+	app      string
+	nextId   int
+	requests []string
+	now      func() time.Time
+}
+
+// Repo is a repository of the fake server. Its methods seed and inspect its state.
+type Repo struct {
+	server        *Server
+	Id            int
+	Owner         string
+	Name          string
+	DefaultBranch string
+	files         map[string]string
+	permissions   map[string]string
+	pulls         map[int]*PullRequest
+	suites        []*CheckSuite
+	runs          []*CheckRun
+	statuses      map[string][]Status
+	comments      []Comment
+}
+
+type PullRequest struct {
+	Number  int
+	Title   string
+	State   string
+	HeadRef string
+	HeadSha string
+	BaseRef string
+	Files   []string
+}
+
+type CheckSuite struct {
+	Id         int
+	App        string
+	HeadSha    string
+	HeadBranch string
+	Status     string
+	Conclusion string
+}
+
+type CheckRun struct {
+	Id          int
+	SuiteId     int
+	Name        string
+	HeadSha     string
+	Status      string
+	Conclusion  string
+	DetailsUrl  string
+	StartedAt   time.Time
+	CompletedAt *time.Time
+	Output      Output
+}
+
+type Output struct {
+	Title   string `json:"title"`
+	Summary string `json:"summary"`
+	Text    string `json:"text,omitempty"`
+}
+
+type Status struct {
+	State       string
+	Description string
+	Context     string
+	TargetUrl   string
+	CreatedAt   time.Time
+}
+
+type Comment struct {
+	Id        int
+	Number    int
+	User      string
+	Body      string
+	CreatedAt time.Time
+}
+
+// New starts a fake server. It must be closed by the caller.
+func New() *Server {
+	s := &Server{
+		repos: map[string]*Repo{},
+		teams: map[string][]string{},
+		user:  DefaultUser,
+		app:   DefaultApp,
+		now:   time.Now,
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// SetNow replaces the clock used for the creation and start times of new objects.
+func (s *Server) SetNow(now func() time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.now = now
+}
+
+// SetUser sets the login of the authenticated user, returned by GET /user and set on
+// the comments created through the API.
+func (s *Server) SetUser(login string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.user = login
+}
+
+// SetApp sets the name of the app that check runs created through the API belong to.
+func (s *Server) SetApp(name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.app = name
+}
+
+// AddTeamMember adds an active member to a team in the format "<org>/<team slug>".
+func (s *Server) AddTeamMember(team string, user string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.teams[strings.ToLower(team)] = append(s.teams[strings.ToLower(team)], user)
+}
+
+// Requests returns the requests served so far, in the format "GET /repos/owner/name".
+func (s *Server) Requests() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string{}, s.requests...)
+}
+
+// AddRepo adds a repository with the full name "<owner>/<name>" and a main default
+// branch, or returns the existing repository.
+func (s *Server) AddRepo(fullName string) *Repo {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if repo, ok := s.repos[strings.ToLower(fullName)]; ok {
+		return repo
+	}
+	parts := strings.SplitN(fullName, "/", 2)
+	if len(parts) != 2 {
+		parts = []string{fullName, fullName}
+	}
+	repo := &Repo{
+		server:        s,
+		Id:            s.newId(),
+		Owner:         parts[0],
+		Name:          parts[1],
+		DefaultBranch: "main",
+		files:         map[string]string{},
+		permissions:   map[string]string{},
+		pulls:         map[int]*PullRequest{},
+		statuses:      map[string][]Status{},
+	}
+	s.repos[strings.ToLower(fullName)] = repo
+	return repo
+}
+
+// Repo returns the repository with the full name, or nil if it was not added.
+func (s *Server) Repo(fullName string) *Repo {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.repos[strings.ToLower(fullName)]
+}
+
+func (s *Server) newId() int {
+	s.nextId++
+	return s.nextId
+}
+
+func (r *Repo) FullName() string {
+	return r.Owner + "/" + r.Name
+}
+
+// SetFile sets the content of a file on the default branch.
+func (r *Repo) SetFile(path string, content string) {
+	r.server.mutex.Lock()
+	defer r.server.mutex.Unlock()
+	r.files[strings.TrimPrefix(path, "/")] = content
+}
+
+// SetPermission sets the role of a collaborator: admin, maintain, write, triage or read.
+// Users without a role have read permission.
+func (r *Repo) SetPermission(user string, role string) {
+	r.server.mutex.Lock()
+	defer r.server.mutex.Unlock()
+	r.permissions[strings.ToLower(user)] = role
+}
+
+// AddPullRequest adds an open pull request against the default branch. The number
+// is assigned if it is 0.
+func (r *Repo) AddPullRequest(pr PullRequest) PullRequest {
+	r.server.mutex.Lock()
+	defer r.server.mutex.Unlock()
+
+	if pr.Number == 0 {
+		pr.Number = r.server.newId()
+	}
+	if pr.Title == "" {
+		pr.Title = fmt.Sprintf("Pull request %d", pr.Number)
+	}
+	if pr.State == "" {
+		pr.State = "open"
+	}
+	if pr.BaseRef == "" {
+		pr.BaseRef = r.DefaultBranch
+	}
+	pr.Files = append([]string{}, pr.Files...)
+	r.pulls[pr.Number] = &pr
+	return pr
+}
+
+// PullRequest returns the pull request with the number.
+func (r *Repo) PullRequest(number int) (PullRequest, bool) {
+	r.server.mutex.Lock()
+	defer r.server.mutex.Unlock()
+	pr, ok := r.pulls[number]
+	if !ok {
+		return PullRequest{}, false
+	}
+	return *pr, true
+}
+
+// PushPullRequest moves the head of a pull request to a new commit, as a push to its
+// branch does.
+func (r *Repo) PushPullRequest(number int, sha string) error {
+	r.server.mutex.Lock()
+	defer r.server.mutex.Unlock()
+	pr, ok := r.pulls[number]
+	if !ok {
+		return fmt.Errorf("Pull request %s#%d not found", r.FullName(), number)
+	}
+	pr.HeadSha = sha
+	return nil
+}
+
+// SetPullRequestState sets the state of a pull request to open or closed.
+func (r *Repo) SetPullRequestState(number int, state string) error {
+	r.server.mutex.Lock()
+	defer r.server.mutex.Unlock()
+	pr, ok := r.pulls[number]
+	if !ok {
+		return fmt.Errorf("Pull request %s#%d not found", r.FullName(), number)
+	}
+	pr.State = state
+	return nil
+}
+
+// AddCheckSuite adds a check suite for a commit. The id is assigned, the status
+// defaults to queued and the head branch to the branch of the pull request for the commit.
+func (r *Repo) AddCheckSuite(suite CheckSuite) CheckSuite {
+	r.server.mutex.Lock()
+	defer r.server.mutex.Unlock()
+	return *r.addCheckSuite(suite)
+}
+
+func (r *Repo) addCheckSuite(suite CheckSuite) *CheckSuite {
+	suite.Id = r.server.newId()
+	if suite.Status == "" {
+		suite.Status = StatusQueued
+	}
+	if suite.HeadBranch == "" {
+		for _, pr := range r.pullsForSha(suite.HeadSha) {
+			suite.HeadBranch = pr.HeadRef
+			break
+		}
+	}
+	r.suites = append(r.suites, &suite)
+	return &suite
+}
+
+// CheckSuite returns the check suite with the id.
+func (r *Repo) CheckSuite(id int) (CheckSuite, bool) {
+	r.server.mutex.Lock()
+	defer r.server.mutex.Unlock()
+	if suite := r.findCheckSuite(id); suite != nil {
+		return *suite, true
+	}
+	return CheckSuite{}, false
+}
+
+// CheckSuites returns the check suites of a commit, in the order they were added.
+func (r *Repo) CheckSuites(sha string) []CheckSuite {
+	r.server.mutex.Lock()
+	defer r.server.mutex.Unlock()
+	suites := []CheckSuite{}
+	for _, suite := range r.suites {
+		if suite.HeadSha == sha {
+			suites = append(suites, *suite)
+		}
+	}
+	return suites
+}
+
+// UpdateCheckSuite sets the status and conclusion of a check suite. The conclusion is
+// cleared unless the status is completed.
+func (r *Repo) UpdateCheckSuite(id int, status string, conclusion string) error {
+	r.server.mutex.Lock()
+	defer r.server.mutex.Unlock()
+	suite := r.findCheckSuite(id)
+	if suite == nil {
+		return fmt.Errorf("Check suite %d not found in %s", id, r.FullName())
+	}
+	suite.Status = status
+	suite.Conclusion = ""
+	if status == StatusCompleted {
+		suite.Conclusion = conclusion
+	}
+	return nil
+}
+
+// AddCheckRun adds a check run to the check suite with SuiteId. The id is assigned,
+// the status defaults to in_progress and the start time to now.
+func (r *Repo) AddCheckRun(run CheckRun) (CheckRun, error) {
+	r.server.mutex.Lock()
+	defer r.server.mutex.Unlock()
+	suite := r.findCheckSuite(run.SuiteId)
+	if suite == nil {
+		return CheckRun{}, fmt.Errorf("Check suite %d not found in %s", run.SuiteId, r.FullName())
+	}
+	run.Id = r.server.newId()
+	run.HeadSha = suite.HeadSha
+	if run.Status == "" {
+		run.Status = StatusInProgress
+	}
+	if run.StartedAt.IsZero() {
+		run.StartedAt = r.server.now().UTC()
+	}
+	if run.Status == StatusCompleted && run.CompletedAt == nil {
+		completedAt := r.server.now().UTC()
+		run.CompletedAt = &completedAt
+	}
+	r.runs = append(r.runs, &run)
+	return run, nil
+}
+
+// UpdateCheckRun sets the status and conclusion of a check run.
+func (r *Repo) UpdateCheckRun(id int, status string, conclusion string) error {
+	r.server.mutex.Lock()
+	defer r.server.mutex.Unlock()
+	run := r.findCheckRun(id)
+	if run == nil {
+		return fmt.Errorf("Check run %d not found in %s", id, r.FullName())
+	}
+	r.updateCheckRun(run, status, conclusion)
+	return nil
+}
+
+func (r *Repo) updateCheckRun(run *CheckRun, status string, conclusion string) {
+	run.Status = status
+	run.Conclusion = ""
+	run.CompletedAt = nil
+	if status == StatusCompleted {
+		run.Conclusion = conclusion
+		completedAt := r.server.now().UTC()
+		run.CompletedAt = &completedAt
+	}
+}
+
+// CheckRuns returns the check runs of a commit, in the order they were added.
+func (r *Repo) CheckRuns(sha string) []CheckRun {
+	r.server.mutex.Lock()
+	defer r.server.mutex.Unlock()
+	runs := []CheckRun{}
+	for _, run := range r.runs {
+		if run.HeadSha == sha {
+			runs = append(runs, *run)
+		}
+	}
+	return runs
+}
+
+// AddStatus adds a commit status, as if it was posted through the API.
+func (r *Repo) AddStatus(sha string, status Status) Status {
+	r.server.mutex.Lock()
+	defer r.server.mutex.Unlock()
+	if status.CreatedAt.IsZero() {
+		status.CreatedAt = r.server.now().UTC()
+	}
+	r.statuses[sha] = append(r.statuses[sha], status)
+	return status
+}
+
+// Statuses returns every status posted for a commit, oldest first.
+func (r *Repo) Statuses(sha string) []Status {
+	r.server.mutex.Lock()
+	defer r.server.mutex.Unlock()
+	return append([]Status{}, r.statuses[sha]...)
+}
+
+// LatestStatus returns the latest status posted for a commit with the context.
+func (r *Repo) LatestStatus(sha string, context string) (Status, bool) {
+	r.server.mutex.Lock()
+	defer r.server.mutex.Unlock()
+	statuses := r.statuses[sha]
+	for i := len(statuses) - 1; i >= 0; i-- {
+		if statuses[i].Context == context {
+			return statuses[i], true
+		}
+	}
+	return Status{}, false
+}
+
+// AddComment adds a comment to a pull request.
+func (r *Repo) AddComment(number int, user string, body string) Comment {
+	r.server.mutex.Lock()
+	defer r.server.mutex.Unlock()
+	return r.addComment(number, user, body)
+}
+
+func (r *Repo) addComment(number int, user string, body string) Comment {
+	comment := Comment{Id: r.server.newId(), Number: number, User: user, Body: body, CreatedAt: r.server.now().UTC()}
+	r.comments = append(r.comments, comment)
+	return comment
+}
+
+// Comments returns the comments of a pull request, oldest first.
+func (r *Repo) Comments(number int) []Comment {
+	r.server.mutex.Lock()
+	defer r.server.mutex.Unlock()
+	comments := []Comment{}
+	for _, comment := range r.comments {
+		if comment.Number == number {
+			comments = append(comments, comment)
+		}
+	}
+	return comments
+}
+
+func (r *Repo) findCheckSuite(id int) *CheckSuite {
+	for _, suite := range r.suites {
+		if suite.Id == id {
+			return suite
+		}
+	}
+	return nil
+}
+
+func (r *Repo) findCheckRun(id int) *CheckRun {
+	for _, run := range r.runs {
+		if run.Id == id {
+			return run
+		}
+	}
+	return nil
+}
+
+// sortedPulls returns the pull requests by number.
+func (r *Repo) sortedPulls() []*PullRequest {
+	pulls := []*PullRequest{}
+	for _, pr := range r.pulls {
+		pulls = append(pulls, pr)
+	}
+	sort.Slice(pulls, func(i, j int) bool { return pulls[i].Number < pulls[j].Number })
+	return pulls
+}
+
+// pullsForSha returns the pull requests with the commit as their head, by number.
+func (r *Repo) pullsForSha(sha string) []*PullRequest {
+	pulls := []*PullRequest{}
+	for _, pr := range r.sortedPulls() {
+		if pr.HeadSha == sha {
+			pulls = append(pulls, pr)
+		}
+	}
+	return pulls
+}
+
+// ownCheckSuite returns the check suite of the server app for a commit, which check
+// runs created through the API are added to.
+func (r *Repo) ownCheckSuite(sha string) *CheckSuite {
+	for _, suite := range r.suites {
+		if suite.HeadSha == sha && suite.App == r.server.app {
+			return suite
+		}
+	}
+	return r.addCheckSuite(CheckSuite{App: r.server.app, HeadSha: sha, Status: StatusInProgress})
+}
@@ -0,0 +1,229 @@
+package fakegithub
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testSha = "6dcb09b5b57875f334f61aebed695e2e4193db5e"
+
+func send(assert *assert.Assertions, method string, url string, body string) (int, http.Header, string) {
+	req, err := http.NewRequest(method, url, bytes.NewReader([]byte(body)))
+	assert.NoError(err)
+	resp, err := http.DefaultClient.Do(req)
+	if !assert.NoError(err) {
+		return 0, nil, ""
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(err)
+	return resp.StatusCode, resp.Header, string(data)
+}
+
+func newTestServer() (*Server, *Repo) {
+	s := New()
+	repo := s.AddRepo("octocat/Hello-World")
+	repo.AddPullRequest(PullRequest{Number: 1347, HeadRef: "new-topic", HeadSha: testSha, Files: []string{"sdk/storage/README.md"}})
+	repo.SetFile(".github/CODEOWNERS", "/sdk/ @octocat\n")
+	repo.SetPermission("octocat", "maintain")
+	s.AddTeamMember("octo-org/reviewers", "hubot")
+	return s, repo
+}
+
+type TestEndpointCase struct {
+	Description  string
+	Method       string
+	Path         string
+	Body         string
+	ExpectedCode int
+	Expected     string
+}
+
+func TestEndpoints(t *testing.T) {
+	assert := assert.New(t)
+	s, repo := newTestServer()
+	defer s.Close()
+	suite := repo.AddCheckSuite(CheckSuite{App: "Azure Pipelines", HeadSha: testSha})
+	_, err := repo.AddCheckRun(CheckRun{SuiteId: suite.Id, Name: "ci"})
+	assert.NoError(err)
+	_, err = repo.AddCheckRun(CheckRun{SuiteId: 999, Name: "ci"})
+	assert.Error(err)
+
+	repoUrl := s.URL + "/repos/octocat/Hello-World"
+	for _, tc := range []TestEndpointCase{
+		{"repo", "GET", "/repos/octocat/hello-world", "", 200, `"pulls_url":"` + repoUrl + `/pulls{/number}"`},
+		{"missing repo", "GET", "/repos/octocat/Spoon-Knife", "", 404, `"message":"Not Found"`},
+		{"open pulls", "GET", "/repos/octocat/Hello-World/pulls?state=open", "", 200, `"number":1347`},
+		{"pull", "GET", "/repos/octocat/Hello-World/pulls/1347", "", 200, `"statuses_url":"` + repoUrl + `/statuses/` + testSha + `"`},
+		{"missing pull", "GET", "/repos/octocat/Hello-World/pulls/1", "", 404, ""},
+		{"pull files", "GET", "/repos/octocat/Hello-World/pulls/1347/files", "", 200, `"filename":"sdk/storage/README.md"`},
+		{"check suites", "GET", "/repos/octocat/Hello-World/commits/" + testSha + "/check-suites", "", 200, `"head_branch":"new-topic"`},
+		{"check suite runs", "GET", fmt.Sprintf("/repos/octocat/Hello-World/check-suites/%d/check-runs", suite.Id), "", 200, `"total_count":1`},
+		{"commit pulls", "GET", "/repos/octocat/Hello-World/commits/" + testSha + "/pulls", "", 200, `"number":1347`},
+		{"contents", "GET", "/repos/octocat/Hello-World/contents/.github/CODEOWNERS", "", 200, `"content":"L3Nkay8gQG9jdG9jYXQK"`},
+		{"missing contents", "GET", "/repos/octocat/Hello-World/contents/.github/check-enforcer.yml", "", 404, ""},
+		{"permission", "GET", "/repos/octocat/Hello-World/collaborators/octocat/permission", "", 200, `"permission":"write","role_name":"maintain"`},
+		{"default permission", "GET", "/repos/octocat/Hello-World/collaborators/hubot/permission", "", 200, `"permission":"read"`},
+		{"team member", "GET", "/orgs/octo-org/teams/reviewers/memberships/hubot", "", 200, `"state":"active"`},
+		{"not a team member", "GET", "/orgs/octo-org/teams/reviewers/memberships/octocat", "", 404, ""},
+		{"user", "GET", "/user", "", 200, `"login":"check-enforcer[bot]","type":"Bot"`},
+		{"access token", "POST", "/app/installations/1/access_tokens", "", 201, `"token":`},
+		{"invalid status", "POST", "/repos/octocat/Hello-World/statuses/" + testSha, `{"state": "done"}`, 422, "Invalid state 'done'"},
+		{"comment on missing pull", "POST", "/repos/octocat/Hello-World/issues/1/comments", `{"body": "hi"}`, 404, ""},
+	} {
+		code, _, body := send(assert, tc.Method, s.URL+tc.Path, tc.Body)
+		assert.Equal(tc.ExpectedCode, code, tc.Description)
+		assert.Contains(body, tc.Expected, tc.Description)
+	}
+}
+
+func TestStatusesAndComments(t *testing.T) {
+	assert := assert.New(t)
+	s, repo := newTestServer()
+	defer s.Close()
+	statusesUrl := s.URL + "/repos/octocat/Hello-World/statuses/" + testSha
+	combinedUrl := s.URL + "/repos/octocat/Hello-World/commits/" + testSha + "/status"
+
+	_, _, body := send(assert, "GET", combinedUrl, "")
+	assert.Contains(body, `"state":"pending"`, "A commit without statuses is pending")
+
+	for _, state := range []string{"pending", "success"} {
+		code, _, _ := send(assert, "POST", statusesUrl, fmt.Sprintf(`{"state": "%s", "context": "https://aka.ms/azsdk/checkenforcer"}`, state))
+		assert.Equal(http.StatusCreated, code)
+	}
+	repo.AddStatus(testSha, Status{State: "failure", Context: "license/cla"})
+
+	assert.Len(repo.Statuses(testSha), 3)
+	latest, ok := repo.LatestStatus(testSha, "https://aka.ms/azsdk/checkenforcer")
+	assert.True(ok)
+	assert.Equal("success", latest.State)
+	_, ok = repo.LatestStatus("0000000", "https://aka.ms/azsdk/checkenforcer")
+	assert.False(ok)
+
+	_, _, body = send(assert, "GET", combinedUrl, "")
+	combined := struct {
+		State    string
+		Statuses []struct{ State, Context string }
+	}{}
+	assert.NoError(json.Unmarshal([]byte(body), &combined))
+	assert.Equal("failure", combined.State)
+	assert.Len(combined.Statuses, 2, "The combined status has the latest status of each context")
+	assert.Equal("license/cla", combined.Statuses[0].Context)
+	assert.Equal("success", combined.Statuses[1].State)
+
+	code, _, _ := send(assert, "POST", s.URL+"/repos/octocat/Hello-World/issues/1347/comments", `{"body": "All checks passed"}`)
+	assert.Equal(http.StatusCreated, code)
+	comments := repo.Comments(1347)
+	if assert.Len(comments, 1) {
+		assert.Equal(DefaultUser, comments[0].User)
+		assert.Equal("All checks passed", comments[0].Body)
+	}
+}
+
+func TestCheckRuns(t *testing.T) {
+	assert := assert.New(t)
+	s, repo := newTestServer()
+	defer s.Close()
+	commitRunsUrl := s.URL + "/repos/octocat/Hello-World/commits/" + testSha + "/check-runs"
+
+	code, _, body := send(assert, "POST", s.URL+"/repos/octocat/Hello-World/check-runs",
+		`{"name": "check-enforcer", "head_sha": "`+testSha+`", "status": "in_progress", "output": {"title": "Waiting", "summary": "Waiting for checks"}}`)
+	assert.Equal(http.StatusCreated, code)
+	run := struct {
+		Id  int
+		Url string
+	}{}
+	assert.NoError(json.Unmarshal([]byte(body), &run))
+
+	code, _, _ = send(assert, "PATCH", run.Url, `{"status": "completed", "conclusion": "success", "output": {"title": "Passed", "summary": "All checks passed"}}`)
+	assert.Equal(http.StatusOK, code)
+
+	runs := repo.CheckRuns(testSha)
+	if assert.Len(runs, 1) {
+		assert.Equal(StatusCompleted, runs[0].Status)
+		assert.Equal("success", runs[0].Conclusion)
+		assert.Equal("Passed", runs[0].Output.Title)
+		assert.NotNil(runs[0].CompletedAt)
+	}
+	suites := repo.CheckSuites(testSha)
+	if assert.Len(suites, 1) {
+		assert.Equal(DefaultApp, suites[0].App, "Created check runs belong to the check suite of the server app")
+		assert.Equal("success", suites[0].Conclusion)
+	}
+
+	_, _, body = send(assert, "GET", commitRunsUrl+"?check_name=other", "")
+	assert.Contains(body, `"total_count":0`)
+	_, _, body = send(assert, "GET", commitRunsUrl+"?check_name=check-enforcer", "")
+	assert.Contains(body, `"app":{"name":"Check Enforcer"}`)
+}
+
+func TestPagination(t *testing.T) {
+	assert := assert.New(t)
+	s, repo := newTestServer()
+	defer s.Close()
+	for i := 0; i < 5; i++ {
+		repo.AddCheckSuite(CheckSuite{App: fmt.Sprintf("App %d", i), HeadSha: testSha})
+	}
+
+	url := s.URL + "/repos/octocat/Hello-World/commits/" + testSha + "/check-suites?per_page=2"
+	pages := 0
+	suites := 0
+	for url != "" {
+		code, header, body := send(assert, "GET", url, "")
+		assert.Equal(http.StatusOK, code)
+		page := struct {
+			Count       int                      `json:"total_count"`
+			CheckSuites []map[string]interface{} `json:"check_suites"`
+		}{}
+		assert.NoError(json.Unmarshal([]byte(body), &page))
+		assert.Equal(5, page.Count)
+		suites += len(page.CheckSuites)
+		pages++
+
+		url = ""
+		if link := header.Get("Link"); link != "" {
+			url = strings.TrimPrefix(strings.SplitN(link, ">", 2)[0], "<")
+		}
+	}
+	assert.Equal(3, pages)
+	assert.Equal(5, suites)
+}
+
+func TestEvents(t *testing.T) {
+	assert := assert.New(t)
+	s, repo := newTestServer()
+	defer s.Close()
+	suite := repo.AddCheckSuite(CheckSuite{App: "Azure Pipelines", HeadSha: testSha})
+	assert.NoError(repo.UpdateCheckSuite(suite.Id, StatusCompleted, "success"))
+
+	payload, err := repo.CheckSuiteEvent("completed", suite.Id)
+	assert.NoError(err)
+	assert.Contains(string(payload), `"conclusion":"success"`)
+	assert.Contains(string(payload), `"pull_requests":[{"number":1347`)
+
+	payload, err = repo.IssueCommentEvent(1347, "octocat", "/check-enforcer evaluate")
+	assert.NoError(err)
+	assert.Contains(string(payload), `"comments_url":"`+s.URL+`/repos/octocat/Hello-World/issues/1347/comments"`)
+	assert.Len(repo.Comments(1347), 1, "The comment of the event is added to the pull request")
+
+	assert.NoError(repo.PushPullRequest(1347, "abc"))
+	payload, err = repo.PullRequestEvent("synchronize", 1347)
+	assert.NoError(err)
+	assert.Contains(string(payload), `"sha":"abc"`)
+
+	payload, err = repo.WorkflowRunEvent("completed", "abc", "pull_request")
+	assert.NoError(err)
+	assert.Contains(string(payload), `"head_branch":"new-topic"`)
+
+	_, err = repo.PullRequestEvent("opened", 1)
+	assert.Error(err)
+	_, err = repo.CheckSuiteEvent("completed", 999)
+	assert.Error(err)
+}
@@ -0,0 +1,424 @@
+package fakegithub
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPageSize is the page size of list endpoints when per_page is not set. Pages
+// are limited to 100 items, the same as github.
+const DefaultPageSize = 30
+
+type handler func(w http.ResponseWriter, req *http.Request, repo *Repo, params []string)
+
+type route struct {
+	method  string
+	pattern *regexp.Regexp
+	handle  handler
+}
+
+// repoRoutes are matched against the path following /repos/<owner>/<name>.
+var repoRoutes = []route{
+	{"GET", regexp.MustCompile(`^$`), getRepo},
+	{"GET", regexp.MustCompile(`^/pulls$`), listPulls},
+	{"GET", regexp.MustCompile(`^/pulls/(\d+)$`), getPull},
+	{"GET", regexp.MustCompile(`^/pulls/(\d+)/files$`), listPullFiles},
+	{"GET", regexp.MustCompile(`^/commits/([^/]+)/check-suites$`), listCommitCheckSuites},
+	{"GET", regexp.MustCompile(`^/commits/([^/]+)/check-runs$`), listCommitCheckRuns},
+	{"GET", regexp.MustCompile(`^/commits/([^/]+)/status$`), getCombinedStatus},
+	{"GET", regexp.MustCompile(`^/commits/([^/]+)/pulls$`), listCommitPulls},
+	{"GET", regexp.MustCompile(`^/check-suites/(\d+)/check-runs$`), listSuiteCheckRuns},
+	{"POST", regexp.MustCompile(`^/check-runs$`), createCheckRun},
+	{"PATCH", regexp.MustCompile(`^/check-runs/(\d+)$`), updateCheckRun},
+	{"POST", regexp.MustCompile(`^/statuses/([^/]+)$`), createStatus},
+	{"GET", regexp.MustCompile(`^/issues/(\d+)/comments$`), listComments},
+	{"POST", regexp.MustCompile(`^/issues/(\d+)/comments$`), createComment},
+	{"GET", regexp.MustCompile(`^/contents/(.+)$`), getContents},
+	{"GET", regexp.MustCompile(`^/collaborators/([^/]+)/permission$`), getPermission},
+}
+
+var repoPathRegex = regexp.MustCompile(`^/repos/([^/]+)/([^/]+)(/.*)?$`)
+var teamMembershipRegex = regexp.MustCompile(`^/orgs/([^/]+)/teams/([^/]+)/memberships/([^/]+)$`)
+var accessTokenRegex = regexp.MustCompile(`^/app/installations/(\d+)/access_tokens$`)
+
+// serveHTTP handles a request while holding the server mutex, so every request sees
+// and leaves a consistent state.
+func (s *Server) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.requests = append(s.requests, req.Method+" "+req.URL.Path)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	path := req.URL.Path
+	switch {
+	case path == "/user" && req.Method == "GET":
+		writeJson(w, http.StatusOK, object{"login": s.user, "type": userType(s.user)})
+		return
+	case accessTokenRegex.MatchString(path) && req.Method == "POST":
+		writeJson(w, http.StatusCreated, object{"token": "fake-installation-token", "expires_at": s.now().Add(time.Hour).UTC().Format(time.RFC3339)})
+		return
+	case teamMembershipRegex.MatchString(path) && req.Method == "GET":
+		matches := teamMembershipRegex.FindStringSubmatch(path)
+		for _, member := range s.teams[strings.ToLower(matches[1]+"/"+matches[2])] {
+			if strings.EqualFold(member, matches[3]) {
+				writeJson(w, http.StatusOK, object{"state": "active", "role": "member"})
+				return
+			}
+		}
+		writeNotFound(w)
+		return
+	}
+
+	matches := repoPathRegex.FindStringSubmatch(path)
+	if matches == nil {
+		writeNotFound(w)
+		return
+	}
+	repo, ok := s.repos[strings.ToLower(matches[1]+"/"+matches[2])]
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	for _, route := range repoRoutes {
+		if route.method != req.Method {
+			continue
+		}
+		if params := route.pattern.FindStringSubmatch(matches[3]); params != nil {
+			route.handle(w, req, repo, params[1:])
+			return
+		}
+	}
+	writeNotFound(w)
+}
+
+func writeJson(w http.ResponseWriter, statusCode int, value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(statusCode)
+	w.Write(data)
+}
+
+func writeError(w http.ResponseWriter, statusCode int, message string) {
+	data, _ := json.Marshal(object{"message": message})
+	w.WriteHeader(statusCode)
+	w.Write(data)
+}
+
+func writeNotFound(w http.ResponseWriter) {
+	writeError(w, http.StatusNotFound, "Not Found")
+}
+
+// writePage writes a page of items, selected by the page and per_page query parameters,
+// with a Link header to the next page if there is one. If key is set, the page is
+// wrapped in an object with the total count, as for check suites and check runs.
+func writePage(w http.ResponseWriter, req *http.Request, items []object, key string) {
+	query := req.URL.Query()
+	page, err := strconv.Atoi(query.Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	perPage, err := strconv.Atoi(query.Get("per_page"))
+	if err != nil || perPage < 1 {
+		perPage = DefaultPageSize
+	}
+	if perPage > 100 {
+		perPage = 100
+	}
+
+	start := (page - 1) * perPage
+	if start > len(items) {
+		start = len(items)
+	}
+	end := start + perPage
+	if end > len(items) {
+		end = len(items)
+	}
+	if end < len(items) {
+		query.Set("page", strconv.Itoa(page+1))
+		next := fmt.Sprintf("http://%s%s?%s", req.Host, req.URL.Path, query.Encode())
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next))
+	}
+
+	if key == "" {
+		writeJson(w, http.StatusOK, items[start:end])
+		return
+	}
+	writeJson(w, http.StatusOK, object{"total_count": len(items), key: items[start:end]})
+}
+
+func readBody(req *http.Request, value interface{}) error {
+	data, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+func getRepo(w http.ResponseWriter, req *http.Request, repo *Repo, params []string) {
+	writeJson(w, http.StatusOK, repo.toJson())
+}
+
+func listPulls(w http.ResponseWriter, req *http.Request, repo *Repo, params []string) {
+	state := req.URL.Query().Get("state")
+	if state == "" {
+		state = "open"
+	}
+	pulls := []object{}
+	for _, pr := range repo.sortedPulls() {
+		if state == "all" || pr.State == state {
+			pulls = append(pulls, repo.pullToJson(pr))
+		}
+	}
+	writePage(w, req, pulls, "")
+}
+
+func getPull(w http.ResponseWriter, req *http.Request, repo *Repo, params []string) {
+	number, _ := strconv.Atoi(params[0])
+	pr, ok := repo.pulls[number]
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	writeJson(w, http.StatusOK, repo.pullToJson(pr))
+}
+
+func listPullFiles(w http.ResponseWriter, req *http.Request, repo *Repo, params []string) {
+	number, _ := strconv.Atoi(params[0])
+	pr, ok := repo.pulls[number]
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	files := []object{}
+	for _, file := range pr.Files {
+		files = append(files, object{"filename": file, "status": "modified"})
+	}
+	writePage(w, req, files, "")
+}
+
+func listCommitCheckSuites(w http.ResponseWriter, req *http.Request, repo *Repo, params []string) {
+	suites := []object{}
+	for _, suite := range repo.suites {
+		if suite.HeadSha == params[0] {
+			suites = append(suites, repo.suiteToJson(suite))
+		}
+	}
+	writePage(w, req, suites, "check_suites")
+}
+
+func listCommitCheckRuns(w http.ResponseWriter, req *http.Request, repo *Repo, params []string) {
+	name := req.URL.Query().Get("check_name")
+	runs := []object{}
+	for _, run := range repo.runs {
+		if run.HeadSha == params[0] && (name == "" || run.Name == name) {
+			runs = append(runs, repo.runToJson(run))
+		}
+	}
+	writePage(w, req, runs, "check_runs")
+}
+
+func listSuiteCheckRuns(w http.ResponseWriter, req *http.Request, repo *Repo, params []string) {
+	id, _ := strconv.Atoi(params[0])
+	if repo.findCheckSuite(id) == nil {
+		writeNotFound(w)
+		return
+	}
+	runs := []object{}
+	for _, run := range repo.runs {
+		if run.SuiteId == id {
+			runs = append(runs, repo.runToJson(run))
+		}
+	}
+	writePage(w, req, runs, "check_runs")
+}
+
+type checkRunBody struct {
+	Name       string `json:"name"`
+	HeadSha    string `json:"head_sha"`
+	Status     string `json:"status"`
+	Conclusion string `json:"conclusion"`
+	DetailsUrl string `json:"details_url"`
+	Output     Output `json:"output"`
+}
+
+// createCheckRun adds the check run to the check suite of the server app for the
+// commit, and sets the suite to the status of its latest check run.
+func createCheckRun(w http.ResponseWriter, req *http.Request, repo *Repo, params []string) {
+	body := checkRunBody{}
+	if err := readBody(req, &body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if body.Name == "" || body.HeadSha == "" {
+		writeError(w, http.StatusUnprocessableEntity, "name and head_sha are required")
+		return
+	}
+	if body.Status == "" {
+		body.Status = StatusQueued
+	}
+
+	suite := repo.ownCheckSuite(body.HeadSha)
+	run := &CheckRun{
+		Id:         repo.server.newId(),
+		SuiteId:    suite.Id,
+		Name:       body.Name,
+		HeadSha:    body.HeadSha,
+		DetailsUrl: body.DetailsUrl,
+		StartedAt:  repo.server.now().UTC(),
+		Output:     body.Output,
+	}
+	repo.updateCheckRun(run, body.Status, body.Conclusion)
+	repo.runs = append(repo.runs, run)
+	suite.Status = run.Status
+	suite.Conclusion = run.Conclusion
+	writeJson(w, http.StatusCreated, repo.runToJson(run))
+}
+
+func updateCheckRun(w http.ResponseWriter, req *http.Request, repo *Repo, params []string) {
+	id, _ := strconv.Atoi(params[0])
+	run := repo.findCheckRun(id)
+	if run == nil {
+		writeNotFound(w)
+		return
+	}
+	body := checkRunBody{}
+	if err := readBody(req, &body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if body.Name != "" {
+		run.Name = body.Name
+	}
+	if body.DetailsUrl != "" {
+		run.DetailsUrl = body.DetailsUrl
+	}
+	if body.Output.Title != "" || body.Output.Summary != "" {
+		run.Output = body.Output
+	}
+	status := body.Status
+	if status == "" && body.Conclusion != "" {
+		status = StatusCompleted
+	}
+	if status != "" {
+		repo.updateCheckRun(run, status, body.Conclusion)
+	}
+	if suite := repo.findCheckSuite(run.SuiteId); suite != nil && suite.App == repo.server.app {
+		suite.Status = run.Status
+		suite.Conclusion = run.Conclusion
+	}
+	writeJson(w, http.StatusOK, repo.runToJson(run))
+}
+
+func getCombinedStatus(w http.ResponseWriter, req *http.Request, repo *Repo, params []string) {
+	writeJson(w, http.StatusOK, repo.combinedStatusToJson(params[0]))
+}
+
+func createStatus(w http.ResponseWriter, req *http.Request, repo *Repo, params []string) {
+	body := struct {
+		State       string `json:"state"`
+		Description string `json:"description"`
+		Context     string `json:"context"`
+		TargetUrl   string `json:"target_url"`
+	}{}
+	if err := readBody(req, &body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	switch body.State {
+	case "pending", "success", "failure", "error":
+	default:
+		writeError(w, http.StatusUnprocessableEntity, fmt.Sprintf("Invalid state '%s'", body.State))
+		return
+	}
+	if body.Context == "" {
+		body.Context = "default"
+	}
+
+	status := Status{
+		State:       body.State,
+		Description: body.Description,
+		Context:     body.Context,
+		TargetUrl:   body.TargetUrl,
+		CreatedAt:   repo.server.now().UTC(),
+	}
+	repo.statuses[params[0]] = append(repo.statuses[params[0]], status)
+	writeJson(w, http.StatusCreated, statusToJson(status))
+}
+
+func listCommitPulls(w http.ResponseWriter, req *http.Request, repo *Repo, params []string) {
+	pulls := []object{}
+	for _, pr := range repo.pullsForSha(params[0]) {
+		pulls = append(pulls, repo.pullToJson(pr))
+	}
+	writePage(w, req, pulls, "")
+}
+
+func listComments(w http.ResponseWriter, req *http.Request, repo *Repo, params []string) {
+	number, _ := strconv.Atoi(params[0])
+	comments := []object{}
+	for _, comment := range repo.comments {
+		if comment.Number == number {
+			comments = append(comments, repo.commentToJson(comment))
+		}
+	}
+	writePage(w, req, comments, "")
+}
+
+func createComment(w http.ResponseWriter, req *http.Request, repo *Repo, params []string) {
+	number, _ := strconv.Atoi(params[0])
+	if _, ok := repo.pulls[number]; !ok {
+		writeNotFound(w)
+		return
+	}
+	body := struct {
+		Body string `json:"body"`
+	}{}
+	if err := readBody(req, &body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	comment := repo.addComment(number, repo.server.user, body.Body)
+	writeJson(w, http.StatusCreated, repo.commentToJson(comment))
+}
+
+func getContents(w http.ResponseWriter, req *http.Request, repo *Repo, params []string) {
+	content, ok := repo.files[params[0]]
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	writeJson(w, http.StatusOK, object{
+		"type":     "file",
+		"path":     params[0],
+		"encoding": "base64",
+		"content":  base64.StdEncoding.EncodeToString([]byte(content)),
+	})
+}
+
+// getPermission returns the role of a collaborator, and its legacy permission of
+// admin, write or read.
+func getPermission(w http.ResponseWriter, req *http.Request, repo *Repo, params []string) {
+	role, ok := repo.permissions[strings.ToLower(params[0])]
+	if !ok {
+		role = "read"
+	}
+	permission := role
+	switch role {
+	case "maintain":
+		permission = "write"
+	case "triage":
+		permission = "read"
+	}
+	writeJson(w, http.StatusOK, object{"permission": permission, "role_name": role, "user": object{"login": params[0]}})
+}
@@ -0,0 +1,179 @@
+package fakegithub
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The json representations below have the fields of the github REST API that check
+// enforcer reads. They are built while the server mutex is held.
+
+type object map[string]interface{}
+
+func (r *Repo) url() string {
+	return fmt.Sprintf("%s/repos/%s", r.server.URL, r.FullName())
+}
+
+func (r *Repo) htmlUrl() string {
+	return "https://github.com/" + r.FullName()
+}
+
+func (r *Repo) toJson() object {
+	url := r.url()
+	return object{
+		"id":                r.Id,
+		"name":              r.Name,
+		"full_name":         r.FullName(),
+		"owner":             object{"login": r.Owner},
+		"default_branch":    r.DefaultBranch,
+		"url":               url,
+		"html_url":          r.htmlUrl(),
+		"collaborators_url": url + "/collaborators{/collaborator}",
+		"commits_url":       url + "/commits{/sha}",
+		"contents_url":      url + "/contents/{+path}",
+		"issues_url":        url + "/issues{/number}",
+		"pulls_url":         url + "/pulls{/number}",
+		"statuses_url":      url + "/statuses/{sha}",
+	}
+}
+
+func (r *Repo) pullToJson(pr *PullRequest) object {
+	repo := r.toJson()
+	return object{
+		"id":           pr.Number,
+		"number":       pr.Number,
+		"title":        pr.Title,
+		"state":        pr.State,
+		"url":          fmt.Sprintf("%s/pulls/%d", r.url(), pr.Number),
+		"html_url":     fmt.Sprintf("%s/pull/%d", r.htmlUrl(), pr.Number),
+		"comments_url": fmt.Sprintf("%s/issues/%d/comments", r.url(), pr.Number),
+		"statuses_url": fmt.Sprintf("%s/statuses/%s", r.url(), pr.HeadSha),
+		"head":         object{"ref": pr.HeadRef, "sha": pr.HeadSha, "repo": repo},
+		"base":         object{"ref": pr.BaseRef, "repo": repo},
+	}
+}
+
+// pullRefsToJson returns the open pull requests for a commit, as listed in check suite
+// and workflow run payloads.
+func (r *Repo) pullRefsToJson(sha string) []object {
+	refs := []object{}
+	for _, pr := range r.pullsForSha(sha) {
+		if pr.State == "open" {
+			refs = append(refs, object{"number": pr.Number, "url": fmt.Sprintf("%s/pulls/%d", r.url(), pr.Number)})
+		}
+	}
+	return refs
+}
+
+func (r *Repo) suiteToJson(suite *CheckSuite) object {
+	runs := 0
+	for _, run := range r.runs {
+		if run.SuiteId == suite.Id {
+			runs++
+		}
+	}
+	return object{
+		"id":                      suite.Id,
+		"head_branch":             suite.HeadBranch,
+		"head_sha":                suite.HeadSha,
+		"status":                  suite.Status,
+		"conclusion":              nullable(suite.Conclusion),
+		"url":                     fmt.Sprintf("%s/check-suites/%d", r.url(), suite.Id),
+		"check_runs_url":          fmt.Sprintf("%s/check-suites/%d/check-runs", r.url(), suite.Id),
+		"latest_check_runs_count": runs,
+		"app":                     object{"name": suite.App},
+		"pull_requests":           r.pullRefsToJson(suite.HeadSha),
+	}
+}
+
+func (r *Repo) runToJson(run *CheckRun) object {
+	app := ""
+	if suite := r.findCheckSuite(run.SuiteId); suite != nil {
+		app = suite.App
+	}
+	var completedAt interface{}
+	if run.CompletedAt != nil {
+		completedAt = run.CompletedAt.Format(time.RFC3339)
+	}
+	return object{
+		"id":           run.Id,
+		"name":         run.Name,
+		"head_sha":     run.HeadSha,
+		"status":       run.Status,
+		"conclusion":   nullable(run.Conclusion),
+		"url":          fmt.Sprintf("%s/check-runs/%d", r.url(), run.Id),
+		"html_url":     fmt.Sprintf("%s/runs/%d", r.htmlUrl(), run.Id),
+		"details_url":  run.DetailsUrl,
+		"started_at":   run.StartedAt.Format(time.RFC3339),
+		"completed_at": completedAt,
+		"app":          object{"name": app},
+		"check_suite":  object{"id": run.SuiteId},
+		"output":       run.Output,
+	}
+}
+
+func statusToJson(status Status) object {
+	return object{
+		"state":       status.State,
+		"description": status.Description,
+		"context":     status.Context,
+		"target_url":  status.TargetUrl,
+		"created_at":  status.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func (r *Repo) commentToJson(comment Comment) object {
+	return object{
+		"id":         comment.Id,
+		"body":       comment.Body,
+		"user":       object{"login": comment.User, "type": userType(comment.User)},
+		"url":        fmt.Sprintf("%s/issues/comments/%d", r.url(), comment.Id),
+		"html_url":   fmt.Sprintf("%s/pull/%d#issuecomment-%d", r.htmlUrl(), comment.Number, comment.Id),
+		"created_at": comment.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// combinedStatusToJson returns the latest status of each context, newest first, and
+// their combined state: failure if any failed, pending if any are pending or there are
+// none, and success otherwise.
+func (r *Repo) combinedStatusToJson(sha string) object {
+	latest := []object{}
+	seen := map[string]bool{}
+	state := "success"
+	statuses := r.statuses[sha]
+	for i := len(statuses) - 1; i >= 0; i-- {
+		status := statuses[i]
+		if seen[status.Context] {
+			continue
+		}
+		seen[status.Context] = true
+		latest = append(latest, statusToJson(status))
+		switch {
+		case status.State == "failure" || status.State == "error":
+			state = "failure"
+		case status.State == "pending" && state != "failure":
+			state = "pending"
+		}
+	}
+	if len(latest) == 0 {
+		state = "pending"
+	}
+	return object{"state": state, "sha": sha, "total_count": len(latest), "statuses": latest}
+}
+
+func userType(login string) string {
+	if strings.HasSuffix(login, "[bot]") {
+		return "Bot"
+	}
+	return "User"
+}
+
+// nullable returns nil for empty strings, which github sends as null, e.g. the
+// conclusion of unfinished check suites.
+func nullable(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
@@ -20,6 +20,22 @@ func getDuration(from time.Time, to time.Time) time.Duration {
 	return d
 }
 
+// formatAge formats a duration in its two largest units, e.g. "2d 3h", "5h 10m" or "45m".
+func formatAge(d time.Duration) string {
+	totalMinutes := int64(d / time.Minute)
+	days := totalMinutes / (24 * 60)
+	hours := totalMinutes / 60 % 24
+	minutes := totalMinutes % 60
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd %dh", days, hours)
+	case hours > 0:
+		return fmt.Sprintf("%dh %dm", hours, minutes)
+	default:
+		return fmt.Sprintf("%dm", minutes)
+	}
+}
+
 // formatDuration formats a duration as hh:mm:ss (always zero-padded).
 func formatDuration(d time.Duration) string {
 	totalSeconds := int64(d / time.Second)
@@ -24,6 +24,15 @@ func TestFormatDuration(t *testing.T) {
 	assert.Equal("10:09:08", formatDuration(10*time.Hour+9*time.Minute+8*time.Second))
 }
 
+func TestFormatAge(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("0m", formatAge(30*time.Second))
+	assert.Equal("45m", formatAge(45*time.Minute))
+	assert.Equal("5h 10m", formatAge(5*time.Hour+10*time.Minute))
+	assert.Equal("1d 0h", formatAge(24*time.Hour+59*time.Minute))
+	assert.Equal("3d 2h", formatAge(74*time.Hour))
+}
+
 func TestGetDuration(t *testing.T) {
 	assert := assert.New(t)
 	from := time.Unix(1000, 0)
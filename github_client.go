@@ -2,39 +2,90 @@ package main
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 )
 
+// GhesApiPath is the path of the REST API on github enterprise server.
+const GhesApiPath = "/api/v3"
+
 type GithubClient struct {
-	client     *http.Client
-	token      string
-	BaseUrl    url.URL
-	AppTargets []string
+	client  *http.Client
+	auth    Authenticator
+	retry   RetryPolicy
+	BaseUrl url.URL
+	Config  *Config
+	// Timers runs delayed checks. It is nil when delayed checks are not supported.
+	Timers *Timers
+	// DryRun records writes instead of sending them. It is nil unless dry-run mode is enabled.
+	DryRun *DryRun
+	// Log adds the fields of the event being handled to every line.
+	Log *Logger
+	// RateLimit sheds non-essential calls and waits for the rate limit to reset.
+	RateLimit *RateLimitGuard
+	// installationId is the github app installation the client is authenticated for,
+	// or 0 for a static token. Rate limit metrics are labeled with it.
+	installationId int
+}
+
+// HttpError is returned for any API response with an error status code.
+type HttpError struct {
+	StatusCode int
+	// Body is the response body, which has the error message from github.
+	Body []byte
+}
+
+func (e *HttpError) Error() string {
+	return fmt.Sprintf("Received http error %d", e.StatusCode)
+}
+
+func IsNotFound(err error) bool {
+	var httpErr *HttpError
+	return errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusNotFound
 }
 
+// NewGithubClient creates a client with a default config targeting appTargets. The
+// default config is replaced by the repository config when LoadConfig is called.
 func NewGithubClient(baseUrl string, token string, appTargets ...string) (*GithubClient, error) {
+	return NewGithubClientWithAuth(baseUrl, NewTokenAuthenticator(token), appTargets...)
+}
+
+func NewGithubClientWithAuth(baseUrl string, auth Authenticator, appTargets ...string) (*GithubClient, error) {
 	u, err := url.Parse(baseUrl)
 	if err != nil {
 		return nil, err
 	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, errors.New(fmt.Sprintf("Github api url '%s' must be an absolute url", baseUrl))
+	}
 	return &GithubClient{
-		client:     &http.Client{},
-		BaseUrl:    *u,
-		token:      token,
-		AppTargets: appTargets,
+		client:    &http.Client{},
+		BaseUrl:   *u,
+		auth:      auth,
+		retry:     NewDefaultRetryPolicy(),
+		Config:    NewDefaultConfig(appTargets...),
+		Log:       logger,
+		RateLimit: NewDefaultRateLimitGuard(),
 	}, nil
 }
 
+// SetTransport replaces the transport API requests are sent with, e.g. to record or
+// replay them.
+func (gh *GithubClient) SetTransport(transport http.RoundTripper) {
+	gh.client.Transport = transport
+}
+
 func (gh *GithubClient) setHeaders(req *http.Request) {
 	req.Header.Add("Accept", "application/vnd.github.v3+json")
-	req.Header.Add("Authorization", fmt.Sprintf("token %s", gh.token))
 }
 
 func (gh *GithubClient) getUrl(target string) (*url.URL, error) {
@@ -43,11 +94,28 @@ func (gh *GithubClient) getUrl(target string) (*url.URL, error) {
 		return nil, err
 	}
 
+	// Payload urls include the api path of the server that sent them, e.g. /api/v3 for
+	// github enterprise server. When the base url has a different path, e.g. for a proxy,
+	// the payload api path is replaced with the base url path.
+	basePath := strings.TrimSuffix(gh.BaseUrl.Path, "/")
+	if basePath != "" && !hasPathPrefix(targetUrl.Path, basePath) {
+		path := targetUrl.Path
+		if hasPathPrefix(path, GhesApiPath) {
+			path = strings.TrimPrefix(path, GhesApiPath)
+		}
+		targetUrl.Path = basePath + path
+		targetUrl.RawPath = ""
+	}
+
 	targetUrl.Scheme = gh.BaseUrl.Scheme
 	targetUrl.Host = gh.BaseUrl.Host
 	return targetUrl, nil
 }
 
+func hasPathPrefix(path string, prefix string) bool {
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
 func (gh *GithubClient) SetStatus(statusUrl string, status StatusBody) error {
 	body, err := json.Marshal(status)
 	if err != nil {
@@ -59,6 +127,10 @@ func (gh *GithubClient) SetStatus(statusUrl string, status StatusBody) error {
 		return err
 	}
 
+	if gh.skipWrite("POST", target.String(), body, fmt.Sprintf("Status '%s' would be '%s': %s", status.Context, status.State, status.Description)) {
+		return nil
+	}
+
 	reader := bytes.NewReader(body)
 
 	req, err := http.NewRequest("POST", target.String(), reader)
@@ -68,10 +140,12 @@ func (gh *GithubClient) SetStatus(statusUrl string, status StatusBody) error {
 
 	gh.setHeaders(req)
 
-	_, err = gh.request(req)
+	// Posting the same status more than once is harmless, so retry even though it is a POST.
+	_, _, err = gh.requestWithRetry(req, true)
 	if err != nil {
 		return err
 	}
+	metrics.Inc(MetricStatuses, Labels{"state": string(status.State)})
 
 	return nil
 }
@@ -106,15 +180,15 @@ func (gh *GithubClient) FilterCheckSuiteStatuses(checkSuites []CheckSuite) []Che
 	filteredCheckSuites := []CheckSuite{}
 
 	for _, cs := range checkSuites {
-		for _, target := range gh.AppTargets {
+		for _, target := range gh.Config.AppTargets {
 			// Ignore auxiliary checks we don't control, e.g. Microsoft Policy Service.
 			// Github creates a check suite for each app with checks:write permissions,
 			// so also ignore any check suites with 0 check runs posted
 			//
-			// TODO: in the case where a check run isn't posted from azure pipelines due to invalid yaml, will this
-			// show up as 0 check runs? If so, how do we differentiate between the following so we don't submit a passing status:
-			//    1. Github Actions CI intended, Azure Pipelines CI NOT detected
-			//    2. Github Actions CI intended, Azure Pipelines CI intended, Azure Pipelines CI invalid yaml
+			// A check run that isn't posted from azure pipelines due to invalid yaml also shows up as 0
+			// check runs, which cannot be told apart from azure pipelines CI not being triggered. Repos
+			// that need to differentiate these cases should list the check runs that must be posted
+			// under 'check_runs.<app>.required' in the config file, see evaluateCheckSuites.
 			if cs.App.Name == target && cs.LatestCheckRunCount > 0 {
 				filteredCheckSuites = append(filteredCheckSuites, cs)
 			}
@@ -124,29 +198,150 @@ func (gh *GithubClient) FilterCheckSuiteStatuses(checkSuites []CheckSuite) []Che
 	return filteredCheckSuites
 }
 
+// GetCheckSuiteStatuses lists all check suites for a commit, filtered to the target apps.
 func (gh *GithubClient) GetCheckSuiteStatuses(checkSuiteUrl string) ([]CheckSuite, error) {
-	target, err := gh.getUrl(checkSuiteUrl)
+	suites := []CheckSuite{}
+	totalCount := 0
+
+	err := gh.getPaged(checkSuiteUrl, func(data []byte) error {
+		page := CheckSuites{}
+		if err := json.Unmarshal(data, &page); err != nil {
+			return err
+		}
+		totalCount = page.Count
+		suites = append(suites, page.CheckSuites...)
+		return nil
+	})
 	if err != nil {
 		return []CheckSuite{}, err
 	}
 
+	// Evaluating a partial list could post a success status while a failing suite
+	// was left out, so fail instead.
+	if len(suites) != totalCount {
+		return []CheckSuite{}, errors.New(fmt.Sprintf(
+			"Expected %d check suites but found %d when listing '%s'", totalCount, len(suites), checkSuiteUrl))
+	}
+
+	return gh.FilterCheckSuiteStatuses(suites), nil
+}
+
+// GetCheckRuns lists all check runs in a check suite.
+func (gh *GithubClient) GetCheckRuns(checkRunsUrl string) ([]CheckRun, error) {
+	runs := []CheckRun{}
+	totalCount := 0
+
+	err := gh.getPaged(checkRunsUrl, func(data []byte) error {
+		page := CheckRuns{}
+		if err := json.Unmarshal(data, &page); err != nil {
+			return err
+		}
+		totalCount = page.Count
+		runs = append(runs, page.CheckRuns...)
+		return nil
+	})
+	if err != nil {
+		return []CheckRun{}, err
+	}
+
+	if len(runs) != totalCount {
+		return []CheckRun{}, errors.New(fmt.Sprintf(
+			"Expected %d check runs but found %d when listing '%s'", totalCount, len(runs), checkRunsUrl))
+	}
+
+	return runs, nil
+}
+
+// GetPullRequestFiles lists the files changed in a pull request. Github returns at
+// most 3000 files for a pull request.
+func (gh *GithubClient) GetPullRequestFiles(pullUrl string) ([]PullRequestFile, error) {
+	files := []PullRequestFile{}
+
+	err := gh.getPaged(pullUrl+"/files", func(data []byte) error {
+		page := []PullRequestFile{}
+		if err := json.Unmarshal(data, &page); err != nil {
+			return err
+		}
+		files = append(files, page...)
+		return nil
+	})
+	if err != nil {
+		return []PullRequestFile{}, err
+	}
+
+	return files, nil
+}
+
+// GetIssueComments lists all comments on an issue or pull request.
+func (gh *GithubClient) GetIssueComments(commentsUrl string) ([]IssueComment, error) {
+	comments := []IssueComment{}
+
+	err := gh.getPaged(commentsUrl, func(data []byte) error {
+		page := []IssueComment{}
+		if err := json.Unmarshal(data, &page); err != nil {
+			return err
+		}
+		comments = append(comments, page...)
+		return nil
+	})
+	if err != nil {
+		return []IssueComment{}, err
+	}
+
+	return comments, nil
+}
+
+// GetFileContents fetches and decodes a file from the contents API. Returns an error
+// matching IsNotFound if the file does not exist.
+func (gh *GithubClient) GetFileContents(contentsUrl string) ([]byte, error) {
+	target, err := gh.getUrl(contentsUrl)
+	if err != nil {
+		return nil, err
+	}
+
 	req, err := http.NewRequest("GET", target.String(), nil)
 	if err != nil {
-		return []CheckSuite{}, err
+		return nil, err
 	}
 
 	gh.setHeaders(req)
 
 	data, err := gh.request(req)
 	if err != nil {
-		return []CheckSuite{}, err
+		return nil, err
 	}
-	suites := CheckSuites{}
-	if err = json.Unmarshal(data, &suites); err != nil {
-		return []CheckSuite{}, err
+
+	file := FileContent{}
+	if err = json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	if file.Encoding != "base64" {
+		return nil, errors.New(fmt.Sprintf("Unsupported encoding '%s' for file '%s'", file.Encoding, file.Path))
 	}
 
-	return gh.FilterCheckSuiteStatuses(suites.CheckSuites), nil
+	return base64.StdEncoding.DecodeString(file.Content)
+}
+
+// LoadConfig replaces the client config with the config file from the default branch
+// of repo. If the repository has no config file, the current config is kept.
+func (gh *GithubClient) LoadConfig(repo Repo) error {
+	data, err := gh.GetFileContents(repo.GetContentsUrl(ConfigPath))
+	if IsNotFound(err) {
+		gh.Log.Info(fmt.Sprintf("No config file found at '%s', using default config.", ConfigPath))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	config, err := ParseConfig(data, gh.Config)
+	if err != nil {
+		return err
+	}
+
+	gh.Log.Info(fmt.Sprintf("Loaded config file '%s'.", ConfigPath))
+	gh.Config = config
+	return nil
 }
 
 func (gh *GithubClient) CreateIssueComment(commentsUrl string, body string) error {
@@ -155,16 +350,17 @@ func (gh *GithubClient) CreateIssueComment(commentsUrl string, body string) erro
 		return err
 	}
 
-	fmt.Println("Creating new issue comment with contents:")
-	fmt.Println("=====================================")
-	fmt.Println(body)
-	fmt.Println("=====================================")
+	gh.Log.Info("Creating new issue comment.", Fields{"body": body})
 
 	reqBody, err := NewIssueCommentBody(body)
 	if err != nil {
 		return err
 	}
 
+	if gh.skipWrite("POST", target.String(), reqBody, "Issue comment would be created") {
+		return nil
+	}
+
 	req, err := http.NewRequest("POST", target.String(), bytes.NewReader(reqBody))
 	if err != nil {
 		return err
@@ -176,33 +372,93 @@ func (gh *GithubClient) CreateIssueComment(commentsUrl string, body string) erro
 	return err
 }
 
+// request sends a request, retrying on transient errors if the method is idempotent.
 func (gh *GithubClient) request(req *http.Request) ([]byte, error) {
+	data, _, err := gh.requestWithRetry(req, isIdempotentMethod(req.Method))
+	return data, err
+}
+
+// requestWithRetry sends a request, retrying on transient errors if retryable is set.
+// The response of the final attempt is returned for callers that need its headers.
+func (gh *GithubClient) requestWithRetry(req *http.Request, retryable bool) ([]byte, *http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		data, resp, err := gh.send(req)
+		if err == nil || !retryable {
+			return data, resp, err
+		}
+
+		var body []byte
+		var httpErr *HttpError
+		if errors.As(err, &httpErr) {
+			body = httpErr.Body
+		}
+		delay, ok := gh.retry.getRetryDelay(attempt, resp, body)
+		if !ok {
+			return data, resp, err
+		}
+
+		gh.Log.Warn(fmt.Sprintf("[github] %s, retrying in %s (attempt %d of %d)",
+			err, delay.Round(time.Millisecond), attempt+1, gh.retry.MaxRetries),
+			Fields{"attempt": attempt + 1, "max_retries": gh.retry.MaxRetries, "delay_ms": delay.Milliseconds()})
+		gh.retry.sleep(delay)
+
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return []byte{}, nil, err
+			}
+		}
+	}
+}
+
+// send makes a single attempt at a request. The response is returned for inspection
+// of its headers even when the request failed with an http error status.
+func (gh *GithubClient) send(req *http.Request) ([]byte, *http.Response, error) {
+	// Set authorization per request rather than in setHeaders so that expiring
+	// credentials such as github app installation tokens are refreshed as needed.
+	authorization, err := gh.auth.GetAuthorization()
+	if err != nil {
+		return []byte{}, nil, err
+	}
+	req.Header.Set("Authorization", authorization)
+
+	if gh.RateLimit != nil {
+		gh.RateLimit.WaitForReset(gh.Log)
+	}
+
 	gh.logRequest(req)
 
 	resp, err := gh.client.Do(req)
+	observeApiRequest(gh.BaseUrl.Path, req, resp)
 	if err != nil {
-		return []byte{}, err
+		return []byte{}, nil, err
 	}
 
 	defer resp.Body.Close()
 	gh.logResponse(resp)
 	data, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return []byte{}, err
+		return []byte{}, resp, err
 	}
 
 	if resp.StatusCode >= 400 {
-		fmt.Println("Error Response:")
-		fmt.Println(fmt.Sprintf("%s", data))
-		return []byte{}, errors.New(fmt.Sprintf("Received http error %d", resp.StatusCode))
+		message := fmt.Sprintf("[github] %s %s returned %d", req.Method, req.URL.String(), resp.StatusCode)
+		fields := Fields{"status": resp.StatusCode, "response": string(data)}
+		// Not found is expected for lookups such as a missing config file or team
+		// membership, callers that cannot handle it return the error instead.
+		if resp.StatusCode == http.StatusNotFound {
+			gh.Log.Debug(message, fields)
+		} else {
+			gh.Log.Error(message, fields)
+		}
+		return []byte{}, resp, &HttpError{StatusCode: resp.StatusCode, Body: data}
 	}
 
-	return data, nil
+	return data, resp, nil
 }
 
-// logRequest logs the outgoing API call in the format:
+// logRequest logs the outgoing API call and its request body, if any, in the format:
 //
-//	[github] GET https://api.github.com/... {"body":"..."}
+//	[github] request method=GET url=https://api.github.com/...
 func (gh *GithubClient) logRequest(req *http.Request) {
 	body := ""
 	if req.GetBody != nil {
@@ -214,11 +470,15 @@ func (gh *GithubClient) logRequest(req *http.Request) {
 		}
 	}
 
-	fmt.Println(fmt.Sprintf("[github] %s %s %s", req.Method, req.URL.String(), body))
+	fields := Fields{"method": req.Method, "url": req.URL.String()}
+	if body != "" {
+		fields["body"] = body
+	}
+	gh.Log.Info("[github] request", fields)
 }
 
-// logResponse extracts the rate limit headers from a response and logs them,
-// prefixed by the response status, in the format:
+// logResponse extracts the rate limit headers from a response and logs them as numeric
+// fields, with a message prefixed by the response status in the format:
 //
 //	[github] status: 201, load: 1%, used: 105, remaining: 14895, reset: 00:19:31
 //
@@ -231,23 +491,24 @@ func (gh *GithubClient) logResponse(resp *http.Response) {
 	resetHeader := resp.Header.Get("x-ratelimit-reset")
 
 	if limitHeader == "" || remainingHeader == "" || resetHeader == "" {
-		fmt.Println("[github] missing ratelimit header(s) in response")
+		gh.Log.Info(fmt.Sprintf("[github] status: %d, missing ratelimit header(s) in response", resp.StatusCode),
+			Fields{"status": resp.StatusCode})
 		return
 	}
 
 	limit, err := strconv.Atoi(limitHeader)
 	if err != nil {
-		fmt.Println("[github] invalid x-ratelimit-limit header:", limitHeader)
+		gh.Log.Warn(fmt.Sprintf("[github] invalid x-ratelimit-limit header: %s", limitHeader), Fields{"status": resp.StatusCode})
 		return
 	}
 	remaining, err := strconv.Atoi(remainingHeader)
 	if err != nil {
-		fmt.Println("[github] invalid x-ratelimit-remaining header:", remainingHeader)
+		gh.Log.Warn(fmt.Sprintf("[github] invalid x-ratelimit-remaining header: %s", remainingHeader), Fields{"status": resp.StatusCode})
 		return
 	}
 	resetUnix, err := strconv.ParseInt(resetHeader, 10, 64)
 	if err != nil {
-		fmt.Println("[github] invalid x-ratelimit-reset header:", resetHeader)
+		gh.Log.Warn(fmt.Sprintf("[github] invalid x-ratelimit-reset header: %s", resetHeader), Fields{"status": resp.StatusCode})
 		return
 	}
 
@@ -259,7 +520,7 @@ func (gh *GithubClient) logResponse(resp *http.Response) {
 	// The rate limit window is one hour, so it started one hour before reset.
 	start := reset.Add(-time.Hour)
 	elapsed := now.Sub(start)
-    // Guard against clock skew causing time <1s or >1h
+	// Guard against clock skew causing time <1s or >1h
 	if elapsed < time.Second {
 		elapsed = time.Second
 	} else if elapsed > time.Hour {
@@ -271,7 +532,7 @@ func (gh *GithubClient) logResponse(resp *http.Response) {
 	// Example: If limit is 1000, and 6 minutes have elapsed (10% of 1 hour),
 	// availableLimit will be 100 (10% of total).
 	availableLimit := float64(limit) * elapsedFraction
-    // guard against very small limit causing divide-by-zero
+	// guard against very small limit causing divide-by-zero
 	if availableLimit < 1 {
 		availableLimit = 1
 	}
@@ -280,6 +541,29 @@ func (gh *GithubClient) logResponse(resp *http.Response) {
 	// before reset. Keep load < 50% for a safety margin.
 	load := float64(used) / availableLimit
 
-	fmt.Println(fmt.Sprintf("[github] status: %d, load: %s, used: %d, remaining: %d, reset: %s",
-		resp.StatusCode, toPercent(load), used, remaining, formatDuration(getDuration(now, reset))))
+	// The rate limit is tracked separately for each resource, e.g. core or search
+	resource := resp.Header.Get("x-ratelimit-resource")
+	if resource == "" {
+		resource = "core"
+	}
+	// Each installation has its own rate limit
+	labels := Labels{"resource": resource, "installation": strconv.Itoa(gh.installationId)}
+	metrics.Set(MetricRateLimitLoad, labels, load)
+	metrics.Set(MetricRateLimitLimit, labels, float64(limit))
+	metrics.Set(MetricRateLimitRemaining, labels, float64(remaining))
+	metrics.Set(MetricRateLimitReset, labels, float64(resetUnix))
+	if gh.RateLimit != nil {
+		gh.RateLimit.Observe(RateLimit{Resource: resource, Limit: limit, Remaining: remaining, Reset: reset, Load: load})
+	}
+
+	gh.Log.Info(fmt.Sprintf("[github] status: %d, load: %s, used: %d, remaining: %d, reset: %s",
+		resp.StatusCode, toPercent(load), used, remaining, formatDuration(getDuration(now, reset))),
+		Fields{
+			"status":              resp.StatusCode,
+			"ratelimit_load":      math.Round(load*1000) / 1000,
+			"ratelimit_limit":     limit,
+			"ratelimit_used":      used,
+			"ratelimit_remaining": remaining,
+			"ratelimit_reset":     resetUnix,
+		})
 }
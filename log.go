@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const LogLevelKey = "CHECK_ENFORCER_LOG_LEVEL"
+const LogFormatKey = "CHECK_ENFORCER_LOG_FORMAT"
+
+const (
+	LogFormatText = "text"
+	LogFormatJson = "json"
+)
+
+type LogLevel int
+
+const (
+	LogLevelDebug LogLevel = iota
+	LogLevelInfo
+	LogLevelWarn
+	LogLevelError
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+func (l LogLevel) String() string {
+	if l < LogLevelDebug || l > LogLevelError {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return logLevelNames[l]
+}
+
+func ParseLogLevel(level string) (LogLevel, error) {
+	for i, name := range logLevelNames {
+		if strings.EqualFold(level, name) {
+			return LogLevel(i), nil
+		}
+	}
+	return LogLevelInfo, errors.New(fmt.Sprintf("Invalid log level '%s', expected one of %s", level, strings.Join(logLevelNames, ", ")))
+}
+
+// Fields are structured values attached to a log line, e.g. the repository and pull
+// request of the event being handled.
+type Fields map[string]interface{}
+
+// Logger writes leveled log lines, either as text for people reading the action logs
+// or as one json object per line for log pipelines. Loggers created with With share
+// the output of their parent and add fields to every line.
+type Logger struct {
+	out    io.Writer
+	mutex  *sync.Mutex
+	level  LogLevel
+	format string
+	fields Fields
+	now    func() time.Time
+}
+
+// logger is the process logger, configured from flags in main. Clients log through a
+// copy with the fields of the event they are handling.
+var logger = NewLogger(os.Stdout, LogLevelInfo, LogFormatText)
+
+func NewLogger(out io.Writer, level LogLevel, format string) *Logger {
+	return &Logger{
+		out:    out,
+		mutex:  &sync.Mutex{},
+		level:  level,
+		format: format,
+		fields: Fields{},
+		now:    time.Now,
+	}
+}
+
+// NewLoggerFromOptions creates a logger writing to stdout for the level and format names.
+func NewLoggerFromOptions(level string, format string) (*Logger, error) {
+	logLevel, err := ParseLogLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	if format != LogFormatText && format != LogFormatJson {
+		return nil, errors.New(fmt.Sprintf("Invalid log format '%s', expected '%s' or '%s'", format, LogFormatText, LogFormatJson))
+	}
+	return NewLogger(os.Stdout, logLevel, format), nil
+}
+
+// With returns a logger that adds the fields to every line. Empty values are skipped,
+// so that callers can pass the fields they have without checking each one.
+func (l *Logger) With(fields Fields) *Logger {
+	child := *l
+	child.fields = Fields{}
+	for k, v := range l.fields {
+		child.fields[k] = v
+	}
+	for k, v := range fields {
+		if v == nil || v == "" || v == 0 {
+			continue
+		}
+		child.fields[k] = v
+	}
+	return &child
+}
+
+// Field returns the value of a field added with With, or nil if it is not set.
+func (l *Logger) Field(key string) interface{} {
+	return l.fields[key]
+}
+
+func (l *Logger) IsJson() bool {
+	return l.format == LogFormatJson
+}
+
+func (l *Logger) Debug(msg string, fields ...Fields) {
+	l.log(LogLevelDebug, msg, fields)
+}
+
+func (l *Logger) Info(msg string, fields ...Fields) {
+	l.log(LogLevelInfo, msg, fields)
+}
+
+func (l *Logger) Warn(msg string, fields ...Fields) {
+	l.log(LogLevelWarn, msg, fields)
+}
+
+func (l *Logger) Error(msg string, fields ...Fields) {
+	l.log(LogLevelError, msg, fields)
+}
+
+func (l *Logger) log(level LogLevel, msg string, lineFields []Fields) {
+	if level < l.level {
+		return
+	}
+
+	fields := Fields{}
+	for k, v := range l.fields {
+		fields[k] = v
+	}
+	for _, f := range lineFields {
+		for k, v := range f {
+			// Errors marshal to an empty json object, so log their message instead
+			if err, ok := v.(error); ok {
+				v = err.Error()
+			}
+			fields[k] = v
+		}
+	}
+
+	var line string
+	if l.format == LogFormatJson {
+		line = l.formatJson(level, msg, fields)
+	} else {
+		line = formatText(level, msg, fields)
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	fmt.Fprintln(l.out, line)
+}
+
+// formatJson formats a line as a json object with the time, level and msg keys
+// followed by the fields. Fields cannot replace the time, level or msg.
+func (l *Logger) formatJson(level LogLevel, msg string, fields Fields) string {
+	entry := map[string]interface{}{}
+	for k, v := range fields {
+		entry[k] = v
+	}
+	entry["time"] = l.now().UTC().Format(time.RFC3339Nano)
+	entry["level"] = level.String()
+	entry["msg"] = msg
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		data, _ = json.Marshal(map[string]interface{}{
+			"time":  entry["time"],
+			"level": entry["level"],
+			"msg":   msg,
+			"error": fmt.Sprintf("Unable to marshal log fields: %v", err),
+		})
+	}
+	return string(data)
+}
+
+// formatText formats a line in the format:
+//
+//	[info] message key=value key="value with spaces" key={"json":"value"}
+func formatText(level LogLevel, msg string, fields Fields) string {
+	keys := []string{}
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	line := strings.Builder{}
+	line.WriteString(fmt.Sprintf("[%s] %s", level, msg))
+	for _, k := range keys {
+		value := formatTextValue(fields[k])
+		if _, ok := fields[k].(string); ok && (value == "" || strings.ContainsAny(value, " \t\r\n\"=")) {
+			value = strconv.Quote(value)
+		}
+		line.WriteString(fmt.Sprintf(" %s=%s", k, value))
+	}
+	return line.String()
+}
+
+// formatTextValue formats structs, maps and slices as json, and anything else with %v.
+func formatTextValue(value interface{}) string {
+	switch value.(type) {
+	case string, bool, int, int64, float64, time.Duration, time.Time:
+		return fmt.Sprintf("%v", value)
+	}
+	if data, err := json.Marshal(value); err == nil {
+		return string(data)
+	}
+	return fmt.Sprintf("%v", value)
+}
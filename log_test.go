@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestLogger(level LogLevel, format string) (*Logger, *bytes.Buffer) {
+	out := &bytes.Buffer{}
+	log := NewLogger(out, level, format)
+	log.now = func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) }
+	return log, out
+}
+
+func getLogLines(assert *assert.Assertions, out *bytes.Buffer) []map[string]interface{} {
+	lines := []map[string]interface{}{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		entry := map[string]interface{}{}
+		assert.NoError(json.Unmarshal([]byte(line), &entry), line)
+		lines = append(lines, entry)
+	}
+	return lines
+}
+
+func TestJsonLogger(t *testing.T) {
+	assert := assert.New(t)
+	log, out := newTestLogger(LogLevelInfo, LogFormatJson)
+
+	log = log.With(Fields{"event": "check_suite", "delivery": "", "repo": "octo/repo", "pr": 0})
+	log.With(Fields{"pr": 12, "sha": "abc"}).Info("Handling check suite event.", Fields{"error": errors.New("oops"), "msg": "ignored"})
+	log.Debug("Skipped below the log level")
+	log.Warn("Without the pull request fields")
+
+	lines := getLogLines(assert, out)
+	assert.Len(lines, 2)
+	assert.Equal(map[string]interface{}{
+		"time":  "2024-01-02T03:04:05Z",
+		"level": "info",
+		"msg":   "Handling check suite event.",
+		"event": "check_suite",
+		"repo":  "octo/repo",
+		"pr":    float64(12),
+		"sha":   "abc",
+		"error": "oops",
+	}, lines[0])
+	assert.Equal("warn", lines[1]["level"])
+	assert.NotContains(lines[1], "pr")
+	assert.NotContains(lines[1], "delivery")
+}
+
+func TestTextLogger(t *testing.T) {
+	assert := assert.New(t)
+	log, out := newTestLogger(LogLevelDebug, LogFormatText)
+
+	log.With(Fields{"repo": "octo/repo", "pr": 12}).Debug("Loaded config.", Fields{"body": "two words", "audit": OverrideAudit{Action: "override"}})
+	assert.Equal(`[debug] Loaded config. audit={"action":"override","user":"","reason":"","repo":"","pull_number":0,"head_sha":"","comment_url":"","time":"0001-01-01T00:00:00Z"} body="two words" pr=12 repo=octo/repo`+"\n", out.String())
+}
+
+func TestLoggerOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	level, err := ParseLogLevel("WARN")
+	assert.NoError(err)
+	assert.Equal(LogLevelWarn, level)
+	_, err = ParseLogLevel("verbose")
+	assert.Error(err)
+
+	log, err := NewLoggerFromOptions("debug", LogFormatJson)
+	assert.NoError(err)
+	assert.True(log.IsJson())
+	_, err = NewLoggerFromOptions("info", "xml")
+	assert.Error(err)
+}
+
+func TestLogResponseFields(t *testing.T) {
+	assert := assert.New(t)
+	log, out := newTestLogger(LogLevelInfo, LogFormatJson)
+	gh, err := NewGithubClient("https://api.github.com", "")
+	assert.NoError(err)
+	gh.Log = log.With(Fields{"delivery": "72d3162e"})
+	gh.installationId = 42
+
+	reset := time.Now().Add(30 * time.Minute).Unix()
+	resp := &http.Response{StatusCode: 201, Header: http.Header{}}
+	resp.Header.Set("x-ratelimit-limit", "5000")
+	resp.Header.Set("x-ratelimit-remaining", "4000")
+	resp.Header.Set("x-ratelimit-reset", strconv.FormatInt(reset, 10))
+	gh.logResponse(resp)
+	gh.logResponse(&http.Response{StatusCode: 404, Header: http.Header{}})
+
+	lines := getLogLines(assert, out)
+	assert.Len(lines, 2)
+	assert.Equal("72d3162e", lines[0]["delivery"])
+	assert.Equal(float64(201), lines[0]["status"])
+	assert.Equal(float64(5000), lines[0]["ratelimit_limit"])
+	assert.Equal(float64(1000), lines[0]["ratelimit_used"])
+	assert.Equal(float64(4000), lines[0]["ratelimit_remaining"])
+	assert.Equal(float64(reset), lines[0]["ratelimit_reset"])
+	assert.InDelta(0.4, lines[0]["ratelimit_load"], 0.01)
+	assert.Equal(float64(404), lines[1]["status"])
+	assert.NotContains(lines[1], "ratelimit_limit")
+	assert.Equal(float64(4000), metrics.Get(MetricRateLimitRemaining, Labels{"resource": "core", "installation": "42"}), "Rate limit metrics are kept per installation")
+}
+
+func TestLogHttpErrors(t *testing.T) {
+	assert := assert.New(t)
+	log, out := newTestLogger(LogLevelDebug, LogFormatJson)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		}
+	}))
+	defer server.Close()
+	gh, err := NewGithubClient(server.URL, "")
+	assert.NoError(err)
+	gh.Log = log
+
+	for _, path := range []string{"/missing", "/invalid"} {
+		req, err := http.NewRequest("GET", server.URL+path, nil)
+		assert.NoError(err)
+		_, _, err = gh.send(req)
+		assert.Error(err)
+	}
+
+	levels := map[float64]string{}
+	for _, line := range getLogLines(assert, out) {
+		if status, ok := line["status"].(float64); ok && line["response"] != nil {
+			levels[status] = line["level"].(string)
+		}
+	}
+	assert.Equal(map[float64]string{404: "debug", 422: "error"}, levels, "Not found is expected for lookups")
+}
@@ -2,44 +2,60 @@ package main
 
 import (
 	"errors"
+	"flag"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 	"unicode"
+
+	"github.com/azure/azure-sdk-actions/cassette"
 )
 
 const GithubTokenKey = "GITHUB_TOKEN"
+const GithubApiUrlKey = "GITHUB_API_URL"
+const DefaultGithubApiUrl = "https://api.github.com"
 const CommitStatusContext = "https://aka.ms/azsdk/checkenforcer"
 const AzurePipelinesAppName = "Azure Pipelines"
 const GithubActionsAppName = "GitHub Actions"
 
-func newPendingBody() StatusBody {
+func newPendingBody(context string) StatusBody {
 	return StatusBody{
 		State:       CommitStatePending,
 		Description: "Waiting for all checks to succeed",
-		Context:     CommitStatusContext,
+		Context:     context,
 		TargetUrl:   getActionLink(),
 	}
 }
 
-func newSucceededBody() StatusBody {
+func newSucceededBody(context string) StatusBody {
 	return StatusBody{
 		State:       CommitStateSuccess,
 		Description: "All checks passed",
-		Context:     CommitStatusContext,
+		Context:     context,
 		TargetUrl:   getActionLink(),
 	}
 }
 
-// NOTE: This is currently unused as we post a pending state on check_suite failure,
-// but keep the function around for now in case we want to revert this behavior.
-func newFailedBody() StatusBody {
+func newFailedBody(context string) StatusBody {
 	return StatusBody{
 		State:       CommitStateFailure,
 		Description: "Some checks failed",
-		Context:     CommitStatusContext,
+		Context:     context,
+		TargetUrl:   getActionLink(),
+	}
+}
+
+func newErrorBody(context string) StatusBody {
+	return StatusBody{
+		State:       CommitStateError,
+		Description: "Some checks are stuck",
+		Context:     context,
 		TargetUrl:   getActionLink(),
 	}
 }
@@ -50,53 +66,313 @@ func main() {
 		os.Exit(1)
 	}
 
-	payloadPath := os.Args[1]
-	payload, err := ioutil.ReadFile(payloadPath)
+	options := NewDefaultOptions()
+	flags := flag.NewFlagSet("check-enforcer", flag.ExitOnError)
+	flags.Usage = help
+	options.AddFlags(flags)
+	flags.Parse(os.Args[1:])
+	if flags.NArg() == 0 {
+		help()
+		os.Exit(1)
+	}
+
+	run, ok := commands[flags.Arg(0)]
+	args := flags.Args()[1:]
+	if !ok {
+		// The github action passes the event payload path without a command
+		run = runReplay
+		args = flags.Args()
+	}
+	err := run(options, args)
+	if options.cassette != nil {
+		// Recordings are saved even if the command failed, to capture error scenarios
+		if closeErr := options.cassette.Close(); err == nil {
+			err = closeErr
+		}
+	}
 	handleError(err)
+}
+
+// Options are the settings shared by every command, from flags or environment variables.
+type Options struct {
+	ApiUrl           string
+	DryRun           bool
+	LogLevel         string
+	LogFormat        string
+	RateLimitMaxLoad float64
+	RateLimitReserve int
+	RecordCassette   string
+	ReplayCassette   string
+
+	// cassette records or replays every API call when a cassette option is set, see openCassette.
+	cassette *cassette.Recorder
+}
+
+func NewDefaultOptions() *Options {
+	options := &Options{
+		ApiUrl:    getDefaultApiUrl(),
+		DryRun:    getDefaultDryRun(),
+		LogLevel:  os.Getenv(LogLevelKey),
+		LogFormat: os.Getenv(LogFormatKey),
 
-	github_token := os.Getenv(GithubTokenKey)
-	if github_token == "" {
-		fmt.Println(fmt.Sprintf("WARNING: environment variable '%s' is not set", GithubTokenKey))
+		RateLimitMaxLoad: DefaultRateLimitMaxLoad,
+		RateLimitReserve: DefaultRateLimitReserve,
+	}
+	if options.LogLevel == "" {
+		options.LogLevel = LogLevelInfo.String()
 	}
+	if options.LogFormat == "" {
+		options.LogFormat = LogFormatText
+	}
+	if value := os.Getenv(RateLimitMaxLoadKey); value != "" {
+		maxLoad, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			logger.Warn(fmt.Sprintf("Ignoring invalid %s '%s'", RateLimitMaxLoadKey, value))
+		} else {
+			options.RateLimitMaxLoad = maxLoad
+		}
+	}
+	if value := os.Getenv(RateLimitReserveKey); value != "" {
+		reserve, err := strconv.Atoi(value)
+		if err != nil {
+			logger.Warn(fmt.Sprintf("Ignoring invalid %s '%s'", RateLimitReserveKey, value))
+		} else {
+			options.RateLimitReserve = reserve
+		}
+	}
+	return options
+}
 
-	gh, err := NewGithubClient("https://api.github.com", github_token, AzurePipelinesAppName, GithubActionsAppName)
-	handleError(err)
+// AddFlags adds a flag for each option, defaulting to its current value.
+func (o *Options) AddFlags(flags *flag.FlagSet) {
+	flags.StringVar(&o.ApiUrl, "api-url", o.ApiUrl, "Github REST API base url")
+	flags.BoolVar(&o.DryRun, "dry-run", o.DryRun, "Log writes instead of sending them to github")
+	flags.StringVar(&o.LogLevel, "log-level", o.LogLevel, "Minimum level to log: debug, info, warn or error")
+	flags.StringVar(&o.LogFormat, "log-format", o.LogFormat, "Log format: text or json")
+	flags.Float64Var(&o.RateLimitMaxLoad, "ratelimit-max-load", o.RateLimitMaxLoad, "Rate limit load above which non-essential calls are skipped")
+	flags.IntVar(&o.RateLimitReserve, "ratelimit-reserve", o.RateLimitReserve, "Remaining requests at which to wait for the rate limit to reset")
+	flags.StringVar(&o.RecordCassette, "record-cassette", o.RecordCassette, "Record API calls to a cassette file")
+	flags.StringVar(&o.ReplayCassette, "replay-cassette", o.ReplayCassette, "Replay API calls from a cassette file instead of calling github")
+}
 
-	err = handleEvent(gh, payload)
-	handleError(err)
+// openCassette starts recording to, or replaying from, the cassette options.
+func (o *Options) openCassette() error {
+	if o.RecordCassette != "" && o.ReplayCassette != "" {
+		return errors.New("Error: Only one of -record-cassette and -replay-cassette can be set.")
+	}
+	if o.RecordCassette != "" {
+		logger.Info(fmt.Sprintf("Recording API calls to cassette '%s'", o.RecordCassette))
+		o.cassette = cassette.NewRecorder(o.RecordCassette, nil)
+	}
+	if o.ReplayCassette != "" {
+		logger.Info(fmt.Sprintf("Replaying API calls from cassette '%s'", o.ReplayCassette))
+		recorder, err := cassette.Load(o.ReplayCassette)
+		if err != nil {
+			return err
+		}
+		o.cassette = recorder
+	}
+	return nil
 }
 
-func handleEvent(gh *GithubClient, payload []byte) error {
+// setLogger replaces the process logger with one for the log level and format options.
+func (o *Options) setLogger() error {
+	log, err := NewLoggerFromOptions(o.LogLevel, o.LogFormat)
+	if err != nil {
+		return err
+	}
+	logger = log
+	return nil
+}
+
+// getDefaultApiUrl returns the api url from GITHUB_API_URL, which github actions sets to
+// the api url of the server running the workflow, e.g. https://ghe.example.com/api/v3.
+func getDefaultApiUrl() string {
+	if apiUrl := os.Getenv(GithubApiUrlKey); apiUrl != "" {
+		return apiUrl
+	}
+	return DefaultGithubApiUrl
+}
+
+// newClientFactory returns a function that creates a client for the api url,
+// authenticated for a github app installation id. In dry-run mode every client
+// records its own writes. Clients for the same installation share its rate limit.
+func newClientFactory(options *Options) (func(installationId int) (*GithubClient, error), error) {
+	baseUrl := options.ApiUrl
+	logger.Info(fmt.Sprintf("Using github api url '%s'", baseUrl))
+	if options.DryRun {
+		logger.Info("Running in dry-run mode, writes will be logged instead of sent to github.")
+	}
+	var transport http.RoundTripper
+	if options.cassette != nil {
+		transport = options.cassette
+	}
+	getAuthenticator, err := newAuthenticatorFactory(baseUrl, transport)
+	if err != nil {
+		return nil, err
+	}
+
+	var mutex sync.Mutex
+	rateLimits := map[int]*RateLimitGuard{}
+
+	return func(installationId int) (*GithubClient, error) {
+		auth, err := getAuthenticator(installationId)
+		if err != nil {
+			return nil, err
+		}
+		gh, err := NewGithubClientWithAuth(baseUrl, auth, AzurePipelinesAppName, GithubActionsAppName)
+		if err != nil {
+			return nil, err
+		}
+		gh.installationId = installationId
+		if options.DryRun {
+			gh.DryRun = NewDryRun()
+		}
+		if transport != nil {
+			gh.SetTransport(transport)
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		if _, ok := rateLimits[installationId]; !ok {
+			rateLimits[installationId] = NewRateLimitGuard(options.RateLimitMaxLoad, options.RateLimitReserve)
+		}
+		gh.RateLimit = rateLimits[installationId]
+		return gh, nil
+	}, nil
+}
+
+// newAuthenticatorFactory authenticates as a github app if GITHUB_APP_ID is set, and
+// otherwise with the static GITHUB_TOKEN. The returned function creates an authenticator
+// for an app installation id, falling back to GITHUB_APP_INSTALLATION_ID if it is 0.
+func newAuthenticatorFactory(baseUrl string, transport http.RoundTripper) (func(installationId int) (Authenticator, error), error) {
+	appId := os.Getenv(GithubAppIdKey)
+	if appId == "" {
+		github_token := os.Getenv(GithubTokenKey)
+		if github_token == "" {
+			logger.Warn(fmt.Sprintf("Environment variable '%s' is not set", GithubTokenKey))
+		}
+		auth := NewTokenAuthenticator(github_token)
+		return func(int) (Authenticator, error) { return auth, nil }, nil
+	}
+
+	privateKey := []byte(os.Getenv(GithubAppPrivateKeyKey))
+	if keyPath := os.Getenv(GithubAppPrivateKeyPathKey); len(privateKey) == 0 && keyPath != "" {
+		var err error
+		privateKey, err = ioutil.ReadFile(keyPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	credentials, err := NewAppCredentials(baseUrl, appId, privateKey)
+	if err != nil {
+		return nil, err
+	}
+	if transport != nil {
+		credentials.client.Transport = transport
+	}
+
+	defaultInstallationId := 0
+	if id := os.Getenv(GithubAppInstallationIdKey); id != "" {
+		defaultInstallationId, err = strconv.Atoi(id)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s '%s': %w", GithubAppInstallationIdKey, id, err)
+		}
+	}
+
+	return func(installationId int) (Authenticator, error) {
+		if installationId == 0 {
+			installationId = defaultInstallationId
+		}
+		if installationId == 0 {
+			return nil, errors.New(fmt.Sprintf("No github app installation id in payload or %s", GithubAppInstallationIdKey))
+		}
+		return credentials.ForInstallation(installationId), nil
+	}, nil
+}
+
+// printBanner prints the banner for text logs. It is skipped for json logs, which
+// must be one json object per line.
+func printBanner(log *Logger) {
+	if log.IsJson() {
+		return
+	}
 	fmt.Println("################################################")
 	fmt.Println("#  AZURE SDK CHECK ENFORCER                    #")
 	fmt.Println("#  Docs: https://aka.ms/azsdk/checkenforcer    #")
 	fmt.Println("################################################")
 	fmt.Println()
+}
+
+// handleEvent handles a webhook payload of unknown type, as is the case for github
+// actions where the event payload file is passed in without its event name.
+func handleEvent(gh *GithubClient, payload []byte) error {
+	printBanner(gh.Log)
 
 	if ic := NewIssueCommentWebhook(payload); ic != nil {
-		err := handleIssueComment(gh, ic)
-		handleError(err)
-		return nil
+		return handleIssueComment(gh, ic)
 	}
 
 	if cs := NewCheckSuiteWebhook(payload); cs != nil {
-		err := handleCheckSuite(gh, cs)
-		handleError(err)
-		return nil
+		return handleCheckSuite(gh, cs)
 	}
 
 	if wr := NewWorkflowRunWebhook(payload); wr != nil {
-		err := handleWorkflowRun(gh, wr)
-		handleError(err)
-		return nil
+		return handleWorkflowRun(gh, wr)
+	}
+
+	if pr := NewPullRequestWebhook(payload); pr != nil {
+		return handlePullRequest(gh, pr)
+	}
+
+	if mg := NewMergeGroupWebhook(payload); mg != nil {
+		return handleMergeGroup(gh, mg)
+	}
+
+	if sw := NewScheduleWebhook(payload); sw != nil {
+		return handleSchedule(gh, sw)
 	}
 
 	return errors.New("Error: Invalid or unsupported payload body.")
 }
 
+// handleEventType handles a webhook payload for a known event type, as is the case
+// for webhook deliveries where the type is set in the X-GitHub-Event header.
+func handleEventType(gh *GithubClient, eventType string, payload []byte) error {
+	printBanner(gh.Log)
+
+	switch eventType {
+	case "issue_comment":
+		if ic := NewIssueCommentWebhook(payload); ic != nil {
+			return handleIssueComment(gh, ic)
+		}
+	case "check_suite":
+		if cs := NewCheckSuiteWebhook(payload); cs != nil {
+			return handleCheckSuite(gh, cs)
+		}
+	case "workflow_run":
+		if wr := NewWorkflowRunWebhook(payload); wr != nil {
+			return handleWorkflowRun(gh, wr)
+		}
+	case "pull_request":
+		if pr := NewPullRequestWebhook(payload); pr != nil {
+			return handlePullRequest(gh, pr)
+		}
+	case "merge_group":
+		if mg := NewMergeGroupWebhook(payload); mg != nil {
+			return handleMergeGroup(gh, mg)
+		}
+	default:
+		return fmt.Errorf("%w '%s'", errUnsupportedEvent, eventType)
+	}
+
+	return errors.New(fmt.Sprintf("Error: Invalid payload body for event type '%s'.", eventType))
+}
+
 func handleError(err error) {
 	if err != nil {
-		fmt.Println(err)
+		logger.Error(err.Error())
 		os.Exit(1)
 	}
 }
@@ -123,12 +399,12 @@ func sanitizeComment(comment string) string {
 	return string(result)
 }
 
-func getCheckEnforcerCommand(comment string) string {
+func getCheckEnforcerCommand(log *Logger, comment string) string {
 	comment = sanitizeComment(comment)
 	baseCommand := "/check-enforcer"
 
 	if !strings.HasPrefix(comment, baseCommand) {
-		fmt.Println(fmt.Sprintf("Skipping comment that does not start with '%s'", baseCommand))
+		log.Info(fmt.Sprintf("Skipping comment that does not start with '%s'", baseCommand))
 		return ""
 	}
 
@@ -137,92 +413,210 @@ func getCheckEnforcerCommand(comment string) string {
 	if len(matches) >= 1 {
 		command := matches[1]
 		if command == "override" || command == "evaluate" || command == "reset" || command == "help" {
-			fmt.Println("Parsed check enforcer command", command)
+			log.Info(fmt.Sprintf("Parsed check enforcer command %s", command))
 			return command
 		}
-		fmt.Println("Supported commands are 'override', 'evaluate', 'reset', or 'help' but found:", command)
+		log.Info(fmt.Sprintf("Supported commands are 'override', 'evaluate', 'reset', or 'help' but found: %s", command))
 		return command
 	} else {
-		fmt.Println("Command does not match format '/check-enforcer [override|reset|evaluate|help]'")
+		log.Info("Command does not match format '/check-enforcer [override|reset|evaluate|help]'")
 		return "UNKNOWN"
 	}
 }
 
-func setStatusForCheckSuiteConclusions(gh *GithubClient, checkSuites []CheckSuite, statusesUrl string) error {
-	successCount := 0
+func setStatusForCheckSuiteConclusions(gh *GithubClient, checkSuites []CheckSuite, target CommitTarget) error {
+	status, evaluation, err := getStatusForCheckSuiteConclusions(gh, checkSuites, target)
+	if err != nil {
+		return err
+	}
+	return publish(gh, target, status, evaluation)
+}
+
+// getStatusForCheckSuiteConclusions evaluates the check suites and returns the status to
+// publish for the commit. Missing and stuck checks are commented on along the way.
+func getStatusForCheckSuiteConclusions(gh *GithubClient, checkSuites []CheckSuite, target CommitTarget) (StatusBody, *Evaluation, error) {
+	evaluation, err := evaluateCheckSuites(gh, checkSuites, &target)
+	if err != nil {
+		return StatusBody{}, nil, err
+	}
+
+	if evaluation.State == CommitStateSuccess {
+		return newSucceededBody(gh.Config.StatusContext), evaluation, nil
+	}
 
-	for _, suite := range checkSuites {
-		fmt.Println(fmt.Sprintf("Check suite conclusion for '%s' is '%s'.", suite.App.Name, suite.Conclusion))
-		if IsCheckSuiteSucceeded(suite.Conclusion) {
-			successCount++
+	// Only comment once all triggered pipelines have finished, so that pipelines that
+	// are slow to register are not reported as missing.
+	if len(evaluation.Missing) > 0 && evaluation.isSettled() {
+		if err := commentMissingChecks(gh, target, evaluation.Missing); err != nil {
+			return StatusBody{}, nil, err
 		}
 	}
 
-	if successCount > 0 && successCount == len(checkSuites) {
-		return gh.SetStatus(statusesUrl, newSucceededBody())
+	if evaluation.State == CommitStateFailure {
+		status := newFailedBody(gh.Config.StatusContext)
+		status.Description = evaluation.Description
+		return status, evaluation, nil
+	}
+
+	stuck, err := findStuckChecks(gh, evaluation, time.Now())
+	if err != nil {
+		return StatusBody{}, nil, err
+	}
+	if len(stuck) > 0 {
+		if err := commentStuckChecks(gh, target, stuck); err != nil {
+			return StatusBody{}, nil, err
+		}
+		return newStuckBody(gh.Config.StatusContext, stuck), evaluation, nil
 	}
 
 	// A pending status is redundant with the default status, but it allows us to
 	// add more details to the status check in the UI such as a link back to the
 	// check enforcer run that evaluated pending.
-	return gh.SetStatus(statusesUrl, newPendingBody())
+	status := newPendingBody(gh.Config.StatusContext)
+	status.Description = evaluation.Description
+	return status, evaluation, nil
 }
 
 func handleIssueComment(gh *GithubClient, ic *IssueCommentWebhook) error {
-	fmt.Println("Handling issue comment event.")
+	gh.Log = gh.Log.With(Fields{"event": "issue_comment", "repo": ic.Repo.FullName, "pr": ic.Issue.Number})
+	gh.Log.Info("Handling issue comment event.")
 
-	command := getCheckEnforcerCommand(ic.Comment.Body)
+	command := getCheckEnforcerCommand(gh.Log, ic.Comment.Body)
 
 	if command == "" {
 		return nil
-	} else if command == "override" {
+	}
+
+	err := gh.LoadConfig(ic.Repo)
+	if err != nil {
+		return err
+	}
+
+	if command == "override" {
+		allowed, reason, err := authorizeOverride(gh, ic.Repo, ic.Comment.User.Login)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			gh.Log.Info(fmt.Sprintf("Denied override from '%s': %s", ic.Comment.User.Login, reason))
+			metrics.Inc(MetricOverrides, Labels{"result": "denied"})
+			return gh.CreateIssueComment(ic.GetCommentsUrl(), fmt.Sprintf("@%s you are not allowed to override check enforcer. %s", ic.Comment.User.Login, reason))
+		}
+		overrideReason := getOverrideReason(ic.Comment.Body)
+		if overrideReason == "" {
+			gh.Log.Info("Denied override without a reason.")
+			metrics.Inc(MetricOverrides, Labels{"result": "missing_reason"})
+			return gh.CreateIssueComment(ic.GetCommentsUrl(), fmt.Sprintf("@%s overrides require a reason, e.g. `/check-enforcer override <reason>`.", ic.Comment.User.Login))
+		}
 		pr, err := gh.GetPullRequest(ic.GetPullsUrl())
-		handleError(err)
-		return gh.SetStatus(pr.StatusesUrl, newSucceededBody())
+		if err != nil {
+			return err
+		}
+		audit := OverrideAudit{User: ic.Comment.User.Login, Reason: overrideReason, Repo: ic.Repo.FullName, CommentUrl: ic.Comment.HtmlUrl}
+		return applyOverride(gh, pr, audit, ic.GetCommentsUrl())
 	} else if command == "evaluate" || command == "reset" {
 		// We cannot use the commits url from the issue object because it
 		// is targeted to the main repo. To get all check suites for a commit,
 		// a request must be made to the repos API for the repository the pull
 		// request branch is from, which may be a fork.
 		pr, err := gh.GetPullRequest(ic.GetPullsUrl())
-		handleError(err)
-		checkSuites, err := gh.GetCheckSuiteStatuses(pr.GetCheckSuiteUrl())
-		handleError(err)
-
-		if checkSuites == nil || len(checkSuites) == 0 {
-			noPipelineText, err := ioutil.ReadFile("./comments/no_pipelines.txt")
-			handleError(err)
-			err = gh.CreateIssueComment(ic.GetCommentsUrl(), string(noPipelineText))
-			handleError(err)
+		if err != nil {
+			return err
 		}
-
-		return setStatusForCheckSuiteConclusions(gh, checkSuites, pr.StatusesUrl)
+		return evaluatePullRequest(gh, pr, ic.GetCommentsUrl())
 	} else {
-		helpText, err := ioutil.ReadFile("./comments/help.txt")
-		handleError(err)
-		err = gh.CreateIssueComment(ic.GetCommentsUrl(), string(helpText))
-		handleError(err)
+		if gh.shed("help comment") {
+			return nil
+		}
+		helpText, err := gh.Config.GetHelpComment()
+		if err != nil {
+			return err
+		}
+		err = gh.CreateIssueComment(ic.GetCommentsUrl(), helpText)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func handleCheckSuite(gh *GithubClient, cs *CheckSuiteWebhook) error {
-	fmt.Println("Handling check suite event.")
-
-	if cs.CheckSuite.HeadBranch == "main" {
-		fmt.Println("Skipping check suite for main branch.")
+// applyOverride posts a success status for the pull request head commit, comments with
+// the audit record and adds it to the audit log. The user, reason and repo of the audit
+// are set by the caller, and the user must already be authorized, see authorizeOverride.
+func applyOverride(gh *GithubClient, pr PullRequest, audit OverrideAudit, commentsUrl string) error {
+	gh.Log = gh.Log.With(Fields{"sha": pr.Head.Sha})
+	audit.Action = "override"
+	audit.PullNumber = pr.Number
+	audit.HeadSha = pr.Head.Sha
+	audit.Time = time.Now().UTC()
+
+	status := newSucceededBody(gh.Config.StatusContext)
+	status.Description = newOverrideDescription(audit)
+	target := CommitTarget{Repo: pr.Base.Repo, HeadSha: pr.Head.Sha, StatusesUrl: pr.StatusesUrl, PullNumber: pr.Number}
+	if err := publish(gh, target, status, nil); err != nil {
+		return err
+	}
+	overrideComment, err := newOverrideComment(audit)
+	if err != nil {
+		return err
+	}
+	if err = gh.CreateIssueComment(commentsUrl, overrideComment); err != nil {
+		return err
+	}
+	metrics.Inc(MetricOverrides, Labels{"result": "applied"})
+	if gh.DryRun != nil {
+		// The override was not applied, so it is not added to the audit log
+		gh.Log.Info("[dry-run] Skipping audit record for override.")
 		return nil
 	}
+	return writeAuditRecord(gh.Log, audit)
+}
 
-	eventIsFromSupportedApp := false
-	for _, app := range gh.AppTargets {
-		if app == cs.CheckSuite.App.Name {
-			eventIsFromSupportedApp = true
-			break
+// evaluatePullRequest evaluates the check suites of the pull request head commit and
+// publishes the result, commenting if no pipelines were triggered for it.
+func evaluatePullRequest(gh *GithubClient, pr PullRequest, commentsUrl string) error {
+	gh.Log = gh.Log.With(Fields{"sha": pr.Head.Sha})
+	checkSuites, err := gh.GetCheckSuiteStatuses(pr.GetCheckSuiteUrl())
+	if err != nil {
+		return err
+	}
+
+	if (checkSuites == nil || len(checkSuites) == 0) && !gh.shed("no pipelines comment") {
+		noPipelineText, err := gh.Config.GetNoPipelinesComment()
+		if err != nil {
+			return err
+		}
+		err = gh.CreateIssueComment(commentsUrl, noPipelineText)
+		if err != nil {
+			return err
 		}
 	}
 
+	target := CommitTarget{Repo: pr.Base.Repo, HeadSha: pr.Head.Sha, StatusesUrl: pr.StatusesUrl, PullNumber: pr.Number}
+	return setStatusForCheckSuiteConclusions(gh, checkSuites, target)
+}
+
+func handleCheckSuite(gh *GithubClient, cs *CheckSuiteWebhook) error {
+	target := NewCommitTarget(cs.Repo, cs.CheckSuite.HeadSha, cs.GetStatusesUrl(), cs.CheckSuite.PullRequests)
+	if target.PullNumber == 0 {
+		target.PullNumber = getMergeQueuePullNumber(cs.CheckSuite.HeadBranch)
+	}
+	gh.Log = gh.Log.With(Fields{"event": "check_suite", "repo": cs.Repo.FullName, "pr": target.PullNumber, "sha": target.HeadSha})
+	gh.Log.Info("Handling check suite event.")
+
+	err := gh.LoadConfig(cs.Repo)
+	if err != nil {
+		return err
+	}
+
+	if gh.Config.IsSkippedBranch(cs.CheckSuite.HeadBranch) {
+		gh.Log.Info(fmt.Sprintf("Skipping check suite for branch '%s'.", cs.CheckSuite.HeadBranch))
+		return nil
+	}
+
+	eventIsFromSupportedApp := gh.Config.IsTargetApp(cs.CheckSuite.App.Name)
+
 	// Ignore check suite events from apps that are not in the list of apps to target. This is to avoid
 	// race conditions with Github Actions events that show up in the check suites but are not workflows
 	// we have set to trigger check enforcer via the workflow_dispatch event in the workflow yaml.
@@ -233,50 +627,163 @@ func handleCheckSuite(gh *GithubClient, cs *CheckSuiteWebhook) error {
 	// Github Actions check suites intended as CI gates vs. generic runs without calling the check-runs
 	// API, which could many extra API calls per event.
 	if !eventIsFromSupportedApp {
-		fmt.Println("Skipping check suite evaluation for event from ignored github app", cs.CheckSuite.App.Name)
+		gh.Log.Info(fmt.Sprintf("Skipping check suite evaluation for event from ignored github app %s", cs.CheckSuite.App.Name))
 		// A pending status is redundant with the default status, but it allows us to
 		// add more details to the status check in the UI such as a link back to the
-		// check enforcer run that evaluated pending.
-		return gh.SetStatus(cs.GetStatusesUrl(), newPendingBody())
+		// check enforcer run that evaluated pending. Completed check runs are not
+		// re-opened, so a decided check run result is left in place instead of being
+		// replaced by a new in progress check run.
+		if publishesCheckRun(gh.Config) {
+			published, err := getPublishedStatus(gh, target)
+			if err != nil {
+				return err
+			}
+			if published != nil && published.State != CommitStatePending {
+				gh.Log.Info(fmt.Sprintf("Keeping the published '%s' result.", published.State))
+				return nil
+			}
+		}
+		return publish(gh, target, newPendingBody(gh.Config.StatusContext), nil)
 	}
 
-	if len(gh.AppTargets) > 1 {
+	if len(gh.Config.AppTargets) > 1 {
 		checkSuites, err := gh.GetCheckSuiteStatuses(cs.GetCheckSuiteUrl())
-		handleError(err)
-		return setStatusForCheckSuiteConclusions(gh, checkSuites, cs.GetStatusesUrl())
+		if err != nil {
+			return err
+		}
+		return setStatusForCheckSuiteConclusions(gh, checkSuites, target)
 	} else {
 		checkSuites := gh.FilterCheckSuiteStatuses([]CheckSuite{cs.CheckSuite})
-		return setStatusForCheckSuiteConclusions(gh, checkSuites, cs.GetStatusesUrl())
+		return setStatusForCheckSuiteConclusions(gh, checkSuites, target)
 	}
 }
 
 func handleWorkflowRun(gh *GithubClient, webhook *WorkflowRunWebhook) error {
 	workflowRun := webhook.WorkflowRun
-	fmt.Println("Handling workflow run event.")
-	fmt.Println(fmt.Sprintf("Workflow run url: %s", workflowRun.HtmlUrl))
-	fmt.Println(fmt.Sprintf("Workflow run commit: %s", workflowRun.HeadSha))
+	target := NewCommitTarget(workflowRun.Repo, workflowRun.HeadSha, workflowRun.GetStatusesUrl(), workflowRun.PullRequests)
+	if target.PullNumber == 0 {
+		target.PullNumber = getMergeQueuePullNumber(workflowRun.HeadBranch)
+	}
+	gh.Log = gh.Log.With(Fields{"event": "workflow_run", "repo": workflowRun.Repo.FullName, "pr": target.PullNumber, "sha": target.HeadSha})
+	gh.Log.Info("Handling workflow run event.")
+	gh.Log.Info(fmt.Sprintf("Workflow run url: %s", workflowRun.HtmlUrl))
+	gh.Log.Info(fmt.Sprintf("Workflow run commit: %s", workflowRun.HeadSha))
 
-	if workflowRun.Event != "pull_request" {
-		fmt.Println(fmt.Sprintf("Check enforcer only handles workflow_run events for pull requests. Skipping event for '%s'", workflowRun.Event))
+	if workflowRun.Event != "pull_request" && workflowRun.Event != "merge_group" {
+		gh.Log.Info(fmt.Sprintf("Check enforcer only handles workflow_run events for pull requests and merge groups. Skipping event for '%s'", workflowRun.Event))
 		return nil
 	}
 
+	err := gh.LoadConfig(workflowRun.Repo)
+	if err != nil {
+		return err
+	}
+
 	checkSuites, err := gh.GetCheckSuiteStatuses(workflowRun.GetCheckSuiteUrl())
-	handleError(err)
+	if err != nil {
+		return err
+	}
 
-	return setStatusForCheckSuiteConclusions(gh, checkSuites, workflowRun.GetStatusesUrl())
+	return setStatusForCheckSuiteConclusions(gh, checkSuites, target)
 }
 
 func help() {
 	help := `Update pull request status checks based on github webhook events.
 
 USAGE
-  go run main.go <payload json file>
+  go run main.go [options] <payload json file>
+  go run main.go [options] <command> [command flags] [options]
+
+COMMANDS
+  replay [-event <type>] <payload json file>
+            Handle a webhook payload file, the same as passing it without a command. The
+            event type is detected from the payload unless -event is passed.
+  evaluate -repo <owner/name> -pr <number>
+            Evaluate a pull request and publish the result, the same as commenting
+            '/check-enforcer evaluate'.
+  override -repo <owner/name> -pr <number> -reason <reason>
+            Override check enforcer for a pull request, the same as commenting
+            '/check-enforcer override <reason>'. The override is made for the user of the
+            token, who must be allowed to override by the repository config.
+  status -repo <owner/name> -pr <number>
+            Print the published status of a pull request, the status it would be evaluated
+            to now and the decision trace. Nothing is written.
+  simulate <timeline yaml file> ...
+            Run webhook event timelines against an in-memory fake github and report the
+            status check enforcer published after each step, see SIMULATE.
+  reconcile [owner/name ...]
+            Reconcile the open pull requests of the repositories, see RECONCILE.
+  serve [-addr :8080] [-reconcile-interval 1h -reconcile-repos owner/name,...]
+            Listen for webhook deliveries, see SERVE.
+
+  -repo defaults to GITHUB_REPOSITORY.
+
+OPTIONS
+  -api-url  Github REST API base url. Defaults to GITHUB_API_URL, or https://api.github.com if it
+            is not set. For github enterprise server use https://<hostname>/api/v3. Urls from
+            webhook payloads are rewritten to this url, e.g. to send requests through a proxy.
+  -dry-run  Log statuses, check runs and comments with their request bodies instead of sending
+            them, and print a summary of the skipped writes at the end of the run. Reads are
+            still sent to github. Defaults to CHECK_ENFORCER_DRY_RUN.
+  -log-level
+            Minimum level to log: debug, info, warn or error. Defaults to CHECK_ENFORCER_LOG_LEVEL,
+            or info if it is not set.
+  -log-format
+            'text' for readable logs, or 'json' for one json object per line with the event,
+            delivery, repo, pr and sha of the event being handled. Defaults to
+            CHECK_ENFORCER_LOG_FORMAT, or text if it is not set.
+  -ratelimit-max-load
+            Rate limit load above which non-essential calls, such as help comments, are skipped.
+            Statuses are always posted. Defaults to CHECK_ENFORCER_RATELIMIT_MAX_LOAD, or 0.5.
+  -ratelimit-reserve
+            When this many requests or fewer remain, wait for the rate limit to reset instead of
+            failing. Defaults to CHECK_ENFORCER_RATELIMIT_RESERVE, or 20.
+  -record-cassette
+            Record every API request and response to a cassette file, with tokens redacted.
+  -replay-cassette
+            Replay API responses from a cassette file instead of calling github. Requests must
+            match the recorded method, path, query and body, in the recorded order. Cassettes
+            are not supported by serve.
+
+SERVE
+  Listens for github webhook deliveries and handles them the same as payload files.
+  The webhook secret must be set in the GITHUB_WEBHOOK_SECRET environment variable.
+  Prometheus metrics are served on GET /metrics.
+  With -reconcile-interval and -reconcile-repos, the repositories are also reconciled on
+  that interval.
+
+RECONCILE
+  Re-evaluates the head commit of every open pull request in the repositories, or in
+  GITHUB_REPOSITORY if none are passed, and publishes the result where it is stale.
+  Overridden pull requests are skipped. Sweeps wait for the rate limit to reset when the
+  load is over -ratelimit-max-load. Payloads from a github actions schedule trigger
+  reconcile the repository of the workflow.
+
+SIMULATE
+  A timeline seeds a repository with a config file, permissions and pull requests, then
+  runs steps that add or update check suites and check runs, push commits and deliver
+  webhook events. Each step can expect a published state, description, error or comments,
+  and each pull request the sequence of published states. Exits with an error if any
+  expectation is not met. See testpayloads/timelines for examples.
+
+AUTHENTICATION
+  GITHUB_TOKEN                 Static token, used unless GITHUB_APP_ID is set
+  GITHUB_APP_ID                Authenticate as a github app installation
+  GITHUB_APP_PRIVATE_KEY       PEM encoded app private key
+  GITHUB_APP_PRIVATE_KEY_PATH  Path to the app private key, if GITHUB_APP_PRIVATE_KEY is not set
+  GITHUB_APP_INSTALLATION_ID   Installation id for payloads without an installation field
 
 BEHAVIORS
   complete:
     Sets the check enforcer status for a commit to the value of the check_suite status
-    Handles payload type: https://docs.github.com/en/developers/webhooks-and-events/webhooks/webhook-events-and-payloads#check_suite`
+    Handles payload type: https://docs.github.com/en/developers/webhooks-and-events/webhooks/webhook-events-and-payloads#check_suite
+  opened, synchronize, reopened:
+    Sets a pending status for the pull request head commit. In server mode, also comments
+    if no pipelines are triggered within the no_pipelines_grace period
+    Handles payload type: https://docs.github.com/en/developers/webhooks-and-events/webhooks/webhook-events-and-payloads#pull_request
+  checks_requested:
+    Sets the check enforcer status for a merge queue commit from its check suites
+    Handles payload type: https://docs.github.com/en/developers/webhooks-and-events/webhooks/webhook-events-and-payloads#merge_group`
 
 	fmt.Println(help)
 }
@@ -90,6 +90,12 @@ func getStatusBody(assert *assert.Assertions, req *http.Request) StatusBody {
 	return status
 }
 
+// isConfigRequest matches the contents API request for the repository config file.
+// Test servers respond with 404 so the default config is used.
+func isConfigRequest(req *http.Request) bool {
+	return strings.HasSuffix(req.URL.Path, "/contents/"+ConfigPath) && req.Method == "GET"
+}
+
 type TestCheckSuiteCase struct {
 	Description       string
 	AppTargets        []string
@@ -115,7 +121,9 @@ func NewCheckSuiteTestServer(
 	fn := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		response := []byte{}
 
-		if strings.Contains(checkSuite.GetCheckSuiteUrl(), req.URL.String()) && req.Method == "GET" {
+		if isConfigRequest(req) {
+			w.WriteHeader(http.StatusNotFound)
+		} else if strings.Contains(checkSuite.GetCheckSuiteUrl(), req.URL.Path) && req.Method == "GET" {
 			assert.Contains(req.URL.Path, checkSuite.CheckSuite.HeadSha, description)
 			response = payloads.MultipleCheckSuiteResponse
 			response = []byte(strings.Replace(string(response),
@@ -215,9 +223,13 @@ func NewCommentTestServer(
 
 	fn := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		response := []byte{}
-		if strings.Contains(issueCommentEvent.GetPullsUrl(), req.URL.String()) && req.Method == "GET" {
+		if isConfigRequest(req) {
+			w.WriteHeader(http.StatusNotFound)
+		} else if strings.HasSuffix(req.URL.Path, "/permission") && req.Method == "GET" {
+			response = []byte(`{"permission": "admin", "role_name": "admin"}`)
+		} else if strings.Contains(issueCommentEvent.GetPullsUrl(), req.URL.String()) && req.Method == "GET" {
 			response = payloads.PullRequestResponse
-		} else if strings.Contains(pullRequestResponse.GetCheckSuiteUrl(), req.URL.String()) && req.Method == "GET" {
+		} else if strings.Contains(pullRequestResponse.GetCheckSuiteUrl(), req.URL.Path) && req.Method == "GET" {
 			response = []byte(strings.ReplaceAll(
 				string(payloads.CheckSuiteResponse),
 				`"conclusion": "neutral"`,
@@ -232,7 +244,10 @@ func NewCommentTestServer(
 			response = payloads.NewCommentResponse
 			body, err := ioutil.ReadAll(req.Body)
 			assert.NoError(err, description)
-			assert.Equal(expectedComment, string(body), "%s: Comment body for command '%s'", description, inputComment)
+			// Override comments include a timestamp, and are checked in TestOverrideAudit
+			if getCheckEnforcerCommand(logger, inputComment) != "override" || getOverrideReason(inputComment) == "" {
+				assert.Equal(expectedComment, string(body), "%s: Comment body for command '%s'", description, inputComment)
+			}
 		} else {
 			assert.Fail("%s: Unexpected %s request to '%s'", description, req.Method, req.URL.String())
 		}
@@ -255,15 +270,18 @@ func TestComments(t *testing.T) {
 	assert.NoError(err)
 	helpComment, err := NewIssueCommentBody(string(payloads.HelpComment))
 	assert.NoError(err)
+	overrideReasonComment, err := NewIssueCommentBody("@Codertocat overrides require a reason, e.g. `/check-enforcer override <reason>`.")
+	assert.NoError(err)
 
 	servers := []*httptest.Server{}
 	apps := []string{"Octocat App"}
 	noMatchAppTarget := []string{"no-match"}
 
 	cases := []TestCommentCase{
-		{"override+success", "/check-enforcer override", CheckSuiteConclusionSuccess, CommitStateSuccess, true, false, "", apps},
-		{"override+failure", "/check-enforcer override", CheckSuiteConclusionFailure, CommitStateSuccess, true, false, "", apps},
-		{"comment spaces", "   /check-enforcer   override   ", CheckSuiteConclusionFailure, CommitStateSuccess, true, false, "", apps},
+		{"override+success", "/check-enforcer override docs only change", CheckSuiteConclusionSuccess, CommitStateSuccess, true, true, "", apps},
+		{"override+failure", "/check-enforcer override flaky test, see #123", CheckSuiteConclusionFailure, CommitStateSuccess, true, true, "", apps},
+		{"comment spaces", "   /check-enforcer   override   no pipelines   ", CheckSuiteConclusionFailure, CommitStateSuccess, true, true, "", apps},
+		{"override without reason", "/check-enforcer override", CheckSuiteConclusionFailure, "", false, true, string(overrideReasonComment), apps},
 		{"reset+success", "/check-enforcer reset", CheckSuiteConclusionSuccess, CommitStateSuccess, true, false, "", apps},
 		{"reset+failure", "/check-enforcer reset", CheckSuiteConclusionFailure, CommitStatePending, true, false, "", apps},
 		{"evaluate+success", "/check-enforcer evaluate", CheckSuiteConclusionSuccess, CommitStateSuccess, true, false, "", apps},
@@ -323,7 +341,9 @@ func NewWorkflowRunTestServer(
 	fn := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		response := []byte{}
 
-		if strings.Contains(workflowRun.WorkflowRun.GetCheckSuiteUrl(), req.URL.String()) && req.Method == "GET" {
+		if isConfigRequest(req) {
+			w.WriteHeader(http.StatusNotFound)
+		} else if strings.Contains(workflowRun.WorkflowRun.GetCheckSuiteUrl(), req.URL.Path) && req.Method == "GET" {
 			response = checkSuiteResponse
 		} else if strings.Contains(workflowRun.WorkflowRun.GetStatusesUrl(), req.URL.String()) && req.Method == "POST" {
 			assert.Contains(req.URL.Path, workflowRun.WorkflowRun.HeadSha)
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+const MissingChecksCommentPath = "./comments/missing_checks.txt"
+
+// ExpectedChecks maps changed file globs to the check runs they must produce. Paths
+// support "**" to match any number of directories, and checks are check run name
+// patterns matched with https://pkg.go.dev/path#Match.
+type ExpectedChecks struct {
+	Paths  []string `yaml:"paths"`
+	Checks []string `yaml:"checks"`
+}
+
+func (e ExpectedChecks) validate() error {
+	if len(e.Paths) == 0 || len(e.Checks) == 0 {
+		return fmt.Errorf("'expected_checks' entries must have at least one path and one check")
+	}
+	for _, pattern := range e.Paths {
+		for _, segment := range strings.Split(pattern, "/") {
+			if _, err := path.Match(segment, ""); err != nil {
+				return fmt.Errorf("invalid 'expected_checks' path '%s': %w", pattern, err)
+			}
+		}
+	}
+	for _, pattern := range e.Checks {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid 'expected_checks' check '%s': %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// matchGlob matches a slash separated file path against a pattern where "**" matches
+// zero or more directories and other segments are matched with path.Match.
+func matchGlob(pattern string, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern []string, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if matched, _ := path.Match(pattern[0], name[0]); !matched {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// getExpectedChecks returns the check run patterns expected for the changed files,
+// mapped to the first file that caused each check to be expected.
+func (c *Config) getExpectedChecks(files []PullRequestFile) map[string]string {
+	expected := map[string]string{}
+	for _, entry := range c.ExpectedChecks {
+		for _, file := range files {
+			if !matchesAnyGlob(entry.Paths, file.Filename) && !matchesAnyGlob(entry.Paths, file.PreviousFilename) {
+				continue
+			}
+			for _, check := range entry.Checks {
+				if _, ok := expected[check]; !ok {
+					expected[check] = file.Filename
+				}
+			}
+			break
+		}
+	}
+	return expected
+}
+
+func matchesAnyGlob(patterns []string, name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, pattern := range patterns {
+		if matchGlob(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluateExpectedChecks adds any check runs expected for the pull request's changed
+// files, but not found in the evaluated check suites, to the missing checks.
+func evaluateExpectedChecks(gh *GithubClient, target *CommitTarget, evaluation *Evaluation) error {
+	if target.PullNumber == 0 {
+		pullNumber, err := findPullNumber(gh, target)
+		if err != nil {
+			return err
+		}
+		if pullNumber == 0 {
+			// Without the changed files any check could be expected, e.g. a path
+			// filtered pipeline that never started, so the commit stays pending.
+			evaluation.trace("Could not resolve the pull request for commit '%s' to find its expected checks.", target.HeadSha)
+			evaluation.PendingReason = "Could not resolve the pull request to find expected checks"
+			return nil
+		}
+		target.PullNumber = pullNumber
+	}
+
+	files, err := gh.GetPullRequestFiles(target.GetPullUrl())
+	if err != nil {
+		return err
+	}
+
+	expected := gh.Config.getExpectedChecks(files)
+	checks := []string{}
+	for check := range expected {
+		checks = append(checks, check)
+	}
+	sort.Strings(checks)
+
+	for _, check := range checks {
+		found := false
+		for _, result := range evaluation.Results {
+			for _, run := range result.Runs {
+				if matched, _ := path.Match(check, run.Name); matched {
+					found = true
+				}
+			}
+		}
+		if found {
+			evaluation.trace("Expected check run '%s' for changed file '%s' was found.", check, expected[check])
+		} else {
+			evaluation.trace("Expected check run '%s' for changed file '%s' was not found.", check, expected[check])
+			evaluation.Missing = append(evaluation.Missing, check)
+		}
+	}
+
+	return nil
+}
+
+// findPullNumber looks up the open pull request for a commit when it was not included in
+// the webhook payload, as is the case for pull requests from forks.
+func findPullNumber(gh *GithubClient, target *CommitTarget) (int, error) {
+	url, err := gh.getUrl(target.GetCommitPullsUrl())
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest("GET", url.String(), nil)
+	if err != nil {
+		return 0, err
+	}
+
+	gh.setHeaders(req)
+
+	data, err := gh.request(req)
+	if err != nil {
+		return 0, err
+	}
+
+	pulls := []PullRequest{}
+	if err = json.Unmarshal(data, &pulls); err != nil {
+		return 0, err
+	}
+
+	for _, pr := range pulls {
+		if pr.State == "open" && pr.Head.Sha == target.HeadSha {
+			return pr.Number, nil
+		}
+	}
+	return 0, nil
+}
+
+// missingChecksMarker identifies the missing checks comment for a commit, so that it is
+// only posted once per commit no matter how many events are evaluated.
+func missingChecksMarker(headSha string) string {
+	return fmt.Sprintf("<!-- check-enforcer:missing-checks:%s -->", headSha)
+}
+
+// commentMissingChecks comments on the pull request with the missing checks, unless
+// the comment was already posted for this commit.
+func commentMissingChecks(gh *GithubClient, target CommitTarget, missing []string) error {
+	text, err := gh.Config.GetMissingChecksComment()
+	if err != nil {
+		return err
+	}
+
+	body := strings.Builder{}
+	body.WriteString(strings.TrimSpace(text) + "\n\n")
+	for _, check := range missing {
+		body.WriteString(fmt.Sprintf("- `%s`\n", check))
+	}
+
+	return commentOnce(gh, target, missingChecksMarker(target.HeadSha), body.String())
+}
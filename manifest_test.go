@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchGlob(t *testing.T) {
+	assert := assert.New(t)
+	for _, tc := range []struct {
+		Pattern  string
+		Name     string
+		Expected bool
+	}{
+		{"sdk/storage/**", "sdk/storage/azblob/client.go", true},
+		{"sdk/storage/**", "sdk/storage", true},
+		{"sdk/storage/**", "sdk/storagecache/go.mod", false},
+		{"sdk/*/go.mod", "sdk/storage/go.mod", true},
+		{"sdk/*/go.mod", "sdk/storage/azblob/go.mod", false},
+		{"**/go.mod", "go.mod", true},
+		{"**/go.mod", "sdk/storage/azblob/go.mod", true},
+		{"sdk/**/*.go", "sdk/core/a/b/c.go", true},
+		{"sdk/**/*.go", "sdk/core/a/b/c.md", false},
+		{"eng/**", "sdk/eng/x", false},
+		{"README.md", "README.md", true},
+	} {
+		assert.Equal(tc.Expected, matchGlob(tc.Pattern, tc.Name), "%s => %s", tc.Pattern, tc.Name)
+	}
+}
+
+func TestGetExpectedChecks(t *testing.T) {
+	assert := assert.New(t)
+	config, err := ParseConfig([]byte(`
+version: 1
+expected_checks:
+  - paths: ["sdk/storage/**"]
+    checks: ["go - storage - ci"]
+  - paths: ["sdk/core/**", "eng/**"]
+    checks: ["go - core - ci", "go - storage - ci"]
+`), NewDefaultConfig(AzurePipelinesAppName))
+	assert.NoError(err)
+
+	assert.Empty(config.getExpectedChecks([]PullRequestFile{{Filename: "README.md"}}))
+	assert.Equal(map[string]string{"go - storage - ci": "sdk/storage/go.mod"},
+		config.getExpectedChecks([]PullRequestFile{{Filename: "README.md"}, {Filename: "sdk/storage/go.mod"}}))
+	assert.Equal(map[string]string{"go - core - ci": "eng/ci.yml", "go - storage - ci": "eng/ci.yml"},
+		config.getExpectedChecks([]PullRequestFile{{Filename: "eng/ci.yml"}}))
+	// A file moved out of a path still expects the checks for that path
+	assert.Equal(map[string]string{"go - storage - ci": "sdk/other/go.mod"},
+		config.getExpectedChecks([]PullRequestFile{{Filename: "sdk/other/go.mod", PreviousFilename: "sdk/storage/go.mod"}}))
+
+	for _, invalid := range []string{
+		"version: 1\nexpected_checks:\n  - paths: [sdk/**]",
+		"version: 1\nexpected_checks:\n  - checks: [ci]",
+		"version: 1\nexpected_checks:\n  - paths: ['sdk/[']\n    checks: [ci]",
+	} {
+		_, err := ParseConfig([]byte(invalid), NewDefaultConfig(AzurePipelinesAppName))
+		assert.Error(err, invalid)
+	}
+}
+
+const expectedChecksConfig = `
+version: 1
+apps: [octocoders-linter]
+expected_checks:
+  - paths: ["sdk/storage/**"]
+    checks: ["go - storage - ci"]
+`
+
+type TestExpectedChecksCase struct {
+	Description       string
+	Event             []byte
+	Files             []string
+	Runs              []CheckRun
+	ExistingComments  []string
+	ExpectedState     CommitState
+	ShouldPostComment bool
+}
+
+func TestExpectedChecks(t *testing.T) {
+	assert := assert.New(t)
+	payloads, err := getPayloads()
+	assert.NoError(err)
+	checkSuite := NewCheckSuiteWebhook(payloads.CheckSuiteEvent)
+	assert.NotEmpty(checkSuite)
+	sha := checkSuite.CheckSuite.HeadSha
+	completed := CheckSuiteStatusCompleted
+	success := CheckSuiteConclusionSuccess
+	forkEvent := []byte(strings.Replace(string(payloads.CheckSuiteEvent), `"pull_requests": [`, `"pull_requests": [], "ignored": [`, 1))
+	inProgressEvent := []byte(strings.ReplaceAll(string(payloads.CheckSuiteEvent), `"conclusion": "success"`, `"conclusion": null`))
+
+	for _, tc := range []TestExpectedChecksCase{
+		{"no expected checks", payloads.CheckSuiteEvent, []string{"README.md"},
+			[]CheckRun{newCheckRun("lint", completed, success)}, nil, CommitStateSuccess, false},
+		{"expected check found", payloads.CheckSuiteEvent, []string{"sdk/storage/go.mod"},
+			[]CheckRun{newCheckRun("go - storage - ci", completed, success)}, nil, CommitStateSuccess, false},
+		{"expected check missing", payloads.CheckSuiteEvent, []string{"sdk/storage/go.mod"},
+			[]CheckRun{newCheckRun("lint", completed, success)}, nil, CommitStatePending, true},
+		{"expected check missing for fork", forkEvent, []string{"sdk/storage/go.mod"},
+			[]CheckRun{newCheckRun("lint", completed, success)}, nil, CommitStatePending, true},
+		{"expected check missing already commented", payloads.CheckSuiteEvent, []string{"sdk/storage/go.mod"},
+			[]CheckRun{newCheckRun("lint", completed, success)}, []string{"hello", missingChecksMarker(sha)}, CommitStatePending, false},
+		{"expected check missing for other commit", payloads.CheckSuiteEvent, []string{"sdk/storage/go.mod"},
+			[]CheckRun{newCheckRun("lint", completed, success)}, []string{missingChecksMarker("abc")}, CommitStatePending, true},
+		{"expected check missing while in progress", inProgressEvent, []string{"sdk/storage/go.mod"},
+			[]CheckRun{newCheckRun("lint", CheckSuiteStatusInProgress, "")}, nil, CommitStatePending, false},
+	} {
+		var postedState CommitState
+		postedComment := false
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			switch {
+			case isConfigRequest(req):
+				w.Write(newConfigResponse(expectedChecksConfig))
+			case req.URL.Path == "/repos/Codertocat/Hello-World/check-suites/118578147/check-runs":
+				json.NewEncoder(w).Encode(CheckRuns{Count: len(tc.Runs), CheckRuns: tc.Runs})
+			case req.URL.Path == fmt.Sprintf("/repos/Codertocat/Hello-World/commits/%s/pulls", sha):
+				w.Write([]byte(fmt.Sprintf(`[{"number": 1, "state": "closed", "head": {"sha": "%s"}}, {"number": 2, "state": "open", "head": {"sha": "%s"}}]`, sha, sha)))
+			case req.URL.Path == "/repos/Codertocat/Hello-World/pulls/2/files":
+				files := []PullRequestFile{}
+				for _, f := range tc.Files {
+					files = append(files, PullRequestFile{Filename: f})
+				}
+				json.NewEncoder(w).Encode(files)
+			case req.URL.Path == "/repos/Codertocat/Hello-World/issues/2/comments" && req.Method == "GET":
+				comments := []IssueComment{}
+				for _, c := range tc.ExistingComments {
+					comments = append(comments, IssueComment{Body: c})
+				}
+				json.NewEncoder(w).Encode(comments)
+			case req.URL.Path == "/repos/Codertocat/Hello-World/issues/2/comments" && req.Method == "POST":
+				postedComment = true
+				data, err := ioutil.ReadAll(req.Body)
+				assert.NoError(err)
+				body := IssueCommentBody{}
+				assert.NoError(json.Unmarshal(data, &body))
+				assert.True(strings.HasPrefix(body.Body, missingChecksMarker(sha)), tc.Description)
+				assert.Contains(body.Body, "- `go - storage - ci`", tc.Description)
+				w.Write(payloads.NewCommentResponse)
+			case strings.Contains(checkSuite.GetStatusesUrl(), req.URL.Path) && req.Method == "POST":
+				postedState = getStatusBody(assert, req).State
+				w.Write(payloads.StatusResponse)
+			default:
+				assert.Fail(fmt.Sprintf("%s: Unexpected %s request to '%s'", tc.Description, req.Method, req.URL.String()))
+			}
+		}))
+		defer server.Close()
+
+		gh, err := NewGithubClient(server.URL, "", "octocoders-linter")
+		assert.NoError(err)
+
+		fmt.Println(fmt.Sprintf("\n\n========= %s =========", tc.Description))
+		err = handleEvent(gh, tc.Event)
+		assert.NoError(err, tc.Description)
+		assert.Equal(tc.ExpectedState, postedState, tc.Description)
+		assert.Equal(tc.ShouldPostComment, postedComment, tc.Description)
+	}
+}
+
+func TestExpectedChecksWithoutPullRequest(t *testing.T) {
+	assert := assert.New(t)
+	payloads, err := getPayloads()
+	assert.NoError(err)
+	checkSuite := NewCheckSuiteWebhook(payloads.CheckSuiteEvent)
+	sha := checkSuite.CheckSuite.HeadSha
+	// Fork heads are often not listed for the commit either
+	forkEvent := []byte(strings.Replace(string(payloads.CheckSuiteEvent), `"pull_requests": [`, `"pull_requests": [], "ignored": [`, 1))
+
+	var posted *StatusBody
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case isConfigRequest(req):
+			w.Write(newConfigResponse(expectedChecksConfig))
+		case req.URL.Path == "/repos/Codertocat/Hello-World/check-suites/118578147/check-runs":
+			runs := []CheckRun{newCheckRun("lint", CheckSuiteStatusCompleted, CheckSuiteConclusionSuccess)}
+			json.NewEncoder(w).Encode(CheckRuns{Count: len(runs), CheckRuns: runs})
+		case req.URL.Path == fmt.Sprintf("/repos/Codertocat/Hello-World/commits/%s/pulls", sha):
+			w.Write([]byte(`[]`))
+		case strings.Contains(checkSuite.GetStatusesUrl(), req.URL.Path) && req.Method == "POST":
+			status := getStatusBody(assert, req)
+			posted = &status
+			w.Write(payloads.StatusResponse)
+		default:
+			assert.Fail(fmt.Sprintf("Unexpected %s request to '%s'", req.Method, req.URL.String()))
+		}
+	}))
+	defer server.Close()
+
+	gh, err := NewGithubClient(server.URL, "", "octocoders-linter")
+	assert.NoError(err)
+	assert.NoError(handleEvent(gh, forkEvent))
+	if assert.NotNil(posted) {
+		assert.Equal(CommitStatePending, posted.State)
+		assert.Equal("Could not resolve the pull request to find expected checks", posted.Description)
+	}
+}
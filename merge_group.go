@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const MergeGroupActionChecksRequested ActionType = "checks_requested"
+
+// MergeQueueBranchPrefix is the prefix of the temporary branches github creates for
+// merge group commits, e.g. "gh-readonly-queue/main/pr-123-<base sha>".
+const MergeQueueBranchPrefix = "gh-readonly-queue/"
+
+var mergeQueuePullRegex = regexp.MustCompile(`/pr-(\d+)-[0-9a-f]+$`)
+
+func isMergeQueueBranch(branch string) bool {
+	return strings.HasPrefix(strings.TrimPrefix(branch, "refs/heads/"), MergeQueueBranchPrefix)
+}
+
+// getMergeQueuePullNumber returns the number of the last pull request in a merge
+// group from its branch name, or 0 if the branch is not a merge queue branch.
+func getMergeQueuePullNumber(branch string) int {
+	if !isMergeQueueBranch(branch) {
+		return 0
+	}
+	matches := mergeQueuePullRegex.FindStringSubmatch(branch)
+	if matches == nil {
+		return 0
+	}
+	number, _ := strconv.Atoi(matches[1])
+	return number
+}
+
+// handleMergeGroup evaluates the check suites of a merge group commit when github
+// requests checks for it. The merge group commit is not part of any pull request, so
+// the required status must be posted to it directly for the merge queue to proceed.
+func handleMergeGroup(gh *GithubClient, webhook *MergeGroupWebhook) error {
+	gh.Log = gh.Log.With(Fields{
+		"event": "merge_group",
+		"repo":  webhook.Repo.FullName,
+		"pr":    getMergeQueuePullNumber(webhook.MergeGroup.HeadRef),
+		"sha":   webhook.MergeGroup.HeadSha,
+	})
+	gh.Log.Info("Handling merge group event.")
+	gh.Log.Info(fmt.Sprintf("Merge group branch: %s", webhook.MergeGroup.HeadRef))
+	gh.Log.Info(fmt.Sprintf("Merge group commit: %s", webhook.MergeGroup.HeadSha))
+
+	if webhook.Action != MergeGroupActionChecksRequested {
+		gh.Log.Info(fmt.Sprintf("Skipping merge group event with action '%s'.", webhook.Action))
+		return nil
+	}
+
+	err := gh.LoadConfig(webhook.Repo)
+	if err != nil {
+		return err
+	}
+
+	checkSuites, err := gh.GetCheckSuiteStatuses(webhook.GetCheckSuiteUrl())
+	if err != nil {
+		return err
+	}
+
+	target := CommitTarget{
+		Repo:        webhook.Repo,
+		HeadSha:     webhook.MergeGroup.HeadSha,
+		StatusesUrl: webhook.GetStatusesUrl(),
+		PullNumber:  getMergeQueuePullNumber(webhook.MergeGroup.HeadRef),
+	}
+	return setStatusForCheckSuiteConclusions(gh, checkSuites, target)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeQueueBranch(t *testing.T) {
+	assert := assert.New(t)
+	for _, tc := range []struct {
+		Branch     string
+		MergeQueue bool
+		PullNumber int
+	}{
+		{"gh-readonly-queue/main/pr-123-ec26c3e57ca3a959ca5aad62de7213c562f8c821", true, 123},
+		{"refs/heads/gh-readonly-queue/release/1.0/pr-7-ec26c3e57ca3a959ca5aad62de7213c562f8c821", true, 7},
+		{"gh-readonly-queue/main/unexpected", true, 0},
+		{"main", false, 0},
+		{"feature/pr-123-ec26c3e", false, 0},
+	} {
+		assert.Equal(tc.MergeQueue, isMergeQueueBranch(tc.Branch), tc.Branch)
+		assert.Equal(tc.PullNumber, getMergeQueuePullNumber(tc.Branch), tc.Branch)
+	}
+
+	config, err := ParseConfig([]byte("version: 1\nskip_branches: ['*', 'gh-readonly-queue/*/*']"), NewDefaultConfig(AzurePipelinesAppName))
+	assert.NoError(err)
+	assert.True(config.IsSkippedBranch("main"))
+	assert.False(config.IsSkippedBranch("gh-readonly-queue/main/pr-123-ec26c3e57ca3a959ca5aad62de7213c562f8c821"))
+}
+
+type TestMergeGroupCase struct {
+	Description      string
+	EventType        string
+	Event            []byte
+	InjectConclusion CheckSuiteConclusion
+	ExpectedState    CommitState
+}
+
+func TestMergeGroup(t *testing.T) {
+	assert := assert.New(t)
+	payloads, err := getPayloads()
+	assert.NoError(err)
+	mergeGroupEvent, err := ioutil.ReadFile("./testpayloads/merge_group_event.json")
+	assert.NoError(err)
+	destroyedEvent := []byte(strings.Replace(string(mergeGroupEvent), `"checks_requested"`, `"destroyed"`, 1))
+	sha := "f95f852bd8fca8fcc58a9a2d6c842781e32a215e"
+
+	for _, tc := range []TestMergeGroupCase{
+		{"success", "", mergeGroupEvent, CheckSuiteConclusionSuccess, CommitStateSuccess},
+		{"failure", "", mergeGroupEvent, CheckSuiteConclusionFailure, CommitStatePending},
+		{"event type", "merge_group", mergeGroupEvent, CheckSuiteConclusionSuccess, CommitStateSuccess},
+		{"destroyed", "", destroyedEvent, CheckSuiteConclusionSuccess, ""},
+	} {
+		var postedState CommitState
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			switch {
+			case isConfigRequest(req):
+				w.WriteHeader(http.StatusNotFound)
+			case req.URL.Path == fmt.Sprintf("/repos/Codertocat/Hello-World/commits/%s/check-suites", sha):
+				w.Write([]byte(strings.ReplaceAll(string(payloads.CheckSuiteResponse),
+					`"conclusion": "neutral"`, fmt.Sprintf(`"conclusion": "%s"`, tc.InjectConclusion))))
+			case req.URL.Path == "/repos/Codertocat/Hello-World/statuses/"+sha && req.Method == "POST":
+				postedState = getStatusBody(assert, req).State
+				w.Write(payloads.StatusResponse)
+			default:
+				assert.Fail(fmt.Sprintf("%s: Unexpected %s request to '%s'", tc.Description, req.Method, req.URL.String()))
+			}
+		}))
+		defer server.Close()
+
+		gh, err := NewGithubClient(server.URL, "", "Octocat App")
+		assert.NoError(err)
+
+		if tc.EventType != "" {
+			err = handleEventType(gh, tc.EventType, tc.Event)
+		} else {
+			err = handleEvent(gh, tc.Event)
+		}
+		assert.NoError(err, tc.Description)
+		assert.Equal(tc.ExpectedState, postedState, tc.Description)
+	}
+}
+
+func TestMergeQueueCheckSuite(t *testing.T) {
+	assert := assert.New(t)
+	payloads, err := getPayloads()
+	assert.NoError(err)
+	branch := "gh-readonly-queue/main/pr-2-ec26c3e57ca3a959ca5aad62de7213c562f8c821"
+	event := []byte(strings.Replace(string(payloads.CheckSuiteEvent), `"head_branch": "changes"`, fmt.Sprintf(`"head_branch": "%s"`, branch), 1))
+	checkSuite := NewCheckSuiteWebhook(event)
+	assert.NotEmpty(checkSuite)
+
+	var postedState CommitState
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case isConfigRequest(req):
+			w.Write(newConfigResponse("version: 1\napps: [octocoders-linter]\nskip_branches: ['*']"))
+		case strings.Contains(checkSuite.GetStatusesUrl(), req.URL.Path) && req.Method == "POST":
+			postedState = getStatusBody(assert, req).State
+			w.Write(payloads.StatusResponse)
+		default:
+			assert.Fail(fmt.Sprintf("Unexpected %s request to '%s'", req.Method, req.URL.String()))
+		}
+	}))
+	defer server.Close()
+
+	gh, err := NewGithubClient(server.URL, "", "octocoders-linter")
+	assert.NoError(err)
+	assert.NoError(handleEvent(gh, event))
+	assert.Equal(CommitStateSuccess, postedState)
+}
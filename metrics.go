@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	MetricEvents             = "check_enforcer_events_total"
+	MetricStatuses           = "check_enforcer_statuses_total"
+	MetricOverrides          = "check_enforcer_overrides_total"
+	MetricApiRequests        = "check_enforcer_api_requests_total"
+	MetricReconciled         = "check_enforcer_reconciled_total"
+	MetricRateLimitLoad      = "check_enforcer_ratelimit_load"
+	MetricRateLimitLimit     = "check_enforcer_ratelimit_limit"
+	MetricRateLimitRemaining = "check_enforcer_ratelimit_remaining"
+	MetricRateLimitReset     = "check_enforcer_ratelimit_reset_timestamp_seconds"
+)
+
+const (
+	MetricTypeCounter = "counter"
+	MetricTypeGauge   = "gauge"
+)
+
+// Labels identify a series of a metric, e.g. {event="check_suite",outcome="ok"}.
+type Labels map[string]string
+
+type metricDefinition struct {
+	Help string
+	Type string
+}
+
+var metricDefinitions = map[string]metricDefinition{
+	MetricEvents:             {"Webhook events handled, by event type and outcome.", MetricTypeCounter},
+	MetricStatuses:           {"Commit statuses posted, by state.", MetricTypeCounter},
+	MetricOverrides:          {"Override commands, by result.", MetricTypeCounter},
+	MetricApiRequests:        {"Github API requests, by method, endpoint and status code.", MetricTypeCounter},
+	MetricReconciled:         {"Pull requests checked by reconcile sweeps, by result.", MetricTypeCounter},
+	MetricRateLimitLoad:      {"Ratio of used requests to the requests available so far in the rate limit window. Over 1 means the limit will be hit before it resets.", MetricTypeGauge},
+	MetricRateLimitLimit:     {"Requests allowed in the rate limit window.", MetricTypeGauge},
+	MetricRateLimitRemaining: {"Requests remaining in the rate limit window.", MetricTypeGauge},
+	MetricRateLimitReset:     {"Unix time when the rate limit window resets.", MetricTypeGauge},
+}
+
+// Metrics holds counters and gauges, and serves them in the prometheus text
+// exposition format. Series are keyed by their rendered labels.
+type Metrics struct {
+	mutex  sync.Mutex
+	values map[string]map[string]float64
+}
+
+// metrics is the process metrics registry, served on /metrics in server mode.
+var metrics = NewMetrics()
+
+func NewMetrics() *Metrics {
+	return &Metrics{values: map[string]map[string]float64{}}
+}
+
+func (m *Metrics) Inc(name string, labels Labels) {
+	m.update(name, labels, func(value float64) float64 { return value + 1 })
+}
+
+func (m *Metrics) Set(name string, labels Labels, value float64) {
+	m.update(name, labels, func(float64) float64 { return value })
+}
+
+// Get returns the current value of a series, or 0 if it was never updated.
+func (m *Metrics) Get(name string, labels Labels) float64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.values[name][formatLabels(labels)]
+}
+
+func (m *Metrics) update(name string, labels Labels, fn func(float64) float64) {
+	if _, ok := metricDefinitions[name]; !ok {
+		panic(fmt.Sprintf("Undefined metric '%s'", name))
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	series, ok := m.values[name]
+	if !ok {
+		series = map[string]float64{}
+		m.values[name] = series
+	}
+	key := formatLabels(labels)
+	series[key] = fn(series[key])
+}
+
+// Write writes every metric with at least one series, sorted by name and labels.
+func (m *Metrics) Write(w io.Writer) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	names := []string{}
+	for name := range m.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		definition := metricDefinitions[name]
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, definition.Help, name, definition.Type); err != nil {
+			return err
+		}
+
+		keys := []string{}
+		for key := range m.values[name] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			value := strconv.FormatFloat(m.values[name][key], 'f', -1, 64)
+			if _, err := fmt.Fprintf(w, "%s%s %s\n", name, key, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := m.Write(w); err != nil {
+		logger.Error(fmt.Sprintf("Error writing metrics: %v", err))
+	}
+}
+
+// formatLabels renders labels sorted by name, e.g. {code="200",method="GET"}, or an
+// empty string if there are none.
+func formatLabels(labels Labels) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := []string{}
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := []string{}
+	for _, name := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, escapeLabelValue(labels[name])))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escapeLabelValue(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	return strings.ReplaceAll(value, "\n", `\n`)
+}
+
+var shaSegmentRegex = regexp.MustCompile(`^[0-9a-f]{40}$`)
+var numberSegmentRegex = regexp.MustCompile(`^[0-9]+$`)
+
+// getApiEndpoint returns the path of an API request with owners, repositories, users,
+// numbers and commits replaced by placeholders, e.g. /repos/{owner}/{repo}/statuses/{sha},
+// to keep the number of series for API requests low. basePath is the path of the api
+// base url, e.g. /api/v3, and is removed.
+func getApiEndpoint(basePath string, path string) string {
+	basePath = strings.TrimSuffix(basePath, "/")
+	if basePath != "" && hasPathPrefix(path, basePath) {
+		path = strings.TrimPrefix(path, basePath)
+	}
+
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i, segment := range segments {
+		switch {
+		case i > 0 && segments[0] == "repos" && i <= 2:
+			segments[i] = []string{"", "{owner}", "{repo}"}[i]
+		case i == 1 && segments[0] == "orgs":
+			segments[i] = "{org}"
+		case i > 0 && segments[i-1] == "teams" && segments[0] == "orgs":
+			segments[i] = "{team}"
+		case i > 0 && (segments[i-1] == "memberships" || segments[i-1] == "collaborators"):
+			segments[i] = "{user}"
+		case i > 0 && segments[i-1] == "contents":
+			// The rest of the path is the file path in the repository
+			return "/" + strings.Join(append(segments[:i], "{path}"), "/")
+		case shaSegmentRegex.MatchString(segment):
+			segments[i] = "{sha}"
+		case numberSegmentRegex.MatchString(segment):
+			segments[i] = "{number}"
+		}
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+// observeApiRequest counts an API request. resp is nil if the request failed without
+// a response.
+func observeApiRequest(basePath string, req *http.Request, resp *http.Response) {
+	code := "error"
+	if resp != nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	metrics.Inc(MetricApiRequests, Labels{
+		"method":   req.Method,
+		"endpoint": getApiEndpoint(basePath, req.URL.Path),
+		"code":     code,
+	})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetApiEndpoint(t *testing.T) {
+	assert := assert.New(t)
+	sha := "ec26c3e57ca3a959ca5aad62de7213c562f8c821"
+
+	for _, tc := range []struct {
+		BasePath string
+		Path     string
+		Expected string
+	}{
+		{"", "/repos/Codertocat/Hello-World/statuses/" + sha, "/repos/{owner}/{repo}/statuses/{sha}"},
+		{"", "/repos/Codertocat/Hello-World/commits/" + sha + "/check-suites", "/repos/{owner}/{repo}/commits/{sha}/check-suites"},
+		{"", "/repos/Codertocat/Hello-World/issues/1/comments", "/repos/{owner}/{repo}/issues/{number}/comments"},
+		{"", "/repos/Codertocat/Hello-World/check-runs/7", "/repos/{owner}/{repo}/check-runs/{number}"},
+		{"", "/repos/Codertocat/Hello-World/contents/.github/check-enforcer.yml", "/repos/{owner}/{repo}/contents/{path}"},
+		{"", "/repos/Codertocat/Hello-World/collaborators/octocat/permission", "/repos/{owner}/{repo}/collaborators/{user}/permission"},
+		{"", "/orgs/Azure/teams/azure-sdk-eng/memberships/octocat", "/orgs/{org}/teams/{team}/memberships/{user}"},
+		{"", "/repositories/1296269/issues/1/comments", "/repositories/{number}/issues/{number}/comments"},
+		{"/", "/app/installations/42/access_tokens", "/app/installations/{number}/access_tokens"},
+		{"/api/v3", "/api/v3/repos/Codertocat/Hello-World/pulls/2", "/repos/{owner}/{repo}/pulls/{number}"},
+		{"/github/", "/github/repos/Codertocat/Hello-World/pulls/2", "/repos/{owner}/{repo}/pulls/{number}"},
+	} {
+		assert.Equal(tc.Expected, getApiEndpoint(tc.BasePath, tc.Path), tc.Path)
+	}
+}
+
+func TestMetricsExposition(t *testing.T) {
+	assert := assert.New(t)
+	m := NewMetrics()
+
+	m.Inc(MetricEvents, Labels{"outcome": "ok", "event": "check_suite"})
+	m.Inc(MetricEvents, Labels{"event": "check_suite", "outcome": "ok"})
+	m.Inc(MetricEvents, Labels{"event": "issue_comment", "outcome": "error"})
+	m.Inc(MetricOverrides, Labels{"result": "quote\" slash\\ newline\n"})
+	m.Set(MetricRateLimitLoad, Labels{"resource": "core"}, 0.25)
+	m.Set(MetricRateLimitLoad, Labels{"resource": "core"}, 0.5)
+	m.Set(MetricRateLimitRemaining, nil, 4000)
+
+	assert.Equal(float64(2), m.Get(MetricEvents, Labels{"event": "check_suite", "outcome": "ok"}))
+	assert.Equal(float64(0), m.Get(MetricStatuses, Labels{"state": "success"}))
+	assert.Panics(func() { m.Inc("undefined_total", nil) })
+
+	out := bytes.Buffer{}
+	assert.NoError(m.Write(&out))
+	assert.Equal(`# HELP check_enforcer_events_total Webhook events handled, by event type and outcome.
+# TYPE check_enforcer_events_total counter
+check_enforcer_events_total{event="check_suite",outcome="ok"} 2
+check_enforcer_events_total{event="issue_comment",outcome="error"} 1
+# HELP check_enforcer_overrides_total Override commands, by result.
+# TYPE check_enforcer_overrides_total counter
+check_enforcer_overrides_total{result="quote\" slash\\ newline\n"} 1
+# HELP check_enforcer_ratelimit_load Ratio of used requests to the requests available so far in the rate limit window. Over 1 means the limit will be hit before it resets.
+# TYPE check_enforcer_ratelimit_load gauge
+check_enforcer_ratelimit_load{resource="core"} 0.5
+# HELP check_enforcer_ratelimit_remaining Requests remaining in the rate limit window.
+# TYPE check_enforcer_ratelimit_remaining gauge
+check_enforcer_ratelimit_remaining 4000
+`, out.String())
+}
+
+func TestServerMetrics(t *testing.T) {
+	assert := assert.New(t)
+	payloads, err := getPayloads()
+	assert.NoError(err)
+
+	var postedState CommitState
+	var postedStatus bool
+	githubServer := NewCheckSuiteTestServer(assert, payloads, "", "", &postedState, &postedStatus, "metrics")
+	defer githubServer.Close()
+
+	webhookServer, err := NewWebhookServer(testWebhookSecret, func(installationId int) (*GithubClient, error) {
+		return NewGithubClient(githubServer.URL, "", "octocoders-linter")
+	})
+	assert.NoError(err)
+
+	events := Labels{"event": "check_suite", "outcome": "ok"}
+	unsupported := Labels{"event": "push", "outcome": "unsupported"}
+	statuses := Labels{"state": "success"}
+	requests := Labels{"method": "POST", "endpoint": "/repos/{owner}/{repo}/statuses/{sha}", "code": "200"}
+	before := map[string]float64{
+		"events":      metrics.Get(MetricEvents, events),
+		"unsupported": metrics.Get(MetricEvents, unsupported),
+		"statuses":    metrics.Get(MetricStatuses, statuses),
+		"requests":    metrics.Get(MetricApiRequests, requests),
+	}
+
+	for _, eventType := range []string{"check_suite", "push"} {
+		req := httptest.NewRequest("POST", "/", bytes.NewReader(payloads.CheckSuiteEvent))
+		req.Header.Set("X-GitHub-Event", eventType)
+		req.Header.Set("X-Hub-Signature-256", signPayload(payloads.CheckSuiteEvent))
+		webhookServer.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	webhookServer.Close()
+	assert.True(postedStatus)
+
+	assert.Equal(before["events"]+1, metrics.Get(MetricEvents, events))
+	assert.Equal(before["unsupported"]+1, metrics.Get(MetricEvents, unsupported))
+	assert.Equal(before["statuses"]+1, metrics.Get(MetricStatuses, statuses))
+	assert.Equal(before["requests"]+1, metrics.Get(MetricApiRequests, requests))
+
+	recorder := httptest.NewRecorder()
+	metrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(http.StatusOK, recorder.Code)
+	assert.Equal("text/plain; version=0.0.4; charset=utf-8", recorder.Header().Get("Content-Type"))
+	body, err := ioutil.ReadAll(recorder.Body)
+	assert.NoError(err)
+	assert.True(strings.Contains(string(body), "# TYPE check_enforcer_api_requests_total counter\n"))
+	assert.Contains(string(body), `check_enforcer_api_requests_total{code="200",endpoint="/repos/{owner}/{repo}/statuses/{sha}",method="POST"}`)
+
+	recorder = httptest.NewRecorder()
+	metrics.ServeHTTP(recorder, httptest.NewRequest("POST", "/metrics", nil))
+	assert.Equal(http.StatusMethodNotAllowed, recorder.Code)
+}
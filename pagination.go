@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+// PageSize is the number of items requested per page from list endpoints. 100 is
+// the maximum github allows.
+const PageSize = 100
+
+var nextLinkRegex = regexp.MustCompile(`<([^>]+)>\s*;\s*rel="next"`)
+
+// getPaged requests every page of a list endpoint, calling onPage with the body of
+// each page. Pages are followed through the rel="next" url in the Link header.
+// https://docs.github.com/en/rest/using-the-rest-api/using-pagination-in-the-rest-api
+func (gh *GithubClient) getPaged(listUrl string, onPage func(data []byte) error) error {
+	target, err := gh.getUrl(listUrl)
+	if err != nil {
+		return err
+	}
+	query := target.Query()
+	if query.Get("per_page") == "" {
+		query.Set("per_page", strconv.Itoa(PageSize))
+		target.RawQuery = query.Encode()
+	}
+
+	next := target.String()
+	for next != "" {
+		req, err := http.NewRequest("GET", next, nil)
+		if err != nil {
+			return err
+		}
+
+		gh.setHeaders(req)
+
+		data, resp, err := gh.requestWithRetry(req, true)
+		if err != nil {
+			return err
+		}
+		if err = onPage(data); err != nil {
+			return err
+		}
+
+		next = ""
+		if link := getNextLink(resp.Header.Get("Link")); link != "" {
+			nextUrl, err := gh.getUrl(link)
+			if err != nil {
+				return err
+			}
+			next = nextUrl.String()
+		}
+	}
+
+	return nil
+}
+
+// getNextLink parses the rel="next" url from a Link header in the format:
+//
+//	<https://api.github.com/...?page=2>; rel="next", <https://api.github.com/...?page=5>; rel="last"
+func getNextLink(linkHeader string) string {
+	matches := nextLinkRegex.FindStringSubmatch(linkHeader)
+	if len(matches) < 2 {
+		return ""
+	}
+	return matches[1]
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetNextLink(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("https://api.github.com/repositories/1/check-suites?page=2",
+		getNextLink(`<https://api.github.com/repositories/1/check-suites?page=2>; rel="next", <https://api.github.com/repositories/1/check-suites?page=5>; rel="last"`))
+	assert.Equal("https://api.github.com/x?page=3",
+		getNextLink(`<https://api.github.com/x?page=1>; rel="prev", <https://api.github.com/x?page=3>; rel="next"`))
+	assert.Equal("", getNextLink(`<https://api.github.com/x?page=1>; rel="prev", <https://api.github.com/x?page=1>; rel="first"`))
+	assert.Equal("", getNextLink(""))
+}
+
+// newPagedServer serves pageCount pages of check suites, with totalCount reported in
+// every page. Link headers point to api.github.com to verify they are rewritten to
+// the client base url.
+func newPagedServer(assert *assert.Assertions, pageCount int, totalCount int, requests *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		*requests++
+		assert.Equal("/repos/test/test/commits/abc/check-suites", req.URL.Path)
+		assert.Equal(strconv.Itoa(PageSize), req.URL.Query().Get("per_page"))
+
+		page := 1
+		if p := req.URL.Query().Get("page"); p != "" {
+			page, _ = strconv.Atoi(p)
+		}
+		if page < pageCount {
+			w.Header().Set("Link", fmt.Sprintf(
+				`<https://api.github.com/repos/test/test/commits/abc/check-suites?per_page=%d&page=%d>; rel="next", `+
+					`<https://api.github.com/repos/test/test/commits/abc/check-suites?per_page=%d&page=%d>; rel="last"`,
+				PageSize, page+1, PageSize, pageCount))
+		}
+
+		suites := CheckSuites{Count: totalCount}
+		for i := 0; i < 2; i++ {
+			suites.CheckSuites = append(suites.CheckSuites, CheckSuite{
+				Id:                  page*10 + i,
+				Conclusion:          CheckSuiteConclusionSuccess,
+				LatestCheckRunCount: 1,
+				App:                 App{Name: AzurePipelinesAppName},
+			})
+		}
+		json.NewEncoder(w).Encode(suites)
+	}))
+}
+
+func TestGetCheckSuiteStatusesPaged(t *testing.T) {
+	assert := assert.New(t)
+	requests := 0
+	server := newPagedServer(assert, 3, 6, &requests)
+	defer server.Close()
+
+	gh, err := NewGithubClient(server.URL, "", AzurePipelinesAppName)
+	assert.NoError(err)
+
+	suites, err := gh.GetCheckSuiteStatuses("https://api.github.com/repos/test/test/commits/abc/check-suites")
+	assert.NoError(err)
+	assert.Equal(3, requests)
+	assert.Len(suites, 6)
+	assert.Equal(10, suites[0].Id)
+	assert.Equal(31, suites[5].Id)
+}
+
+func TestGetCheckSuiteStatusesCountMismatch(t *testing.T) {
+	assert := assert.New(t)
+	requests := 0
+	// total_count says there are more suites than the pages contain
+	server := newPagedServer(assert, 2, 6, &requests)
+	defer server.Close()
+
+	gh, err := NewGithubClient(server.URL, "", AzurePipelinesAppName)
+	assert.NoError(err)
+
+	_, err = gh.GetCheckSuiteStatuses("https://api.github.com/repos/test/test/commits/abc/check-suites")
+	assert.Error(err)
+	assert.Contains(err.Error(), "Expected 6 check suites but found 4")
+}
+
+func TestGetPagedArrays(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		page := req.URL.Query().Get("page")
+		if page == "" {
+			w.Header().Set("Link", fmt.Sprintf(`<%s%s?page=2>; rel="next"`, "https://api.github.com", req.URL.Path))
+		}
+		switch req.URL.Path {
+		case "/repos/test/test/pulls/1/files":
+			w.Write([]byte(fmt.Sprintf(`[{"filename": "sdk/core/%s.go", "status": "modified"}]`, page)))
+		case "/repos/test/test/issues/1/comments":
+			w.Write([]byte(fmt.Sprintf(`[{"id": 1, "body": "page %s", "user": {"login": "octocat"}}]`, page)))
+		default:
+			assert.Fail(fmt.Sprintf("Unexpected %s request to '%s'", req.Method, req.URL.String()))
+		}
+	}))
+	defer server.Close()
+
+	gh, err := NewGithubClient(server.URL, "")
+	assert.NoError(err)
+
+	files, err := gh.GetPullRequestFiles("https://api.github.com/repos/test/test/pulls/1")
+	assert.NoError(err)
+	assert.Equal([]PullRequestFile{{Filename: "sdk/core/.go", Status: "modified"}, {Filename: "sdk/core/2.go", Status: "modified"}}, files)
+
+	comments, err := gh.GetIssueComments("https://api.github.com/repos/test/test/issues/1/comments")
+	assert.NoError(err)
+	assert.Len(comments, 2)
+	assert.Equal("page 2", comments[1].Body)
+	assert.Equal("octocat", comments[1].User.Login)
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	OutcomePass    Outcome = "pass"
+	OutcomeFail    Outcome = "fail"
+	OutcomePending Outcome = "pending"
+	OutcomeIgnore  Outcome = "ignore"
+)
+
+// Outcome is how a check suite or check run counts towards the check enforcer status.
+type Outcome string
+
+// CheckRunPolicy requires or ignores check runs by name within the check suites of
+// one app. Names are matched with https://pkg.go.dev/path#Match patterns.
+type CheckRunPolicy struct {
+	Required []string `yaml:"required"`
+	Ignore   []string `yaml:"ignore"`
+}
+
+// SuiteResult is the outcome of a single check suite. Runs is only set for apps with
+// a check run policy, when expected checks are configured, or for unfinished suites
+// when check enforcer publishes a check run, as listing check runs costs an extra API
+// call per suite.
+type SuiteResult struct {
+	Suite   CheckSuite
+	Runs    []CheckRun
+	Outcome Outcome
+}
+
+// Evaluation is the result of evaluating all check suites for a commit.
+type Evaluation struct {
+	State       CommitState
+	Description string
+	Results     []SuiteResult
+	// Missing lists required check runs that were not found, as "<app>/<pattern>",
+	// followed by expected checks for the changed files that were not found.
+	Missing []string
+	// PendingReason keeps the commit pending whatever the check suite outcomes are,
+	// when checks could not be evaluated, and is used as the description.
+	PendingReason string
+	// Failed lists failing check runs as "<app>/<name>" for apps with a check run
+	// policy, and the app name for apps evaluated from the check suite conclusion.
+	Failed []string
+	// Trace records each decision made, for logs and status details.
+	Trace []string
+	// log is the logger of the client that made the evaluation, if any.
+	log *Logger
+}
+
+func (e *Evaluation) trace(format string, args ...interface{}) {
+	line := fmt.Sprintf(format, args...)
+	log := e.log
+	if log == nil {
+		log = logger
+	}
+	log.Info(line)
+	e.Trace = append(e.Trace, line)
+}
+
+func (p CheckRunPolicy) isIgnored(name string) bool {
+	return matchesAny(p.Ignore, name)
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluateCheckSuites decides the commit status from the check suites of the target apps.
+// Apps with a check run policy are evaluated from their individual check runs, all
+// other apps from the check suite conclusion.
+func evaluateCheckSuites(gh *GithubClient, checkSuites []CheckSuite, target *CommitTarget) (*Evaluation, error) {
+	evaluation := &Evaluation{log: gh.Log}
+	runsByApp := map[string][]CheckRun{}
+	// Expected checks are matched by check run name, so all check runs are needed.
+	listAllRuns := len(gh.Config.ExpectedChecks) > 0
+
+	for _, suite := range checkSuites {
+		result := SuiteResult{Suite: suite}
+		policy, hasPolicy := gh.Config.CheckRuns[suite.App.Name]
+
+		// Our own check run keeps its check suite from completing, e.g. the GitHub
+		// Actions suite when authenticated with GITHUB_TOKEN.
+		mayHaveOwnRun := publishesCheckRun(gh.Config) && suite.Status != CheckSuiteStatusCompleted
+
+		var runs []CheckRun
+		hasOwnRun := false
+		if hasPolicy || listAllRuns || mayHaveOwnRun {
+			allRuns, err := gh.GetCheckRuns(suite.CheckRunsUrl)
+			if err != nil {
+				return nil, err
+			}
+			runs = excludeOwnCheckRun(gh.Config, allRuns)
+			hasOwnRun = len(runs) < len(allRuns)
+		}
+
+		if hasPolicy {
+			result.Runs, result.Outcome = evaluateCheckRuns(gh.Config, suite.App.Name, policy, runs, evaluation)
+			runsByApp[suite.App.Name] = append(runsByApp[suite.App.Name], result.Runs...)
+			evaluation.trace("Check suite outcome for '%s' from %d check run(s) is '%s'.", suite.App.Name, len(result.Runs), result.Outcome)
+		} else if hasOwnRun {
+			// Every other check run counts, and a suite with only our check run is ignored
+			result.Runs, result.Outcome = evaluateCheckRuns(gh.Config, suite.App.Name, CheckRunPolicy{}, runs, evaluation)
+			evaluation.trace("Check suite outcome for '%s' from %d check run(s) other than '%s' is '%s'.", suite.App.Name, len(result.Runs), gh.Config.CheckRunName, result.Outcome)
+		} else {
+			result.Runs = runs
+			result.Outcome = gh.Config.GetConclusionOutcome(suite.App.Name, suite.Conclusion)
+			evaluation.trace("Check suite conclusion for '%s' is '%s'.", suite.App.Name, suite.Conclusion)
+			if result.Outcome == OutcomeFail {
+				evaluation.Failed = append(evaluation.Failed, suite.App.Name)
+			}
+		}
+
+		evaluation.Results = append(evaluation.Results, result)
+	}
+
+	// Required check runs catch pipelines that never registered, e.g. because of
+	// invalid yaml, which would otherwise be indistinguishable from pipelines that
+	// were not triggered for the changed files.
+	apps := []string{}
+	for app := range gh.Config.CheckRuns {
+		apps = append(apps, app)
+	}
+	sort.Strings(apps)
+	for _, app := range apps {
+		for _, pattern := range gh.Config.CheckRuns[app].Required {
+			found := false
+			for _, run := range runsByApp[app] {
+				if matched, _ := path.Match(pattern, run.Name); matched {
+					found = true
+					break
+				}
+			}
+			if !found {
+				evaluation.trace("Required check run '%s' for '%s' was not found.", pattern, app)
+				evaluation.Missing = append(evaluation.Missing, fmt.Sprintf("%s/%s", app, pattern))
+			}
+		}
+	}
+
+	if len(gh.Config.ExpectedChecks) > 0 {
+		if err := evaluateExpectedChecks(gh, target, evaluation); err != nil {
+			return nil, err
+		}
+	}
+
+	evaluation.decide(gh.Config.FailureState)
+	return evaluation, nil
+}
+
+// excludeOwnCheckRun removes the check run published by check enforcer, which can be
+// part of a targeted app's check suite, e.g. when authenticated with GITHUB_TOKEN.
+func excludeOwnCheckRun(config *Config, runs []CheckRun) []CheckRun {
+	if !publishesCheckRun(config) {
+		return runs
+	}
+	filtered := []CheckRun{}
+	for _, run := range runs {
+		if run.Name != config.CheckRunName {
+			filtered = append(filtered, run)
+		}
+	}
+	return filtered
+}
+
+// evaluateCheckRuns returns the check runs not ignored by the policy, and their combined outcome.
+func evaluateCheckRuns(config *Config, app string, policy CheckRunPolicy, runs []CheckRun, evaluation *Evaluation) ([]CheckRun, Outcome) {
+	evaluated := []CheckRun{}
+	outcome := OutcomeIgnore
+
+	for _, run := range runs {
+		if policy.isIgnored(run.Name) {
+			evaluation.trace("Ignoring check run '%s'.", run.Name)
+			continue
+		}
+		evaluated = append(evaluated, run)
+
+		runOutcome := getCheckRunOutcome(config, app, run)
+		evaluation.trace("Check run '%s' is '%s' with conclusion '%s'.", run.Name, run.Status, run.Conclusion)
+		if runOutcome == OutcomeFail {
+			evaluation.Failed = append(evaluation.Failed, fmt.Sprintf("%s/%s", app, run.Name))
+		}
+		outcome = combineOutcomes(outcome, runOutcome)
+	}
+
+	return evaluated, outcome
+}
+
+func getCheckRunOutcome(config *Config, app string, run CheckRun) Outcome {
+	if run.Status != CheckSuiteStatusCompleted {
+		return OutcomePending
+	}
+	return config.GetConclusionOutcome(app, run.Conclusion)
+}
+
+// combineOutcomes returns the outcome of two results together. Failures take priority
+// over pending results, which take priority over passing results.
+func combineOutcomes(a Outcome, b Outcome) Outcome {
+	priority := map[Outcome]int{OutcomeIgnore: 0, OutcomePass: 1, OutcomePending: 2, OutcomeFail: 3}
+	if priority[b] > priority[a] {
+		return b
+	}
+	return a
+}
+
+// isSettled returns whether every evaluated check suite has finished, i.e. no new
+// results are expected unless pipelines are re-run or new ones are triggered.
+func (e *Evaluation) isSettled() bool {
+	for _, result := range e.Results {
+		if result.Outcome == OutcomePending {
+			return false
+		}
+	}
+	return true
+}
+
+// decide sets the commit state. Success requires at least one passing check suite,
+// no missing required check runs, no pending reason, and every other suite to be
+// passing or ignored.
+// With the failure state set to "failure", any failing suite fails the commit even
+// while other suites are still in progress. Otherwise failures stay pending, as a
+// failed pipeline may still be re-run.
+func (e *Evaluation) decide(failureState string) {
+	outcome := OutcomeIgnore
+	for _, result := range e.Results {
+		outcome = combineOutcomes(outcome, result.Outcome)
+	}
+
+	if outcome == OutcomePass && len(e.Missing) == 0 && e.PendingReason == "" {
+		e.State = CommitStateSuccess
+		e.Description = "All checks passed"
+		return
+	}
+
+	if outcome == OutcomeFail && failureState == FailureStateFailure {
+		e.State = CommitStateFailure
+		e.Description = truncateDescription("Failed checks: " + strings.Join(e.Failed, ", "))
+		return
+	}
+
+	e.State = CommitStatePending
+	if e.PendingReason != "" {
+		e.Description = e.PendingReason
+	} else if len(e.Missing) > 0 {
+		e.Description = truncateDescription("Missing required checks: " + strings.Join(e.Missing, ", "))
+	} else {
+		e.Description = "Waiting for all checks to succeed"
+	}
+}
+
+// Commit status descriptions are limited to 140 characters.
+const maxDescriptionLength = 140
+
+func truncateDescription(description string) string {
+	runes := []rune(description)
+	if len(runes) <= maxDescriptionLength {
+		return description
+	}
+	return string(runes[:maxDescriptionLength-3]) + "..."
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newCheckRun(name string, status CheckSuiteStatus, conclusion CheckSuiteConclusion) CheckRun {
+	return CheckRun{Name: name, Status: status, Conclusion: conclusion}
+}
+
+type TestPolicyCase struct {
+	Description         string
+	Config              string
+	Suites              []CheckSuite
+	Runs                map[int][]CheckRun
+	ExpectedState       CommitState
+	ExpectedMissing     []string
+	ExpectedRunRequests int
+}
+
+func TestEvaluateCheckSuites(t *testing.T) {
+	assert := assert.New(t)
+	completed := CheckSuiteStatusCompleted
+	success := CheckSuiteConclusionSuccess
+
+	pipelinesSuite := CheckSuite{Id: 1, Conclusion: CheckSuiteConclusionFailure, App: App{Name: AzurePipelinesAppName}}
+	actionsSuite := CheckSuite{Id: 2, Conclusion: success, App: App{Name: GithubActionsAppName}}
+	policyConfig := `
+version: 1
+check_runs:
+  Azure Pipelines:
+    required: ["java - core - ci"]
+    ignore: ["* - weekly"]
+`
+
+	for _, tc := range []TestPolicyCase{
+		{"suite conclusions without policy", "version: 1", []CheckSuite{actionsSuite}, nil, CommitStateSuccess, nil, 0},
+		{"suite failure without policy", "version: 1", []CheckSuite{pipelinesSuite, actionsSuite}, nil, CommitStatePending, nil, 0},
+		{"no suites", "version: 1", []CheckSuite{}, nil, CommitStatePending, nil, 0},
+		{"required run passed", policyConfig, []CheckSuite{pipelinesSuite, actionsSuite},
+			map[int][]CheckRun{1: {newCheckRun("java - core - ci", completed, success)}}, CommitStateSuccess, nil, 1},
+		{"ignored run failed", policyConfig, []CheckSuite{pipelinesSuite, actionsSuite},
+			map[int][]CheckRun{1: {
+				newCheckRun("java - core - ci", completed, success),
+				newCheckRun("java - core - weekly", completed, CheckSuiteConclusionFailure),
+			}}, CommitStateSuccess, nil, 1},
+		{"required run missing", policyConfig, []CheckSuite{pipelinesSuite, actionsSuite},
+			map[int][]CheckRun{1: {newCheckRun("java - storage - ci", completed, success)}},
+			CommitStatePending, []string{"Azure Pipelines/java - core - ci"}, 1},
+		{"required run suite missing", policyConfig, []CheckSuite{actionsSuite}, nil,
+			CommitStatePending, []string{"Azure Pipelines/java - core - ci"}, 0},
+		{"required run in progress", policyConfig, []CheckSuite{pipelinesSuite, actionsSuite},
+			map[int][]CheckRun{1: {newCheckRun("java - core - ci", CheckSuiteStatusInProgress, "")}}, CommitStatePending, nil, 1},
+		{"other run failed", policyConfig, []CheckSuite{pipelinesSuite, actionsSuite},
+			map[int][]CheckRun{1: {
+				newCheckRun("java - core - ci", completed, success),
+				newCheckRun("java - storage - ci", completed, CheckSuiteConclusionFailure),
+			}}, CommitStatePending, nil, 1},
+		{"ignored suite conclusion", "version: 1\napp_conclusions:\n  Azure Pipelines:\n    failure: ignore", []CheckSuite{pipelinesSuite, actionsSuite},
+			nil, CommitStateSuccess, nil, 0},
+		{"only ignored suites", "version: 1\nconclusions:\n  success: ignore\n  failure: ignore\n  neutral: pass", []CheckSuite{pipelinesSuite, actionsSuite},
+			nil, CommitStatePending, nil, 0},
+		{"skipped suite passes", "version: 1\nconclusions:\n  skipped: pass\n  failure: ignore",
+			[]CheckSuite{pipelinesSuite, {Id: 2, Conclusion: CheckSuiteConclusionSkipped, App: App{Name: GithubActionsAppName}}}, nil, CommitStateSuccess, nil, 0},
+		{"skipped run ignored for app", policyConfig + "app_conclusions:\n  Azure Pipelines:\n    skipped: ignore", []CheckSuite{pipelinesSuite, actionsSuite},
+			map[int][]CheckRun{1: {
+				newCheckRun("java - core - ci", completed, success),
+				newCheckRun("java - storage - ci", completed, CheckSuiteConclusionSkipped),
+			}}, CommitStateSuccess, nil, 1},
+		{"skipped run pending by default", policyConfig, []CheckSuite{pipelinesSuite, actionsSuite},
+			map[int][]CheckRun{1: {
+				newCheckRun("java - core - ci", completed, success),
+				newCheckRun("java - storage - ci", completed, CheckSuiteConclusionSkipped),
+			}}, CommitStatePending, nil, 1},
+		{"cancelled run fails", policyConfig + "conclusions:\n  cancelled: fail", []CheckSuite{pipelinesSuite, actionsSuite},
+			map[int][]CheckRun{1: {
+				newCheckRun("java - core - ci", completed, success),
+				newCheckRun("java - storage - ci", completed, CheckSuiteConclusionCancelled),
+			}}, CommitStatePending, nil, 1},
+		{"only ignored runs", "version: 1\ncheck_runs:\n  Azure Pipelines:\n    ignore: ['*']", []CheckSuite{pipelinesSuite, actionsSuite},
+			map[int][]CheckRun{1: {newCheckRun("java - core - ci", completed, CheckSuiteConclusionFailure)}}, CommitStateSuccess, nil, 1},
+	} {
+		runRequests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			var suiteId int
+			if _, err := fmt.Sscanf(req.URL.Path, "/repos/test/test/check-suites/%d/check-runs", &suiteId); err != nil {
+				assert.Fail(fmt.Sprintf("%s: Unexpected %s request to '%s'", tc.Description, req.Method, req.URL.String()))
+				return
+			}
+			runRequests++
+			runs := tc.Runs[suiteId]
+			json.NewEncoder(w).Encode(CheckRuns{Count: len(runs), CheckRuns: runs})
+		}))
+		defer server.Close()
+
+		gh, err := NewGithubClient(server.URL, "", AzurePipelinesAppName, GithubActionsAppName)
+		assert.NoError(err)
+		gh.Config, err = ParseConfig([]byte(tc.Config), gh.Config)
+		assert.NoError(err, tc.Description)
+
+		suites := []CheckSuite{}
+		for _, suite := range tc.Suites {
+			suite.CheckRunsUrl = fmt.Sprintf("https://api.github.com/repos/test/test/check-suites/%d/check-runs", suite.Id)
+			suites = append(suites, suite)
+		}
+
+		evaluation, err := evaluateCheckSuites(gh, suites, &CommitTarget{})
+		assert.NoError(err, tc.Description)
+		assert.Equal(tc.ExpectedState, evaluation.State, tc.Description)
+		assert.Equal(tc.ExpectedMissing, evaluation.Missing, tc.Description)
+		assert.Equal(tc.ExpectedRunRequests, runRequests, tc.Description)
+		assert.Len(evaluation.Results, len(tc.Suites), tc.Description)
+		if len(tc.ExpectedMissing) > 0 {
+			assert.True(strings.HasPrefix(evaluation.Description, "Missing required checks: "), tc.Description)
+		}
+	}
+}
+
+type TestFailureStateCase struct {
+	Description         string
+	Suites              []CheckSuite
+	Runs                []CheckRun
+	ExpectedPending     string
+	ExpectedFailure     CommitState
+	ExpectedDescription string
+}
+
+func TestFailureState(t *testing.T) {
+	assert := assert.New(t)
+	completed := CheckSuiteStatusCompleted
+	failed := CheckSuite{Id: 1, Status: completed, Conclusion: CheckSuiteConclusionFailure, App: App{Name: GithubActionsAppName}}
+	timedOut := CheckSuite{Id: 1, Status: completed, Conclusion: CheckSuiteConclusionTimedOut, App: App{Name: GithubActionsAppName}}
+	passed := CheckSuite{Id: 1, Status: completed, Conclusion: CheckSuiteConclusionSuccess, App: App{Name: GithubActionsAppName}}
+	running := CheckSuite{Id: 2, Status: CheckSuiteStatusInProgress, App: App{Name: "Other App"}}
+	pipelines := CheckSuite{Id: 3, Status: completed, Conclusion: CheckSuiteConclusionFailure, App: App{Name: AzurePipelinesAppName}}
+
+	for _, tc := range []TestFailureStateCase{
+		{"suite failed", []CheckSuite{failed}, nil, "Waiting for all checks to succeed", CommitStateFailure, "Failed checks: GitHub Actions"},
+		{"suite timed out", []CheckSuite{timedOut}, nil, "Waiting for all checks to succeed", CommitStateFailure, "Failed checks: GitHub Actions"},
+		{"suite failed while other in progress", []CheckSuite{failed, running}, nil, "Waiting for all checks to succeed", CommitStateFailure, "Failed checks: GitHub Actions"},
+		{"suite in progress", []CheckSuite{passed, running}, nil, "Waiting for all checks to succeed", CommitStatePending, "Waiting for all checks to succeed"},
+		{"suite passed", []CheckSuite{passed}, nil, "All checks passed", CommitStateSuccess, "All checks passed"},
+		{"runs failed", []CheckSuite{passed, pipelines}, []CheckRun{
+			newCheckRun("java - core - ci", completed, CheckSuiteConclusionSuccess),
+			newCheckRun("java - storage - ci", completed, CheckSuiteConclusionFailure),
+			newCheckRun("java - storage - tests", completed, CheckSuiteConclusionTimedOut),
+		}, "Waiting for all checks to succeed", CommitStateFailure, "Failed checks: Azure Pipelines/java - storage - ci, Azure Pipelines/java - storage - tests"},
+		{"runs in progress", []CheckSuite{passed, pipelines}, []CheckRun{
+			newCheckRun("java - core - ci", completed, CheckSuiteConclusionSuccess),
+			newCheckRun("java - storage - ci", CheckSuiteStatusInProgress, ""),
+		}, "Waiting for all checks to succeed", CommitStatePending, "Waiting for all checks to succeed"},
+	} {
+		for _, failureState := range []string{FailureStatePending, FailureStateFailure} {
+			description := fmt.Sprintf("%s (%s)", tc.Description, failureState)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				assert.Equal("/repos/test/test/check-suites/3/check-runs", req.URL.Path, description)
+				json.NewEncoder(w).Encode(CheckRuns{Count: len(tc.Runs), CheckRuns: tc.Runs})
+			}))
+			defer server.Close()
+
+			gh, err := NewGithubClient(server.URL, "", AzurePipelinesAppName, GithubActionsAppName, "Other App")
+			assert.NoError(err)
+			gh.Config, err = ParseConfig([]byte(fmt.Sprintf("version: 1\nfailure_state: %s\ncheck_runs:\n  Azure Pipelines: {}", failureState)), gh.Config)
+			assert.NoError(err, description)
+
+			suites := []CheckSuite{}
+			for _, suite := range tc.Suites {
+				suite.CheckRunsUrl = fmt.Sprintf("https://api.github.com/repos/test/test/check-suites/%d/check-runs", suite.Id)
+				suites = append(suites, suite)
+			}
+
+			evaluation, err := evaluateCheckSuites(gh, suites, &CommitTarget{})
+			assert.NoError(err, description)
+			if failureState == FailureStateFailure {
+				assert.Equal(tc.ExpectedFailure, evaluation.State, description)
+				assert.Equal(tc.ExpectedDescription, evaluation.Description, description)
+			} else {
+				assert.NotEqual(CommitStateFailure, evaluation.State, description)
+				assert.Equal(tc.ExpectedPending, evaluation.Description, description)
+			}
+		}
+	}
+
+	_, err := ParseConfig([]byte("version: 1\nfailure_state: error"), NewDefaultConfig(AzurePipelinesAppName))
+	assert.Error(err)
+}
+
+func TestCheckRunPolicyConfig(t *testing.T) {
+	assert := assert.New(t)
+	defaults := NewDefaultConfig(AzurePipelinesAppName)
+
+	_, err := ParseConfig([]byte("version: 1\ncheck_runs:\n  Unknown App:\n    required: [ci]"), defaults)
+	assert.Error(err)
+	_, err = ParseConfig([]byte("version: 1\ncheck_runs:\n  Azure Pipelines:\n    ignore: ['[']"), defaults)
+	assert.Error(err)
+}
+
+func TestTruncateDescription(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("short", truncateDescription("short"))
+	truncated := truncateDescription(strings.Repeat("a", 200))
+	assert.Len(truncated, maxDescriptionLength)
+	assert.True(strings.HasSuffix(truncated, "..."))
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	OutputStatus   = "status"
+	OutputCheckRun = "check_run"
+	OutputBoth     = "both"
+)
+
+// Check run output fields are limited to 65535 characters.
+const maxCheckRunOutputLength = 65535
+
+// Publisher reports the check enforcer result for a commit. The evaluation is nil when
+// the result was not evaluated from check suites, e.g. for overrides.
+type Publisher interface {
+	Publish(target CommitTarget, status StatusBody, evaluation *Evaluation) error
+}
+
+// StatusPublisher posts a commit status, which only holds a state, a short
+// description and a link.
+type StatusPublisher struct {
+	gh *GithubClient
+}
+
+// CheckRunPublisher creates or updates a check run with a summary of every evaluated
+// check suite and check run, and the decision trace.
+type CheckRunPublisher struct {
+	gh *GithubClient
+}
+
+type Publishers []Publisher
+
+// NewPublisher returns the publisher for the output configured for the repository.
+func NewPublisher(gh *GithubClient) Publisher {
+	switch gh.Config.Output {
+	case OutputCheckRun:
+		return &CheckRunPublisher{gh: gh}
+	case OutputBoth:
+		return Publishers{&StatusPublisher{gh: gh}, &CheckRunPublisher{gh: gh}}
+	default:
+		return &StatusPublisher{gh: gh}
+	}
+}
+
+// publishesCheckRun returns whether results are published as a check run.
+func publishesCheckRun(config *Config) bool {
+	return config.Output == OutputCheckRun || config.Output == OutputBoth
+}
+
+func publish(gh *GithubClient, target CommitTarget, status StatusBody, evaluation *Evaluation) error {
+	return NewPublisher(gh).Publish(target, status, evaluation)
+}
+
+func (p Publishers) Publish(target CommitTarget, status StatusBody, evaluation *Evaluation) error {
+	for _, publisher := range p {
+		if err := publisher.Publish(target, status, evaluation); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *StatusPublisher) Publish(target CommitTarget, status StatusBody, evaluation *Evaluation) error {
+	return p.gh.SetStatus(target.StatusesUrl, status)
+}
+
+func (p *CheckRunPublisher) Publish(target CommitTarget, status StatusBody, evaluation *Evaluation) error {
+	name := p.gh.Config.CheckRunName
+	body := newCheckRunBody(p.gh.Config, status, evaluation)
+
+	// Looking up the check run to update can be skipped under rate limit load, a new
+	// check run replaces it the same as for completed check runs.
+	existing := []CheckRun{}
+	if !p.gh.shed("check run lookup") {
+		var err error
+		existing, err = p.gh.GetCheckRuns(target.GetCommitCheckRunsUrl() + "?check_name=" + url.QueryEscape(name))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Completed check runs are not re-opened, a new check run replaces them instead
+	// so that the result history stays visible in the checks tab.
+	for _, run := range existing {
+		if run.Name == name && run.Status != CheckSuiteStatusCompleted {
+			p.gh.Log.Info(fmt.Sprintf("Updating check run '%s' (%d) to '%s'.", name, run.Id, body.Status))
+			return p.gh.UpdateCheckRun(run.Url, body)
+		}
+	}
+
+	p.gh.Log.Info(fmt.Sprintf("Creating check run '%s' with status '%s'.", name, body.Status))
+	body.Name = name
+	body.HeadSha = target.HeadSha
+	return p.gh.CreateCheckRun(target.GetCheckRunsUrl(), body)
+}
+
+func newCheckRunBody(config *Config, status StatusBody, evaluation *Evaluation) CheckRunBody {
+	body := CheckRunBody{
+		Status:     CheckSuiteStatusCompleted,
+		DetailsUrl: status.TargetUrl,
+		Output: CheckRunOutput{
+			Title:   status.Description,
+			Summary: truncateOutput(newCheckRunSummary(config, status, evaluation)),
+		},
+	}
+
+	switch status.State {
+	case CommitStateSuccess:
+		body.Conclusion = CheckSuiteConclusionSuccess
+	case CommitStateFailure, CommitStateError:
+		body.Conclusion = CheckSuiteConclusionFailure
+	default:
+		body.Status = CheckSuiteStatusInProgress
+	}
+
+	if evaluation != nil && len(evaluation.Trace) > 0 {
+		body.Output.Text = truncateOutput("```\n" + strings.Join(evaluation.Trace, "\n") + "\n```")
+	}
+
+	return body
+}
+
+// newCheckRunSummary renders a markdown table of every evaluated check suite, followed
+// by its check runs when they were listed, and any missing checks.
+func newCheckRunSummary(config *Config, status StatusBody, evaluation *Evaluation) string {
+	summary := strings.Builder{}
+	summary.WriteString(fmt.Sprintf("**%s**\n", status.Description))
+
+	if evaluation == nil {
+		return summary.String()
+	}
+
+	if len(evaluation.Results) == 0 {
+		summary.WriteString("\nNo check suites were found for the targeted apps.\n")
+	} else {
+		summary.WriteString("\n| App | Check | Status | Conclusion | Outcome |\n")
+		summary.WriteString("| --- | --- | --- | --- | --- |\n")
+		for _, result := range evaluation.Results {
+			summary.WriteString(fmt.Sprintf("| %s | _check suite_ | %s | %s | %s |\n",
+				escapeTableCell(result.Suite.App.Name), result.Suite.Status, result.Suite.Conclusion, result.Outcome))
+			for _, run := range result.Runs {
+				check := escapeTableCell(run.Name)
+				if run.HtmlUrl != "" {
+					check = fmt.Sprintf("[%s](%s)", check, run.HtmlUrl)
+				}
+				summary.WriteString(fmt.Sprintf("| %s | %s | %s | %s | %s |\n",
+					escapeTableCell(result.Suite.App.Name), check, run.Status, run.Conclusion, getCheckRunOutcome(config, result.Suite.App.Name, run)))
+			}
+		}
+	}
+
+	if len(evaluation.Missing) > 0 {
+		summary.WriteString("\n**Missing checks**\n\n")
+		for _, check := range evaluation.Missing {
+			summary.WriteString(fmt.Sprintf("- `%s`\n", check))
+		}
+	}
+
+	return summary.String()
+}
+
+func escapeTableCell(text string) string {
+	return strings.ReplaceAll(text, "|", "\\|")
+}
+
+func truncateOutput(output string) string {
+	if len(output) <= maxCheckRunOutputLength {
+		return output
+	}
+	suffix := "\n\n_Truncated_"
+	end := maxCheckRunOutputLength - len(suffix)
+	// Do not cut a multi-byte character in half
+	for end > 0 && !utf8.RuneStart(output[end]) {
+		end--
+	}
+	return output[:end] + suffix
+}
+
+func (gh *GithubClient) CreateCheckRun(checkRunsUrl string, body CheckRunBody) error {
+	return gh.sendCheckRun("POST", checkRunsUrl, body)
+}
+
+func (gh *GithubClient) UpdateCheckRun(checkRunUrl string, body CheckRunBody) error {
+	return gh.sendCheckRun("PATCH", checkRunUrl, body)
+}
+
+func (gh *GithubClient) sendCheckRun(method string, checkRunUrl string, body CheckRunBody) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	target, err := gh.getUrl(checkRunUrl)
+	if err != nil {
+		return err
+	}
+
+	if gh.skipWrite(method, target.String(), data, fmt.Sprintf("Check run would be '%s' with conclusion '%s': %s", body.Status, body.Conclusion, body.Output.Title)) {
+		return nil
+	}
+
+	req, err := http.NewRequest(method, target.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	gh.setHeaders(req)
+
+	// Updating a check run with the same body is harmless, but creating one twice
+	// would leave a duplicate check run, so only updates are retried.
+	_, _, err = gh.requestWithRetry(req, method == "PATCH")
+	return err
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewCheckRunBody(t *testing.T) {
+	assert := assert.New(t)
+	config := NewDefaultConfig(AzurePipelinesAppName)
+	evaluation := &Evaluation{
+		Results: []SuiteResult{{
+			Suite: CheckSuite{App: App{Name: AzurePipelinesAppName}, Status: CheckSuiteStatusCompleted, Conclusion: CheckSuiteConclusionFailure},
+			Runs: []CheckRun{
+				{Name: "java - core - ci", Status: CheckSuiteStatusCompleted, Conclusion: CheckSuiteConclusionSuccess, HtmlUrl: "https://github.com/runs/1"},
+				{Name: "java | pipes", Status: CheckSuiteStatusInProgress},
+			},
+			Outcome: OutcomePending,
+		}},
+		Missing: []string{"go - storage - ci"},
+		Trace:   []string{"first decision", "second decision"},
+	}
+
+	pending := newPendingBody(CommitStatusContext)
+	body := newCheckRunBody(config, pending, evaluation)
+	assert.Equal(CheckSuiteStatusInProgress, body.Status)
+	assert.Empty(body.Conclusion)
+	assert.Equal(pending.Description, body.Output.Title)
+	assert.Equal(pending.TargetUrl, body.DetailsUrl)
+	assert.Contains(body.Output.Summary, "| Azure Pipelines | _check suite_ | completed | failure | pending |")
+	assert.Contains(body.Output.Summary, "| Azure Pipelines | [java - core - ci](https://github.com/runs/1) | completed | success | pass |")
+	assert.Contains(body.Output.Summary, "| Azure Pipelines | java \\| pipes | in_progress |  | pending |")
+	assert.Contains(body.Output.Summary, "- `go - storage - ci`")
+	assert.Equal("```\nfirst decision\nsecond decision\n```", body.Output.Text)
+
+	body = newCheckRunBody(config, newSucceededBody(CommitStatusContext), &Evaluation{})
+	assert.Equal(CheckSuiteStatusCompleted, body.Status)
+	assert.Equal(CheckSuiteConclusionSuccess, body.Conclusion)
+	assert.Contains(body.Output.Summary, "No check suites were found")
+	assert.Empty(body.Output.Text)
+
+	body = newCheckRunBody(config, newFailedBody(CommitStatusContext), nil)
+	assert.Equal(CheckSuiteStatusCompleted, body.Status)
+	assert.Equal(CheckSuiteConclusionFailure, body.Conclusion)
+	assert.Equal("**Some checks failed**\n", body.Output.Summary)
+}
+
+func TestTruncateOutput(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("short", truncateOutput("short"))
+	truncated := truncateOutput(strings.Repeat("é", maxCheckRunOutputLength))
+	assert.LessOrEqual(len(truncated), maxCheckRunOutputLength)
+	assert.True(strings.HasSuffix(truncated, "_Truncated_"))
+	assert.NotContains(truncated, "�")
+}
+
+type TestPublishCase struct {
+	Description       string
+	Output            string
+	ExistingRuns      []CheckRun
+	ExpectedStatus    bool
+	ExpectedCreate    bool
+	ExpectedUpdate    bool
+	ExpectedRunStatus CheckSuiteStatus
+}
+
+func TestPublish(t *testing.T) {
+	assert := assert.New(t)
+	payloads, err := getPayloads()
+	assert.NoError(err)
+	checkSuite := NewCheckSuiteWebhook(payloads.CheckSuiteEvent)
+	assert.NotEmpty(checkSuite)
+	sha := checkSuite.CheckSuite.HeadSha
+	inProgressEvent := []byte(strings.ReplaceAll(string(payloads.CheckSuiteEvent), `"conclusion": "success"`, `"conclusion": null`))
+	openRun := CheckRun{Id: 7, Name: "Check Enforcer", Status: CheckSuiteStatusInProgress, Url: "https://api.github.com/repos/Codertocat/Hello-World/check-runs/7"}
+	closedRun := CheckRun{Id: 8, Name: "Check Enforcer", Status: CheckSuiteStatusCompleted, Url: "https://api.github.com/repos/Codertocat/Hello-World/check-runs/8"}
+
+	for _, tc := range []TestPublishCase{
+		{"status", "status", nil, true, false, false, ""},
+		{"check run", "check_run", nil, false, true, false, CheckSuiteStatusCompleted},
+		{"both", "both", nil, true, true, false, CheckSuiteStatusCompleted},
+		{"check run update", "check_run", []CheckRun{openRun}, false, false, true, CheckSuiteStatusCompleted},
+		{"check run replaces completed", "check_run", []CheckRun{closedRun}, false, true, false, CheckSuiteStatusCompleted},
+	} {
+		for _, event := range [][]byte{payloads.CheckSuiteEvent, inProgressEvent} {
+			postedStatus := false
+			created := false
+			updated := false
+			var posted CheckRunBody
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				switch {
+				case isConfigRequest(req):
+					w.Write(newConfigResponse(fmt.Sprintf("version: 1\napps: [octocoders-linter]\noutput: %s", tc.Output)))
+				case req.URL.Path == fmt.Sprintf("/repos/Codertocat/Hello-World/commits/%s/check-runs", sha) && req.Method == "GET":
+					assert.Equal("Check Enforcer", req.URL.Query().Get("check_name"), tc.Description)
+					json.NewEncoder(w).Encode(CheckRuns{Count: len(tc.ExistingRuns), CheckRuns: tc.ExistingRuns})
+				case req.URL.Path == "/repos/Codertocat/Hello-World/check-runs" && req.Method == "POST":
+					created = true
+					posted = getCheckRunBody(assert, req)
+					assert.Equal("Check Enforcer", posted.Name, tc.Description)
+					assert.Equal(sha, posted.HeadSha, tc.Description)
+					w.WriteHeader(http.StatusCreated)
+				case req.URL.Path == "/repos/Codertocat/Hello-World/check-runs/7" && req.Method == "PATCH":
+					updated = true
+					posted = getCheckRunBody(assert, req)
+				case strings.Contains(checkSuite.GetStatusesUrl(), req.URL.Path) && req.Method == "POST":
+					postedStatus = true
+					w.Write(payloads.StatusResponse)
+				default:
+					assert.Fail(fmt.Sprintf("%s: Unexpected %s request to '%s'", tc.Description, req.Method, req.URL.String()))
+				}
+			}))
+			defer server.Close()
+
+			gh, err := NewGithubClient(server.URL, "", "octocoders-linter")
+			assert.NoError(err)
+			assert.NoError(handleEvent(gh, event), tc.Description)
+
+			assert.Equal(tc.ExpectedStatus, postedStatus, tc.Description)
+			assert.Equal(tc.ExpectedCreate, created, tc.Description)
+			assert.Equal(tc.ExpectedUpdate, updated, tc.Description)
+			if tc.ExpectedCreate || tc.ExpectedUpdate {
+				if string(event) == string(inProgressEvent) {
+					assert.Equal(CheckSuiteStatusInProgress, posted.Status, tc.Description)
+					assert.Equal("Waiting for all checks to succeed", posted.Output.Title, tc.Description)
+				} else {
+					assert.Equal(tc.ExpectedRunStatus, posted.Status, tc.Description)
+					assert.Equal(CheckSuiteConclusionSuccess, posted.Conclusion, tc.Description)
+				}
+				assert.Contains(posted.Output.Summary, "| octocoders-linter | _check suite_ |", tc.Description)
+				assert.Contains(posted.Output.Text, "Check suite conclusion for 'octocoders-linter'", tc.Description)
+			}
+		}
+	}
+}
+
+func TestExcludeOwnCheckRun(t *testing.T) {
+	assert := assert.New(t)
+	runs := []CheckRun{{Name: "Check Enforcer"}, {Name: "lint"}}
+
+	config := NewDefaultConfig(GithubActionsAppName)
+	assert.Equal(runs, excludeOwnCheckRun(config, runs))
+	config.Output = OutputBoth
+	assert.Equal([]CheckRun{{Name: "lint"}}, excludeOwnCheckRun(config, runs))
+
+	_, err := ParseConfig([]byte("version: 1\noutput: comment"), NewDefaultConfig(GithubActionsAppName))
+	assert.Error(err)
+	_, err = ParseConfig([]byte("version: 1\ncheck_run_name: ''"), NewDefaultConfig(GithubActionsAppName))
+	assert.Error(err)
+}
+
+func getCheckRunBody(assert *assert.Assertions, req *http.Request) CheckRunBody {
+	data, err := ioutil.ReadAll(req.Body)
+	assert.NoError(err)
+	body := CheckRunBody{}
+	assert.NoError(json.Unmarshal(data, &body))
+	return body
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	PullRequestActionOpened      ActionType = "opened"
+	PullRequestActionSynchronize ActionType = "synchronize"
+	PullRequestActionReopened    ActionType = "reopened"
+)
+
+// handlePullRequest posts a pending status as soon as a pull request is opened or
+// updated, before any pipelines have registered check suites, and starts the grace
+// timer for reporting that no pipelines were triggered.
+func handlePullRequest(gh *GithubClient, webhook *PullRequestWebhook) error {
+	pr := webhook.PullRequest
+	gh.Log = gh.Log.With(Fields{"event": "pull_request", "repo": webhook.Repo.FullName, "pr": pr.Number, "sha": pr.Head.Sha})
+	gh.Log.Info("Handling pull request event.")
+
+	if webhook.Action != PullRequestActionOpened && webhook.Action != PullRequestActionSynchronize && webhook.Action != PullRequestActionReopened {
+		gh.Log.Info(fmt.Sprintf("Skipping pull request event with action '%s'.", webhook.Action))
+		return nil
+	}
+
+	err := gh.LoadConfig(webhook.Repo)
+	if err != nil {
+		return err
+	}
+
+	if gh.Config.IsSkippedBranch(pr.Head.Ref) {
+		gh.Log.Info(fmt.Sprintf("Skipping pull request for branch '%s'.", pr.Head.Ref))
+		return nil
+	}
+
+	target := CommitTarget{Repo: webhook.Repo, HeadSha: pr.Head.Sha, StatusesUrl: pr.StatusesUrl, PullNumber: pr.Number}
+	status := newPendingBody(gh.Config.StatusContext)
+	status.Description = "Waiting for pipelines to start"
+	if err = publish(gh, target, status, nil); err != nil {
+		return err
+	}
+
+	if gh.Config.NoPipelinesGrace == 0 {
+		return nil
+	}
+	if gh.Timers == nil {
+		gh.Log.Info("Skipping no pipelines detection, timers are not available.")
+		return nil
+	}
+	// The key does not include the commit, so that pushing again restarts the grace period.
+	key := fmt.Sprintf("no-pipelines:%s#%d", webhook.Repo.FullName, pr.Number)
+	gh.Timers.Schedule(gh.Log, key, gh.Config.NoPipelinesGrace, func() error {
+		return checkNoPipelines(gh, pr, target)
+	})
+	return nil
+}
+
+// checkNoPipelines comments on the pull request if no check suites from the targeted
+// apps were registered for the commit by the end of the grace period.
+func checkNoPipelines(gh *GithubClient, pr PullRequest, target CommitTarget) error {
+	checkSuites, err := gh.GetCheckSuiteStatuses(pr.GetCheckSuiteUrl())
+	if err != nil {
+		return err
+	}
+	if len(checkSuites) > 0 {
+		gh.Log.Info(fmt.Sprintf("Pipelines were triggered for commit '%s'.", target.HeadSha))
+		return nil
+	}
+
+	gh.Log.Info(fmt.Sprintf("No pipelines were triggered for commit '%s' after %s.", target.HeadSha, gh.Config.NoPipelinesGrace))
+	text, err := gh.Config.GetNoPipelinesComment()
+	if err != nil {
+		return err
+	}
+	if err = commentOnce(gh, target, noPipelinesMarker(target.HeadSha), text); err != nil {
+		return err
+	}
+
+	status := newPendingBody(gh.Config.StatusContext)
+	status.Description = "No pipelines were triggered for this commit"
+	return publish(gh, target, status, nil)
+}
+
+func noPipelinesMarker(headSha string) string {
+	return fmt.Sprintf("<!-- check-enforcer:no-pipelines:%s -->", headSha)
+}
+
+// commentOnce comments on the pull request unless a comment containing the marker
+// already exists. The marker is an html comment, so it is hidden in the rendered comment.
+func commentOnce(gh *GithubClient, target CommitTarget, marker string, body string) error {
+	if target.PullNumber == 0 || gh.shed(fmt.Sprintf("comment '%s'", marker)) {
+		return nil
+	}
+
+	comments, err := gh.GetIssueComments(target.GetCommentsUrl())
+	if err != nil {
+		return err
+	}
+	for _, comment := range comments {
+		if strings.Contains(comment.Body, marker) {
+			gh.Log.Info(fmt.Sprintf("Comment '%s' was already posted.", marker))
+			return nil
+		}
+	}
+
+	return gh.CreateIssueComment(target.GetCommentsUrl(), marker+"\n"+body)
+}
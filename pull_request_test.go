@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type TestPullRequestCase struct {
+	Description        string
+	Action             string
+	Config             string
+	CheckSuiteResponse []byte
+	ExistingComments   []string
+	ExpectedStatuses   []string
+	ShouldPostComment  bool
+	ShouldListSuites   bool
+}
+
+func TestPullRequest(t *testing.T) {
+	assert := assert.New(t)
+	payloads, err := getPayloads()
+	assert.NoError(err)
+	pullRequestEvent, err := ioutil.ReadFile("./testpayloads/pull_request_event.json")
+	assert.NoError(err)
+	sha := "6dcb09b5b57875f334f61aebed695e2e4193db5e"
+	noGrace := "version: 1\napps: [Octocat App]\nno_pipelines_grace: 0s"
+	grace := "version: 1\napps: [Octocat App]\nno_pipelines_grace: 1ms"
+	noMatchGrace := "version: 1\napps: [no-match]\nno_pipelines_grace: 1ms"
+	waiting := "Waiting for pipelines to start"
+	noPipelines := "No pipelines were triggered for this commit"
+
+	for _, tc := range []TestPullRequestCase{
+		{"opened", "opened", noGrace, nil, nil, []string{waiting}, false, false},
+		{"synchronize", "synchronize", noGrace, nil, nil, []string{waiting}, false, false},
+		{"reopened", "reopened", noGrace, nil, nil, []string{waiting}, false, false},
+		{"closed", "closed", noGrace, nil, nil, nil, false, false},
+		{"skipped branch", "opened", "version: 1\nskip_branches: [new-*]", nil, nil, nil, false, false},
+		{"pipelines triggered", "opened", grace, payloads.CheckSuiteResponse, nil, []string{waiting}, false, true},
+		{"no pipelines triggered", "opened", noMatchGrace, payloads.CheckSuiteResponse, nil, []string{waiting, noPipelines}, true, true},
+		{"no pipelines already commented", "synchronize", noMatchGrace, payloads.CheckSuiteResponse,
+			[]string{noPipelinesMarker(sha)}, []string{waiting, noPipelines}, false, true},
+	} {
+		var mutex sync.Mutex
+		statuses := []string{}
+		postedComment := false
+		listedSuites := false
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			switch {
+			case isConfigRequest(req):
+				assert.Equal("/repos/octocat/Hello-World/contents/"+ConfigPath, req.URL.Path, tc.Description)
+				w.Write(newConfigResponse(tc.Config))
+			case req.URL.Path == "/repos/octocat/Hello-World/statuses/"+sha && req.Method == "POST":
+				status := getStatusBody(assert, req)
+				assert.Equal(CommitStatePending, status.State, tc.Description)
+				statuses = append(statuses, status.Description)
+				w.Write(payloads.StatusResponse)
+			case req.URL.Path == fmt.Sprintf("/repos/octocat/Hello-World/commits/%s/check-suites", sha):
+				listedSuites = true
+				w.Write(tc.CheckSuiteResponse)
+			case req.URL.Path == "/repos/octocat/Hello-World/issues/1347/comments" && req.Method == "GET":
+				comments := []IssueComment{}
+				for _, c := range tc.ExistingComments {
+					comments = append(comments, IssueComment{Body: c})
+				}
+				json.NewEncoder(w).Encode(comments)
+			case req.URL.Path == "/repos/octocat/Hello-World/issues/1347/comments" && req.Method == "POST":
+				postedComment = true
+				data, err := ioutil.ReadAll(req.Body)
+				assert.NoError(err)
+				body := IssueCommentBody{}
+				assert.NoError(json.Unmarshal(data, &body))
+				assert.True(strings.HasPrefix(body.Body, noPipelinesMarker(sha)), tc.Description)
+				assert.Contains(body.Body, "no Azure Pipelines or Github Actions have been triggered", tc.Description)
+				w.Write(payloads.NewCommentResponse)
+			default:
+				assert.Fail(fmt.Sprintf("%s: Unexpected %s request to '%s'", tc.Description, req.Method, req.URL.String()))
+			}
+		}))
+		defer server.Close()
+
+		gh, err := NewGithubClient(server.URL, "", "Octocat App")
+		assert.NoError(err)
+		gh.Timers = NewTimers()
+
+		fmt.Println(fmt.Sprintf("\n\n========= %s =========", tc.Description))
+		event := strings.Replace(string(pullRequestEvent), `"action": "opened"`, fmt.Sprintf(`"action": "%s"`, tc.Action), 1)
+		assert.NoError(handleEvent(gh, []byte(event)), tc.Description)
+		gh.Timers.Wait()
+
+		mutex.Lock()
+		assert.Equal(len(tc.ExpectedStatuses), len(statuses), tc.Description)
+		if len(tc.ExpectedStatuses) > 0 {
+			assert.Equal(tc.ExpectedStatuses, statuses, tc.Description)
+		}
+		assert.Equal(tc.ShouldPostComment, postedComment, tc.Description)
+		assert.Equal(tc.ShouldListSuites, listedSuites, tc.Description)
+		mutex.Unlock()
+	}
+}
+
+func TestTimers(t *testing.T) {
+	assert := assert.New(t)
+	timers := NewTimers()
+	var mutex sync.Mutex
+	runs := []string{}
+	run := func(name string) func() error {
+		return func() error {
+			mutex.Lock()
+			defer mutex.Unlock()
+			runs = append(runs, name)
+			return nil
+		}
+	}
+
+	log := logger.With(Fields{"event": "pull_request"})
+	labels := Labels{"event": "pull_request", "outcome": "error"}
+	errors := metrics.Get(MetricEvents, labels)
+
+	timers.Schedule(log, "a", time.Hour, run("replaced"))
+	timers.Schedule(log, "a", time.Millisecond, run("a"))
+	timers.Schedule(log, "b", time.Millisecond, func() error { return fmt.Errorf("logged, not returned") })
+	timers.Schedule(log, "c", time.Millisecond, func() error { panic("recovered") })
+	timers.Wait()
+
+	assert.Equal([]string{"a"}, runs)
+	assert.Empty(timers.timers)
+	assert.Equal(errors+1, metrics.Get(MetricEvents, labels), "Panics are counted as errors")
+
+	timers.Schedule(log, "d", time.Hour, run("d"))
+	timers.Fire()
+	assert.Equal([]string{"a", "d"}, runs, "Fire runs pending timers without waiting for their delay")
+	assert.Empty(timers.timers)
+}
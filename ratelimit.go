@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+const RateLimitMaxLoadKey = "CHECK_ENFORCER_RATELIMIT_MAX_LOAD"
+const RateLimitReserveKey = "CHECK_ENFORCER_RATELIMIT_RESERVE"
+
+// Keep load under 50% for a safety margin, see logResponse.
+const DefaultRateLimitMaxLoad = 0.5
+const DefaultRateLimitReserve = 20
+
+// Calls are only shed once this fraction of the limit is used. Right after the window
+// resets, a handful of requests is enough for a load over 100%.
+const RateLimitMinUsedFraction = 0.1
+
+// RateLimit is a snapshot of the rate limit headers of an API response.
+type RateLimit struct {
+	Resource  string
+	Limit     int
+	Remaining int
+	Reset     time.Time
+	// Load is the ratio of used requests to the requests available so far in the
+	// rate limit window, see logResponse.
+	Load float64
+}
+
+// RateLimitGuard keeps the latest rate limit snapshot of a token. When the load is
+// over MaxLoad, non-essential calls such as help comments are shed, and when fewer
+// than Reserve requests remain, requests wait for the rate limit to reset instead of
+// failing. Status writes are never shed.
+type RateLimitGuard struct {
+	mutex   sync.Mutex
+	latest  *RateLimit
+	MaxLoad float64
+	Reserve int
+	now     func() time.Time
+	sleep   func(time.Duration)
+}
+
+func NewRateLimitGuard(maxLoad float64, reserve int) *RateLimitGuard {
+	return &RateLimitGuard{
+		MaxLoad: maxLoad,
+		Reserve: reserve,
+		now:     time.Now,
+		sleep:   time.Sleep,
+	}
+}
+
+func NewDefaultRateLimitGuard() *RateLimitGuard {
+	return NewRateLimitGuard(DefaultRateLimitMaxLoad, DefaultRateLimitReserve)
+}
+
+// Observe records a snapshot. Only the core rate limit, which every call made by check
+// enforcer counts against, is kept.
+func (g *RateLimitGuard) Observe(rateLimit RateLimit) {
+	if rateLimit.Resource != "" && rateLimit.Resource != "core" {
+		return
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.latest = &rateLimit
+}
+
+// Latest returns the latest snapshot, if any response had rate limit headers.
+func (g *RateLimitGuard) Latest() (RateLimit, bool) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.latest == nil {
+		return RateLimit{}, false
+	}
+	return *g.latest, true
+}
+
+// ShouldShed returns whether the load is over the threshold for non-essential calls.
+func (g *RateLimitGuard) ShouldShed() bool {
+	rateLimit, ok := g.Latest()
+	return ok && g.isOverLoad(rateLimit)
+}
+
+// isOverLoad returns whether the load is at or over MaxLoad, once enough of the limit
+// is used for the load to be meaningful, see RateLimitMinUsedFraction.
+func (g *RateLimitGuard) isOverLoad(rateLimit RateLimit) bool {
+	used := rateLimit.Limit - rateLimit.Remaining
+	return rateLimit.Load >= g.MaxLoad && float64(used) >= float64(rateLimit.Limit)*RateLimitMinUsedFraction
+}
+
+// WaitForReset sleeps until the rate limit resets if the reserve is used up.
+func (g *RateLimitGuard) WaitForReset(log *Logger) {
+	rateLimit, ok := g.Latest()
+	if !ok || rateLimit.Remaining > g.Reserve {
+		return
+	}
+	g.sleepUntilReset(log, rateLimit, fmt.Sprintf("%d request(s) remaining", rateLimit.Remaining))
+}
+
+// Pace sleeps until the rate limit resets if the load is over MaxLoad. Background work,
+// such as reconciling every open pull request, paces itself so that the rest of the
+// budget is left for webhook events.
+func (g *RateLimitGuard) Pace(log *Logger) {
+	rateLimit, ok := g.Latest()
+	if !ok || !g.isOverLoad(rateLimit) {
+		return
+	}
+	g.sleepUntilReset(log, rateLimit, fmt.Sprintf("load %s is over %s", toPercent(rateLimit.Load), toPercent(g.MaxLoad)))
+}
+
+func (g *RateLimitGuard) sleepUntilReset(log *Logger, rateLimit RateLimit, reason string) {
+	// Wait a second past the reset time to allow for clock skew
+	delay := rateLimit.Reset.Sub(g.now()) + time.Second
+	if delay > 0 {
+		log.Warn(fmt.Sprintf("[github] Rate limit %s, waiting %s for the rate limit to reset", reason, delay.Round(time.Second)),
+			Fields{"ratelimit_remaining": rateLimit.Remaining, "delay_ms": delay.Milliseconds()})
+		g.sleep(delay)
+	}
+
+	// The snapshot is stale after the reset, the next response will replace it
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.latest != nil && g.latest.Reset.Equal(rateLimit.Reset) {
+		g.latest = nil
+	}
+}
+
+// shed returns true, and logs the skipped call, if non-essential calls are being shed.
+func (gh *GithubClient) shed(description string) bool {
+	if gh.RateLimit == nil || !gh.RateLimit.ShouldShed() {
+		return false
+	}
+	rateLimit, _ := gh.RateLimit.Latest()
+	gh.Log.Warn(fmt.Sprintf("[github] Rate limit load %s is over %s, skipping %s.", toPercent(rateLimit.Load), toPercent(gh.RateLimit.MaxLoad), description),
+		Fields{"ratelimit_load": math.Round(rateLimit.Load*1000) / 1000})
+	return true
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRateLimitGuard(now time.Time, delays *[]time.Duration) *RateLimitGuard {
+	guard := NewDefaultRateLimitGuard()
+	guard.now = func() time.Time { return now }
+	guard.sleep = func(d time.Duration) { *delays = append(*delays, d) }
+	return guard
+}
+
+func TestRateLimitGuard(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Now().Truncate(time.Second)
+	delays := []time.Duration{}
+	guard := newTestRateLimitGuard(now, &delays)
+
+	assert.False(guard.ShouldShed())
+	guard.WaitForReset(logger)
+	assert.Empty(delays)
+
+	guard.Observe(RateLimit{Resource: "search", Remaining: 0, Load: 2, Reset: now.Add(time.Minute)})
+	assert.False(guard.ShouldShed())
+
+	guard.Observe(RateLimit{Resource: "core", Limit: 5000, Remaining: 1000, Load: 0.49, Reset: now.Add(time.Minute)})
+	assert.False(guard.ShouldShed())
+	guard.Observe(RateLimit{Resource: "core", Limit: 5000, Remaining: 1000, Load: 0.5, Reset: now.Add(time.Minute)})
+	assert.True(guard.ShouldShed())
+
+	// Right after the window resets a single request is a high load
+	guard.Observe(RateLimit{Resource: "core", Limit: 1000, Remaining: 999, Load: 1, Reset: now.Add(time.Hour)})
+	assert.False(guard.ShouldShed())
+	guard.Pace(logger)
+	assert.Empty(delays)
+	guard.WaitForReset(logger)
+	assert.Empty(delays)
+
+	guard.Observe(RateLimit{Resource: "core", Remaining: DefaultRateLimitReserve, Load: 1.5, Reset: now.Add(time.Minute)})
+	guard.WaitForReset(logger)
+	assert.Equal([]time.Duration{time.Minute + time.Second}, delays)
+	_, ok := guard.Latest()
+	assert.False(ok, "The snapshot is cleared after waiting for the reset")
+
+	// Background work waits for the reset when the load is over the threshold
+	guard.Observe(RateLimit{Resource: "core", Limit: 5000, Remaining: 1000, Load: 0.49, Reset: now.Add(time.Minute)})
+	guard.Pace(logger)
+	assert.Len(delays, 1)
+	guard.Observe(RateLimit{Resource: "core", Limit: 5000, Remaining: 1000, Load: 0.5, Reset: now.Add(2 * time.Minute)})
+	guard.Pace(logger)
+	assert.Equal(2*time.Minute+time.Second, delays[1])
+	delays = delays[:1]
+
+	// No wait if the reset time has already passed
+	guard.Observe(RateLimit{Resource: "core", Remaining: 0, Load: 1.5, Reset: now.Add(-time.Minute)})
+	guard.WaitForReset(logger)
+	assert.Len(delays, 1)
+}
+
+type TestRateLimitCase struct {
+	Description     string
+	Comment         string
+	Limit           int
+	Remaining       int
+	ResetIn         time.Duration
+	ExpectedComment bool
+	ExpectedStatus  bool
+	ExpectedWaits   int
+}
+
+func TestRateLimitShedding(t *testing.T) {
+	assert := assert.New(t)
+	payloads, err := getPayloads()
+	assert.NoError(err)
+	now := time.Now().Truncate(time.Second)
+	// 59 minutes to the reset is 1 minute into the window, so 4900 used is far over
+	// the available budget. Just after the reset, 1 used is a load of 100%.
+	for _, tc := range []TestRateLimitCase{
+		{"help under budget", "/check-enforcer help", 5000, 4999, 59 * time.Minute, true, false, 0},
+		{"help just after reset", "/check-enforcer help", 1000, 999, time.Hour, true, false, 0},
+		{"help shed", "/check-enforcer help", 5000, 100, 59 * time.Minute, false, false, 0},
+		{"evaluate shed comment", "/check-enforcer evaluate", 5000, 100, 59 * time.Minute, false, true, 0},
+		{"evaluate waits for reset", "/check-enforcer evaluate", 5000, 5, 59 * time.Minute, false, true, 3},
+	} {
+		reset := strconv.FormatInt(now.Add(tc.ResetIn).Unix(), 10)
+		postedComment := false
+		postedStatus := false
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("x-ratelimit-limit", strconv.Itoa(tc.Limit))
+			w.Header().Set("x-ratelimit-remaining", strconv.Itoa(tc.Remaining))
+			w.Header().Set("x-ratelimit-reset", reset)
+			switch {
+			case isConfigRequest(req):
+				w.WriteHeader(http.StatusNotFound)
+			case strings.HasSuffix(req.URL.Path, "/pulls/1"):
+				w.Write(payloads.PullRequestResponse)
+			case strings.HasSuffix(req.URL.Path, "/check-suites"):
+				w.Write([]byte(`{"total_count": 0, "check_suites": []}`))
+			case strings.Contains(req.URL.Path, "/statuses/") && req.Method == "POST":
+				postedStatus = true
+				w.Write(payloads.StatusResponse)
+			case strings.HasSuffix(req.URL.Path, "/comments") && req.Method == "POST":
+				postedComment = true
+				w.Write(payloads.NewCommentResponse)
+			default:
+				assert.Fail(fmt.Sprintf("%s: Unexpected %s request to '%s'", tc.Description, req.Method, req.URL.String()))
+			}
+		}))
+		defer server.Close()
+
+		delays := []time.Duration{}
+		gh, err := NewGithubClient(server.URL, "", "Octocat App")
+		assert.NoError(err)
+		gh.RateLimit = newTestRateLimitGuard(now, &delays)
+
+		event := strings.Replace(string(payloads.IssueCommentEvent), "You are totally right! I'll get this fixed right away.", tc.Comment, 1)
+		assert.NoError(handleEvent(gh, []byte(event)), tc.Description)
+
+		assert.Equal(tc.ExpectedComment, postedComment, tc.Description)
+		assert.Equal(tc.ExpectedStatus, postedStatus, tc.Description)
+		assert.Len(delays, tc.ExpectedWaits, tc.Description)
+	}
+}
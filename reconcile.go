@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	ReconcileUpdated   = "updated"
+	ReconcileUnchanged = "unchanged"
+	ReconcileSkipped   = "skipped"
+	ReconcileError     = "error"
+)
+
+// ReconcileResult counts the open pull requests of a reconcile sweep by result.
+type ReconcileResult map[string]int
+
+func (r ReconcileResult) String() string {
+	return fmt.Sprintf("%d updated, %d unchanged, %d skipped, %d failed",
+		r[ReconcileUpdated], r[ReconcileUnchanged], r[ReconcileSkipped], r[ReconcileError])
+}
+
+// handleSchedule reconciles the repository of a github actions workflow run by a
+// schedule trigger. Schedule payloads may not include the repository, in which case
+// it is read from GITHUB_REPOSITORY.
+func handleSchedule(gh *GithubClient, webhook *ScheduleWebhook) error {
+	repoName := webhook.Repo.FullName
+	if repoName == "" {
+		repoName = os.Getenv("GITHUB_REPOSITORY")
+	}
+	if repoName == "" {
+		return errors.New("Error: No repository in schedule payload or GITHUB_REPOSITORY.")
+	}
+	_, err := reconcileRepo(gh, repoName)
+	return err
+}
+
+// reconcileRepos sweeps each repository with a new client, so that each repository
+// is evaluated with its own config. Clients are created for the default installation.
+func reconcileRepos(repoNames []string, newClient func(installationId int) (*GithubClient, error)) error {
+	failed := []string{}
+	for _, repoName := range repoNames {
+		gh, err := newClient(0)
+		if err != nil {
+			return err
+		}
+		if _, err = reconcileRepo(gh, repoName); err != nil {
+			gh.Log.Error(err.Error())
+			failed = append(failed, repoName)
+		}
+		if gh.DryRun != nil {
+			gh.Log.Info(gh.DryRun.Summary(), Fields{"mutations": len(gh.DryRun.Mutations())})
+		}
+	}
+	if len(failed) > 0 {
+		return errors.New(fmt.Sprintf("Failed to reconcile %s", strings.Join(failed, ", ")))
+	}
+	return nil
+}
+
+// reconcileEvery sweeps the repositories in the background every interval. A sweep
+// that runs longer than the interval delays the next one instead of overlapping it.
+func reconcileEvery(interval time.Duration, repoNames []string, newClient func(installationId int) (*GithubClient, error)) {
+	logger.Info(fmt.Sprintf("Reconciling %s every %s", strings.Join(repoNames, ", "), interval))
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := reconcileRepos(repoNames, newClient); err != nil {
+				logger.Error(err.Error())
+			}
+		}
+	}()
+}
+
+// reconcileRepo re-evaluates the head commit of every open pull request in the
+// repository, to repair statuses left stale by a dropped webhook or a failed actions
+// run. Pull requests are checked one at a time and pace themselves to the rate limit.
+// Pull requests that fail are logged and counted, and do not stop the sweep.
+func reconcileRepo(gh *GithubClient, repoName string) (ReconcileResult, error) {
+	gh.Log = gh.Log.With(Fields{"event": "reconcile", "repo": repoName})
+	gh.Log.Info("Reconciling open pull requests.")
+
+	repo, err := gh.GetRepo(repoName)
+	if err != nil {
+		return nil, err
+	}
+	if err = gh.LoadConfig(repo); err != nil {
+		return nil, err
+	}
+	pulls, err := gh.GetOpenPullRequests(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	log := gh.Log
+	defer func() { gh.Log = log }()
+
+	result := ReconcileResult{}
+	for _, pr := range pulls {
+		if gh.RateLimit != nil {
+			gh.RateLimit.Pace(log)
+		}
+		gh.Log = log.With(Fields{"pr": pr.Number, "sha": pr.Head.Sha})
+		outcome, err := reconcilePullRequest(gh, pr)
+		if err != nil {
+			gh.Log.Error(fmt.Sprintf("Error reconciling pull request #%d: %v", pr.Number, err))
+			outcome = ReconcileError
+		}
+		result[outcome]++
+		metrics.Inc(MetricReconciled, Labels{"result": outcome})
+	}
+
+	log.Info(fmt.Sprintf("Reconciled %d open pull request(s): %s.", len(pulls), result), Fields{
+		"updated": result[ReconcileUpdated], "unchanged": result[ReconcileUnchanged],
+		"skipped": result[ReconcileSkipped], "failed": result[ReconcileError],
+	})
+	if result[ReconcileError] > 0 {
+		return result, errors.New(fmt.Sprintf("Failed to reconcile %d of %d open pull request(s) in '%s'", result[ReconcileError], len(pulls), repoName))
+	}
+	return result, nil
+}
+
+// reconcilePullRequest evaluates the check suites of the pull request head commit the
+// same as for a check suite event, and publishes the result unless it is already
+// published. Overrides are left in place.
+func reconcilePullRequest(gh *GithubClient, pr PullRequest) (string, error) {
+	if gh.Config.IsSkippedBranch(pr.Head.Ref) {
+		gh.Log.Info(fmt.Sprintf("Skipping pull request #%d for branch '%s'.", pr.Number, pr.Head.Ref))
+		return ReconcileSkipped, nil
+	}
+
+	target := CommitTarget{Repo: pr.Base.Repo, HeadSha: pr.Head.Sha, StatusesUrl: pr.StatusesUrl, PullNumber: pr.Number}
+	published, err := getPublishedStatus(gh, target)
+	if err != nil {
+		return "", err
+	}
+	if published != nil && isOverride(*published) {
+		gh.Log.Info(fmt.Sprintf("Skipping pull request #%d, check enforcer was overridden.", pr.Number))
+		return ReconcileSkipped, nil
+	}
+
+	checkSuites, err := gh.GetCheckSuiteStatuses(pr.GetCheckSuiteUrl())
+	if err != nil {
+		return "", err
+	}
+	status, evaluation, err := getStatusForCheckSuiteConclusions(gh, checkSuites, target)
+	if err != nil {
+		return "", err
+	}
+
+	if isPublished(gh.Config, published, status) {
+		gh.Log.Info(fmt.Sprintf("Status for pull request #%d is already '%s': %s", pr.Number, status.State, status.Description))
+		return ReconcileUnchanged, nil
+	}
+
+	gh.Log.Info(fmt.Sprintf("Updating stale status for pull request #%d to '%s': %s", pr.Number, status.State, status.Description))
+	if err = publish(gh, target, status, evaluation); err != nil {
+		return "", err
+	}
+	return ReconcileUpdated, nil
+}
+
+// getPublishedStatus returns the result check enforcer last published for the commit,
+// or nil if there is none. For check run output, the latest check run is read back as
+// a status. With both outputs, the commit status is used.
+func getPublishedStatus(gh *GithubClient, target CommitTarget) (*StatusBody, error) {
+	if gh.Config.Output == OutputCheckRun {
+		name := gh.Config.CheckRunName
+		runs, err := gh.GetCheckRuns(target.GetCommitCheckRunsUrl() + "?check_name=" + url.QueryEscape(name))
+		if err != nil {
+			return nil, err
+		}
+		var latest *CheckRun
+		for i, run := range runs {
+			if run.Name == name && (latest == nil || run.Id > latest.Id) {
+				latest = &runs[i]
+			}
+		}
+		if latest == nil {
+			return nil, nil
+		}
+		status := StatusBody{State: CommitStatePending, Description: latest.Output.Title, Context: name}
+		if latest.Status == CheckSuiteStatusCompleted {
+			status.State = CommitStateFailure
+			if latest.Conclusion == CheckSuiteConclusionSuccess {
+				status.State = CommitStateSuccess
+			}
+		}
+		return &status, nil
+	}
+
+	combined, err := gh.GetCombinedStatus(target.GetCombinedStatusUrl())
+	if err != nil {
+		return nil, err
+	}
+	for _, status := range combined.Statuses {
+		if status.Context == gh.Config.StatusContext {
+			return &status, nil
+		}
+	}
+	return nil, nil
+}
+
+// isPublished returns whether the published result has the same state and description
+// as the status. The link to the run that published it, and the age of stuck checks,
+// are not compared.
+func isPublished(config *Config, published *StatusBody, status StatusBody) bool {
+	if published == nil {
+		return false
+	}
+	state := status.State
+	if config.Output == OutputCheckRun && state == CommitStateError {
+		// Check runs have no error conclusion, see newCheckRunBody
+		state = CommitStateFailure
+	}
+	return published.State == state && withoutStuckAge(published.Description) == withoutStuckAge(status.Description)
+}
+
+// GetRepo fetches a repository by its full name, e.g. Azure/azure-sdk-for-go.
+func (gh *GithubClient) GetRepo(fullName string) (Repo, error) {
+	target, err := gh.getUrl(strings.TrimSuffix(gh.BaseUrl.String(), "/") + "/repos/" + fullName)
+	if err != nil {
+		return Repo{}, err
+	}
+
+	req, err := http.NewRequest("GET", target.String(), nil)
+	if err != nil {
+		return Repo{}, err
+	}
+
+	gh.setHeaders(req)
+
+	data, err := gh.request(req)
+	if err != nil {
+		return Repo{}, err
+	}
+
+	repo := Repo{}
+	if err = json.Unmarshal(data, &repo); err != nil {
+		return Repo{}, err
+	}
+
+	return repo, nil
+}
+
+// GetOpenPullRequests lists all open pull requests of a repository.
+func (gh *GithubClient) GetOpenPullRequests(repo Repo) ([]PullRequest, error) {
+	pulls := []PullRequest{}
+
+	err := gh.getPaged(repo.GetOpenPullsUrl(), func(data []byte) error {
+		page := []PullRequest{}
+		if err := json.Unmarshal(data, &page); err != nil {
+			return err
+		}
+		pulls = append(pulls, page...)
+		return nil
+	})
+	if err != nil {
+		return []PullRequest{}, err
+	}
+
+	return pulls, nil
+}
+
+// GetCombinedStatus fetches the latest status for each context of a commit.
+func (gh *GithubClient) GetCombinedStatus(statusUrl string) (CombinedStatus, error) {
+	combined := CombinedStatus{}
+
+	err := gh.getPaged(statusUrl, func(data []byte) error {
+		page := CombinedStatus{}
+		if err := json.Unmarshal(data, &page); err != nil {
+			return err
+		}
+		combined.State = page.State
+		combined.Sha = page.Sha
+		combined.Statuses = append(combined.Statuses, page.Statuses...)
+		return nil
+	})
+	if err != nil {
+		return CombinedStatus{}, err
+	}
+
+	return combined, nil
+}
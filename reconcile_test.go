@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type TestReconcileCase struct {
+	Description string
+	Ref         string
+	Sha         string
+	Published   *StatusBody
+	Expected    string
+}
+
+func TestReconcile(t *testing.T) {
+	assert := assert.New(t)
+	payloads, err := getPayloads()
+	assert.NoError(err)
+	pr := PullRequest{}
+	assert.NoError(json.Unmarshal(payloads.PullRequestResponse, &pr))
+
+	succeeded := newSucceededBody(CommitStatusContext)
+	pending := newPendingBody(CommitStatusContext)
+	override := newSucceededBody(CommitStatusContext)
+	override.Description = newOverrideDescription(OverrideAudit{User: "octocat", Reason: "pipelines are down"})
+	other := StatusBody{State: CommitStatePending, Context: "license/cla"}
+
+	cases := []TestReconcileCase{
+		{"unchanged", "feature-1", strings.Repeat("a", 40), &succeeded, ReconcileUnchanged},
+		{"stale", "feature-2", strings.Repeat("b", 40), &pending, ReconcileUpdated},
+		{"overridden", "feature-3", strings.Repeat("c", 40), &override, ReconcileSkipped},
+		{"skipped branch", "main", strings.Repeat("d", 40), nil, ReconcileSkipped},
+		{"never published", "feature-5", strings.Repeat("e", 40), &other, ReconcileUpdated},
+	}
+
+	pulls := []PullRequest{}
+	for i, tc := range cases {
+		pull := pr
+		pull.Number = i + 1
+		pull.Head.Ref = tc.Ref
+		pull.Head.Sha = tc.Sha
+		pull.StatusesUrl = "https://api.github.com/repos/octocat/Hello-World/statuses/" + tc.Sha
+		pulls = append(pulls, pull)
+	}
+
+	posted := map[string]StatusBody{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		path := req.URL.Path
+		switch {
+		case isConfigRequest(req):
+			w.WriteHeader(http.StatusNotFound)
+		case path == "/repos/octocat/Hello-World":
+			json.NewEncoder(w).Encode(pr.Base.Repo)
+		case path == "/repos/octocat/Hello-World/pulls":
+			assert.Equal("open", req.URL.Query().Get("state"))
+			json.NewEncoder(w).Encode(pulls)
+		case strings.HasSuffix(path, "/status"):
+			for _, tc := range cases {
+				if strings.Contains(path, tc.Sha) {
+					combined := CombinedStatus{Sha: tc.Sha, Statuses: []StatusBody{}}
+					if tc.Published != nil {
+						combined.Statuses = append(combined.Statuses, *tc.Published)
+					}
+					json.NewEncoder(w).Encode(combined)
+				}
+			}
+		case strings.HasSuffix(path, "/check-suites"):
+			w.Write([]byte(`{"total_count": 1, "check_suites": [{"id": 1, "status": "completed", "conclusion": "success", "latest_check_runs_count": 1, "app": {"name": "Octocat App"}}]}`))
+		case strings.Contains(path, "/statuses/") && req.Method == "POST":
+			posted[path[strings.LastIndex(path, "/")+1:]] = getStatusBody(assert, req)
+			w.Write(payloads.StatusResponse)
+		default:
+			assert.Fail(fmt.Sprintf("Unexpected %s request to '%s'", req.Method, req.URL.String()))
+		}
+	}))
+	defer server.Close()
+
+	gh, err := NewGithubClient(server.URL, "", "Octocat App")
+	assert.NoError(err)
+	result, err := reconcileRepo(gh, "octocat/Hello-World")
+	assert.NoError(err)
+	assert.Equal(ReconcileResult{ReconcileUpdated: 2, ReconcileUnchanged: 1, ReconcileSkipped: 2}, result)
+	assert.Equal("2 updated, 1 unchanged, 2 skipped, 0 failed", result.String())
+
+	for _, tc := range cases {
+		status, ok := posted[tc.Sha]
+		assert.Equal(tc.Expected == ReconcileUpdated, ok, tc.Description)
+		if ok {
+			assert.Equal(CommitStateSuccess, status.State, tc.Description)
+		}
+	}
+
+	// A schedule trigger reconciles the repository of the workflow
+	posted = map[string]StatusBody{}
+	gh, err = NewGithubClient(server.URL, "", "Octocat App")
+	assert.NoError(err)
+	assert.NoError(handleEvent(gh, []byte(`{"schedule": "*/30 * * * *", "repository": {"full_name": "octocat/Hello-World"}}`)))
+	assert.Len(posted, 2)
+
+	// Schedule payloads without the repository fall back to GITHUB_REPOSITORY
+	if repository, ok := os.LookupEnv("GITHUB_REPOSITORY"); ok {
+		defer os.Setenv("GITHUB_REPOSITORY", repository)
+	} else {
+		defer os.Unsetenv("GITHUB_REPOSITORY")
+	}
+	os.Setenv("GITHUB_REPOSITORY", "octocat/Hello-World")
+	posted = map[string]StatusBody{}
+	gh, err = NewGithubClient(server.URL, "", "Octocat App")
+	assert.NoError(err)
+	assert.NoError(handleEvent(gh, []byte(`{"schedule": "*/30 * * * *"}`)))
+	assert.Len(posted, 2)
+}
+
+func TestIsPublished(t *testing.T) {
+	assert := assert.New(t)
+	config := NewDefaultConfig()
+	stuck := StatusBody{State: CommitStateError, Description: "Stuck: 'ci' pending for 2d 1h"}
+
+	assert.False(isPublished(config, nil, stuck))
+	assert.True(isPublished(config, &StatusBody{State: CommitStateError, Description: stuck.Description, TargetUrl: "https://example.com"}, stuck))
+	assert.True(isPublished(config, &StatusBody{State: CommitStateError, Description: "Stuck: 'ci' pending for 1d 23h"}, stuck), "The age of stuck checks changes between sweeps")
+	assert.False(isPublished(config, &StatusBody{State: CommitStateError, Description: "Stuck: 'lint' pending for 2d 1h"}, stuck))
+	assert.False(isPublished(config, &StatusBody{State: CommitStateError, Description: "Stuck: 'ci' pending for 2d 1h (+1 more)"}, stuck))
+	assert.False(isPublished(config, &StatusBody{State: CommitStateFailure, Description: stuck.Description}, stuck))
+
+	// Error statuses are published as failed check runs
+	config.Output = OutputCheckRun
+	assert.True(isPublished(config, &StatusBody{State: CommitStateFailure, Description: stuck.Description}, stuck))
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy controls how failed API requests are retried. Retries use exponential
+// backoff with full jitter, unless the response says how long to wait via the
+// Retry-After or x-ratelimit-reset headers.
+// https://docs.github.com/en/rest/using-the-rest-api/rate-limits-for-the-rest-api#handle-rate-limit-errors-appropriately
+type RetryPolicy struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+	// MaxWait is the longest we will wait for a rate limit to reset before giving up.
+	MaxWait time.Duration
+
+	sleep  func(time.Duration)
+	now    func() time.Time
+	jitter func() float64
+}
+
+func NewDefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxRetries: 4,
+		BaseDelay:  time.Second,
+		MaxDelay:   30 * time.Second,
+		MaxWait:    5 * time.Minute,
+		sleep:      time.Sleep,
+		now:        time.Now,
+		jitter:     rand.Float64,
+	}
+}
+
+// isIdempotentMethod returns whether a request can be repeated without side effects.
+// POST requests are not, but callers may still opt in for POSTs that are safe to
+// repeat, e.g. setting a commit status to the same value twice.
+func isIdempotentMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// getRetryDelay returns how long to wait before retrying a request, and false if the
+// request should not be retried. resp is nil if the request failed without a response,
+// and body is the response body.
+func (p RetryPolicy) getRetryDelay(attempt int, resp *http.Response, body []byte) (time.Duration, bool) {
+	if attempt >= p.MaxRetries {
+		return 0, false
+	}
+
+	if resp == nil {
+		return p.getBackoff(attempt), true
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests || isSecondaryRateLimit(resp, body):
+		delay, ok := p.getRateLimitDelay(resp)
+		if !ok {
+			return p.getBackoff(attempt), true
+		}
+		// Don't block for a long time on a rate limit that won't reset soon.
+		if delay > p.MaxWait {
+			return 0, false
+		}
+		return delay, true
+	case resp.StatusCode >= 500:
+		return p.getBackoff(attempt), true
+	}
+
+	return 0, false
+}
+
+// getBackoff returns a random delay between 0 and BaseDelay * 2^attempt, capped at MaxDelay.
+func (p RetryPolicy) getBackoff(attempt int) time.Duration {
+	ceiling := float64(p.BaseDelay) * math.Pow(2, float64(attempt))
+	if ceiling > float64(p.MaxDelay) {
+		ceiling = float64(p.MaxDelay)
+	}
+	return time.Duration(ceiling * p.jitter())
+}
+
+// getRateLimitDelay reads how long to wait from the response headers, preferring
+// Retry-After over x-ratelimit-reset as github recommends.
+func (p RetryPolicy) getRateLimitDelay(resp *http.Response) (time.Duration, bool) {
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
+		if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+
+	if resp.Header.Get("x-ratelimit-remaining") == "0" {
+		if resetUnix, err := strconv.ParseInt(resp.Header.Get("x-ratelimit-reset"), 10, 64); err == nil {
+			// Wait an extra second in case our clock is slightly ahead of github's.
+			delay := time.Unix(resetUnix, 0).Sub(p.now()) + time.Second
+			if delay < 0 {
+				delay = 0
+			}
+			return delay, true
+		}
+	}
+
+	return 0, false
+}
+
+// isSecondaryRateLimit distinguishes 403 responses for rate limits from 403 responses
+// for missing permissions, which should not be retried. Secondary rate limits do not
+// always set the rate limit headers, but their message says so.
+func isSecondaryRateLimit(resp *http.Response, body []byte) bool {
+	if resp.StatusCode != http.StatusForbidden {
+		return false
+	}
+	if resp.Header.Get("Retry-After") != "" || resp.Header.Get("x-ratelimit-remaining") == "0" {
+		return true
+	}
+	return strings.Contains(strings.ToLower(string(body)), "secondary rate limit")
+}
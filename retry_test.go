@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestRetryPolicy returns a policy that records delays instead of sleeping.
+func newTestRetryPolicy(now time.Time, delays *[]time.Duration) RetryPolicy {
+	policy := NewDefaultRetryPolicy()
+	policy.sleep = func(d time.Duration) { *delays = append(*delays, d) }
+	policy.now = func() time.Time { return now }
+	policy.jitter = func() float64 { return 1 }
+	return policy
+}
+
+type TestRetryCase struct {
+	Description      string
+	Method           string
+	Responses        []int
+	Headers          map[string]string
+	ExpectedAttempts int
+	ExpectedDelays   []time.Duration
+	ShouldSucceed    bool
+}
+
+func TestRetry(t *testing.T) {
+	assert := assert.New(t)
+	payloads, err := getPayloads()
+	assert.NoError(err)
+	now := time.Now().Truncate(time.Second)
+	reset := strconv.FormatInt(now.Add(20*time.Second).Unix(), 10)
+	farReset := strconv.FormatInt(now.Add(time.Hour).Unix(), 10)
+
+	for _, tc := range []TestRetryCase{
+		{"GET success", "GET", []int{200}, nil, 1, nil, true},
+		{"GET 502 then success", "GET", []int{502, 503, 200}, nil, 3, []time.Duration{time.Second, 2 * time.Second}, true},
+		{"GET 500 exhausts retries", "GET", []int{500, 500, 500, 500, 500, 500}, nil, 5,
+			[]time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second}, false},
+		{"GET 404 not retried", "GET", []int{404, 200}, nil, 1, nil, false},
+		{"GET 403 permission not retried", "GET", []int{403, 200}, nil, 1, nil, false},
+		{"GET 403 secondary rate limit with Retry-After", "GET", []int{403, 200}, map[string]string{"Retry-After": "7"}, 2, []time.Duration{7 * time.Second}, true},
+		{"GET 429 with ratelimit reset", "GET", []int{429, 200},
+			map[string]string{"x-ratelimit-remaining": "0", "x-ratelimit-reset": reset}, 2, []time.Duration{21 * time.Second}, true},
+		{"GET 403 with ratelimit reset too far away", "GET", []int{403, 200},
+			map[string]string{"x-ratelimit-remaining": "0", "x-ratelimit-reset": farReset}, 1, nil, false},
+		{"GET 429 without headers uses backoff", "GET", []int{429, 200}, nil, 2, []time.Duration{time.Second}, true},
+		{"status POST retried", "POST", []int{502, 201}, nil, 2, []time.Duration{time.Second}, true},
+	} {
+		attempts := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.Method == "POST" {
+				status := getStatusBody(assert, req)
+				assert.Equal(CommitStateSuccess, status.State, "%s: request body must be resent on retry", tc.Description)
+			}
+			code := tc.Responses[attempts]
+			attempts++
+			if code >= 400 {
+				for k, v := range tc.Headers {
+					w.Header().Set(k, v)
+				}
+			}
+			w.WriteHeader(code)
+			if tc.Method == "GET" {
+				w.Write(payloads.PullRequestResponse)
+			} else {
+				w.Write(payloads.StatusResponse)
+			}
+		}))
+		defer server.Close()
+
+		var delays []time.Duration
+		gh, err := NewGithubClient(server.URL, "")
+		assert.NoError(err)
+		gh.retry = newTestRetryPolicy(now, &delays)
+
+		fmt.Println(fmt.Sprintf("\n\n========= %s =========", tc.Description))
+		if tc.Method == "GET" {
+			_, err = gh.GetPullRequest(server.URL + "/repos/test/test/pulls/1")
+		} else {
+			err = gh.SetStatus(server.URL+"/repos/test/test/statuses/abc", newSucceededBody(CommitStatusContext))
+		}
+		if tc.ShouldSucceed {
+			assert.NoError(err, tc.Description)
+		} else {
+			assert.Error(err, tc.Description)
+		}
+		assert.Equal(tc.ExpectedAttempts, attempts, tc.Description)
+		assert.Equal(tc.ExpectedDelays, delays, tc.Description)
+	}
+}
+
+func TestCommentNotRetried(t *testing.T) {
+	assert := assert.New(t)
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	var delays []time.Duration
+	gh, err := NewGithubClient(server.URL, "")
+	assert.NoError(err)
+	gh.retry = newTestRetryPolicy(time.Now(), &delays)
+
+	// A comment POST that failed may still have been created, so it is not retried to avoid duplicates.
+	err = gh.CreateIssueComment(server.URL+"/repos/test/test/issues/1/comments", "test")
+	assert.Error(err)
+	assert.Equal(1, attempts)
+	assert.Empty(delays)
+}
+
+func TestRetryBackoff(t *testing.T) {
+	assert := assert.New(t)
+	policy := NewDefaultRetryPolicy()
+	policy.jitter = func() float64 { return 0.5 }
+
+	assert.Equal(500*time.Millisecond, policy.getBackoff(0))
+	assert.Equal(4*time.Second, policy.getBackoff(3))
+	// Capped at MaxDelay
+	assert.Equal(15*time.Second, policy.getBackoff(10))
+
+	// Transport errors with no response are retried
+	delay, ok := policy.getRetryDelay(0, nil, nil)
+	assert.True(ok)
+	assert.Equal(500*time.Millisecond, delay)
+	_, ok = policy.getRetryDelay(policy.MaxRetries, nil, nil)
+	assert.False(ok)
+}
+
+func TestSecondaryRateLimitMessage(t *testing.T) {
+	assert := assert.New(t)
+	policy := NewDefaultRetryPolicy()
+	forbidden := &http.Response{StatusCode: http.StatusForbidden, Header: http.Header{}}
+
+	// Without rate limit headers, the message tells secondary rate limits from missing permissions
+	_, ok := policy.getRetryDelay(1, forbidden, []byte(`{"message": "Resource not accessible by integration"}`))
+	assert.False(ok)
+
+	payloads, err := getPayloads()
+	assert.NoError(err)
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"message": "You have exceeded a secondary rate limit. Please wait a few minutes before you try again."}`))
+			return
+		}
+		w.Write(payloads.PullRequestResponse)
+	}))
+	defer server.Close()
+
+	var delays []time.Duration
+	gh, err := NewGithubClient(server.URL, "")
+	assert.NoError(err)
+	gh.retry = newTestRetryPolicy(time.Now(), &delays)
+	_, err = gh.GetPullRequest(server.URL + "/repos/test/test/pulls/1")
+	assert.NoError(err)
+	assert.Equal(2, attempts)
+	assert.Equal([]time.Duration{time.Second}, delays, "Secondary rate limits without headers use the normal backoff")
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/azure/azure-sdk-actions/fakegithub"
+	"github.com/stretchr/testify/assert"
+)
+
+const scenarioSha = "6dcb09b5b57875f334f61aebed695e2e4193db5e"
+
+// ScenarioStep changes the state of the fake github, and returns the payload of the
+// webhook event for the change.
+type ScenarioStep struct {
+	Description         string
+	Event               func() ([]byte, error)
+	ExpectedState       CommitState
+	ExpectedDescription string
+}
+
+func newScenario() (*fakegithub.Server, *fakegithub.Repo) {
+	server := fakegithub.New()
+	repo := server.AddRepo("octocat/Hello-World")
+	repo.AddPullRequest(fakegithub.PullRequest{Number: 1347, HeadRef: "new-topic", HeadSha: scenarioSha})
+	return server, repo
+}
+
+// runScenario handles the event of each step with a new client, as each webhook
+// delivery is handled, and checks the latest check enforcer status after it.
+func runScenario(assert *assert.Assertions, server *fakegithub.Server, repo *fakegithub.Repo, steps []ScenarioStep) {
+	for _, step := range steps {
+		payload, err := step.Event()
+		if !assert.NoError(err, step.Description) {
+			return
+		}
+		gh, err := NewGithubClient(server.URL, "", AzurePipelinesAppName)
+		assert.NoError(err)
+		assert.NoError(handleEvent(gh, payload), step.Description)
+
+		status, ok := repo.LatestStatus(scenarioSha, CommitStatusContext)
+		if assert.True(ok, step.Description) {
+			assert.Equal(string(step.ExpectedState), status.State, step.Description)
+			assert.Equal(step.ExpectedDescription, status.Description, step.Description)
+		}
+	}
+}
+
+func TestScenarioPolicyServiceBeforePipelines(t *testing.T) {
+	assert := assert.New(t)
+	server, repo := newScenario()
+	defer server.Close()
+
+	policy := repo.AddCheckSuite(fakegithub.CheckSuite{App: "GitHub Policy Service", HeadSha: scenarioSha})
+	var pipelines fakegithub.CheckSuite
+
+	runScenario(assert, server, repo, []ScenarioStep{
+		{"pull request opened", func() ([]byte, error) {
+			return repo.PullRequestEvent("opened", 1347)
+		}, CommitStatePending, "Waiting for pipelines to start"},
+		{"policy service completes before pipelines register", func() ([]byte, error) {
+			repo.UpdateCheckSuite(policy.Id, fakegithub.StatusCompleted, "success")
+			return repo.CheckSuiteEvent("completed", policy.Id)
+		}, CommitStatePending, "Waiting for all checks to succeed"},
+		{"pipelines fail", func() ([]byte, error) {
+			pipelines = repo.AddCheckSuite(fakegithub.CheckSuite{App: AzurePipelinesAppName, HeadSha: scenarioSha, Status: fakegithub.StatusCompleted, Conclusion: "failure"})
+			if _, err := repo.AddCheckRun(fakegithub.CheckRun{SuiteId: pipelines.Id, Name: "ci", Status: fakegithub.StatusCompleted, Conclusion: "failure"}); err != nil {
+				return nil, err
+			}
+			return repo.CheckSuiteEvent("completed", pipelines.Id)
+		}, CommitStatePending, "Waiting for all checks to succeed"},
+		{"pipelines succeed on retry", func() ([]byte, error) {
+			repo.UpdateCheckSuite(pipelines.Id, fakegithub.StatusCompleted, "success")
+			return repo.CheckSuiteEvent("completed", pipelines.Id)
+		}, CommitStateSuccess, "All checks passed"},
+		{"evaluate comment", func() ([]byte, error) {
+			return repo.IssueCommentEvent(1347, "octocat", "/check-enforcer evaluate")
+		}, CommitStateSuccess, "All checks passed"},
+	})
+
+	assert.Len(repo.Statuses(scenarioSha), 5)
+	assert.Len(repo.Comments(1347), 1, "Check enforcer does not comment when pipelines ran")
+}
+
+func TestScenarioOverride(t *testing.T) {
+	assert := assert.New(t)
+	server, repo := newScenario()
+	defer server.Close()
+	repo.SetPermission("octocat", "write")
+
+	runScenario(assert, server, repo, []ScenarioStep{
+		{"pull request opened", func() ([]byte, error) {
+			return repo.PullRequestEvent("opened", 1347)
+		}, CommitStatePending, "Waiting for pipelines to start"},
+		{"override denied", func() ([]byte, error) {
+			return repo.IssueCommentEvent(1347, "hubot", "/check-enforcer override pipelines are down")
+		}, CommitStatePending, "Waiting for pipelines to start"},
+		{"override", func() ([]byte, error) {
+			return repo.IssueCommentEvent(1347, "octocat", "/check-enforcer override pipelines are down")
+		}, CommitStateSuccess, "Overridden by @octocat: pipelines are down"},
+	})
+
+	comments := repo.Comments(1347)
+	if assert.Len(comments, 4) {
+		assert.Equal(fakegithub.DefaultUser, comments[1].User)
+		assert.Contains(comments[1].Body, "@hubot you are not allowed to override check enforcer")
+		assert.Contains(comments[3].Body, "overridden by @octocat")
+	}
+}
+
+func TestScenarioCheckRunOutput(t *testing.T) {
+	assert := assert.New(t)
+	server, repo := newScenario()
+	defer server.Close()
+	repo.SetFile(ConfigPath, "version: 1\noutput: check_run\n")
+
+	pipelines := repo.AddCheckSuite(fakegithub.CheckSuite{App: AzurePipelinesAppName, HeadSha: scenarioSha, Status: fakegithub.StatusInProgress})
+	ci, err := repo.AddCheckRun(fakegithub.CheckRun{SuiteId: pipelines.Id, Name: "ci"})
+	assert.NoError(err)
+	for i, conclusion := range []string{"", "success"} {
+		if conclusion != "" {
+			assert.NoError(repo.UpdateCheckRun(ci.Id, fakegithub.StatusCompleted, conclusion))
+			assert.NoError(repo.UpdateCheckSuite(pipelines.Id, fakegithub.StatusCompleted, conclusion))
+		}
+		payload, err := repo.WorkflowRunEvent("completed", scenarioSha, "pull_request")
+		assert.NoError(err)
+		gh, err := NewGithubClient(server.URL, "", AzurePipelinesAppName)
+		assert.NoError(err)
+		assert.NoError(handleEvent(gh, payload), fmt.Sprintf("event %d", i))
+	}
+
+	runs := []fakegithub.CheckRun{}
+	for _, run := range repo.CheckRuns(scenarioSha) {
+		if run.Name == "Check Enforcer" {
+			runs = append(runs, run)
+		}
+	}
+	if assert.Len(runs, 1, "The in progress check run is updated") {
+		assert.Equal(fakegithub.StatusCompleted, runs[0].Status)
+		assert.Equal("success", runs[0].Conclusion)
+		assert.True(strings.Contains(runs[0].Output.Title, "passed"), runs[0].Output.Title)
+	}
+	assert.Empty(repo.Statuses(scenarioSha), "Only a check run is published")
+}
+
+func TestScenarioCheckRunInGithubActionsSuite(t *testing.T) {
+	assert := assert.New(t)
+	server, repo := newScenario()
+	defer server.Close()
+	// With GITHUB_TOKEN, our check run is part of the GitHub Actions check suite
+	server.SetApp(GithubActionsAppName)
+	repo.SetFile(ConfigPath, "version: 1\noutput: check_run\n")
+
+	pipelines := repo.AddCheckSuite(fakegithub.CheckSuite{App: AzurePipelinesAppName, HeadSha: scenarioSha, Status: fakegithub.StatusInProgress})
+	ci, err := repo.AddCheckRun(fakegithub.CheckRun{SuiteId: pipelines.Id, Name: "ci"})
+	assert.NoError(err)
+	policy := repo.AddCheckSuite(fakegithub.CheckSuite{App: "GitHub Policy Service", HeadSha: scenarioSha, Status: fakegithub.StatusCompleted, Conclusion: "success"})
+
+	for _, step := range []struct {
+		Description string
+		Event       func() ([]byte, error)
+		Expected    string
+	}{
+		{"pipelines in progress", func() ([]byte, error) {
+			return repo.WorkflowRunEvent("completed", scenarioSha, "pull_request")
+		}, ""},
+		{"pipelines succeed", func() ([]byte, error) {
+			assert.NoError(repo.UpdateCheckRun(ci.Id, fakegithub.StatusCompleted, "success"))
+			assert.NoError(repo.UpdateCheckSuite(pipelines.Id, fakegithub.StatusCompleted, "success"))
+			return repo.CheckSuiteEvent("completed", pipelines.Id)
+		}, "success"},
+		{"ignored app completes", func() ([]byte, error) {
+			return repo.CheckSuiteEvent("completed", policy.Id)
+		}, "success"},
+	} {
+		payload, err := step.Event()
+		if !assert.NoError(err, step.Description) {
+			return
+		}
+		gh, err := NewGithubClient(server.URL, "", AzurePipelinesAppName, GithubActionsAppName)
+		assert.NoError(err)
+		assert.NoError(handleEvent(gh, payload), step.Description)
+
+		runs := []fakegithub.CheckRun{}
+		for _, run := range repo.CheckRuns(scenarioSha) {
+			if run.Name == "Check Enforcer" {
+				runs = append(runs, run)
+			}
+		}
+		if assert.Len(runs, 1, step.Description) {
+			assert.Equal(step.Expected, runs[0].Conclusion, step.Description)
+		}
+	}
+}
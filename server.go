@@ -0,0 +1,227 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+const WebhookSecretKey = "GITHUB_WEBHOOK_SECRET"
+
+// GitHub caps webhook payloads at 25MB.
+const maxPayloadBytes = 25 * 1024 * 1024
+
+// GitHub fails a delivery that is not answered within 10 seconds, while handling an
+// event can wait minutes for retries or the rate limit to reset. Deliveries are
+// acknowledged once verified and handled by a pool of workers.
+const DefaultWebhookWorkers = 4
+const DefaultWebhookQueueSize = 100
+
+var errUnsupportedEvent = errors.New("Unsupported event type")
+
+// WebhookServer handles github webhook deliveries over HTTP. Each delivery is
+// handled with a new client from newClient, since the client holds the config
+// loaded for the repository of the event. newClient is passed the github app
+// installation id of the delivery, or 0 if it is not set.
+type WebhookServer struct {
+	secret    []byte
+	newClient func(installationId int) (*GithubClient, error)
+	timers    *Timers
+	queue     chan webhookDelivery
+	// pending tracks queued deliveries until they are handled.
+	pending sync.WaitGroup
+}
+
+type webhookDelivery struct {
+	id        string
+	eventType string
+	payload   []byte
+}
+
+func NewWebhookServer(secret string, newClient func(installationId int) (*GithubClient, error)) (*WebhookServer, error) {
+	return newWebhookServer(secret, newClient, DefaultWebhookWorkers, DefaultWebhookQueueSize)
+}
+
+func newWebhookServer(secret string, newClient func(installationId int) (*GithubClient, error), workers int, queueSize int) (*WebhookServer, error) {
+	if secret == "" {
+		return nil, errors.New("A webhook secret is required to verify github webhook signatures")
+	}
+	s := &WebhookServer{
+		secret:    []byte(secret),
+		newClient: newClient,
+		timers:    NewTimers(),
+		queue:     make(chan webhookDelivery, queueSize),
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for delivery := range s.queue {
+				s.handle(delivery)
+				s.pending.Done()
+			}
+		}()
+	}
+	return s, nil
+}
+
+// Wait blocks until every queued delivery has been handled.
+func (s *WebhookServer) Wait() {
+	s.pending.Wait()
+}
+
+// Close handles the queued deliveries and stops the workers. No deliveries can be
+// served after Close.
+func (s *WebhookServer) Close() {
+	s.Wait()
+	close(s.queue)
+}
+
+func (s *WebhookServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	payload, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxPayloadBytes))
+	if err != nil {
+		http.Error(w, "Unable to read request body", http.StatusBadRequest)
+		return
+	}
+
+	if !verifySignature(s.secret, payload, req.Header.Get("X-Hub-Signature-256")) {
+		logger.Warn("Rejecting delivery with invalid signature", Fields{"delivery": req.Header.Get("X-GitHub-Delivery")})
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	eventType := req.Header.Get("X-GitHub-Event")
+	if eventType == "" {
+		http.Error(w, "Missing X-GitHub-Event header", http.StatusBadRequest)
+		return
+	}
+	if eventType == "ping" {
+		fmt.Fprintln(w, "pong")
+		return
+	}
+
+	delivery := webhookDelivery{id: req.Header.Get("X-GitHub-Delivery"), eventType: eventType, payload: payload}
+	log := logger.With(Fields{"event": eventType, "delivery": delivery.id})
+	log.Info(fmt.Sprintf("Received '%s' event delivery '%s'", eventType, delivery.id))
+
+	s.pending.Add(1)
+	select {
+	case s.queue <- delivery:
+	default:
+		s.pending.Done()
+		log.Error(fmt.Sprintf("Rejecting delivery, %d deliveries are already queued", cap(s.queue)))
+		http.Error(w, "Too many deliveries in progress", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintln(w, "accepted")
+}
+
+// handle handles a queued delivery. Errors are logged and counted in the events
+// metric, as the delivery was already acknowledged.
+func (s *WebhookServer) handle(delivery webhookDelivery) {
+	log := logger.With(Fields{"event": delivery.eventType, "delivery": delivery.id})
+	defer recoverPanic(log)
+
+	gh, err := s.newClient(GetInstallationId(delivery.payload))
+	if err != nil {
+		log.Error(fmt.Sprintf("Unable to create github client: %s", err))
+		metrics.Inc(MetricEvents, Labels{"event": delivery.eventType, "outcome": getEventOutcome(err)})
+		return
+	}
+	// Timers outlive the delivery, so they are shared by all clients
+	gh.Timers = s.timers
+	gh.Log = log
+
+	err = handleEventType(gh, delivery.eventType, delivery.payload)
+	metrics.Inc(MetricEvents, Labels{"event": delivery.eventType, "outcome": getEventOutcome(err)})
+	if errors.Is(err, errUnsupportedEvent) {
+		gh.Log.Info(fmt.Sprintf("Ignoring unsupported event type '%s'", delivery.eventType))
+		return
+	}
+	if err != nil {
+		gh.Log.Error(err.Error())
+		return
+	}
+
+	// Writes from delayed checks are logged when the timers run, after this summary
+	if gh.DryRun != nil {
+		gh.Log.Info(gh.DryRun.Summary(), Fields{"mutations": len(gh.DryRun.Mutations())})
+	}
+}
+
+// recoverPanic logs a panic while handling an event in the background and counts it
+// as an error, so that one payload cannot stop the server. It must be deferred.
+func recoverPanic(log *Logger) {
+	if r := recover(); r != nil {
+		log.Error(fmt.Sprintf("Panic handling event: %v", r), Fields{"stack": string(debug.Stack())})
+		metrics.Inc(MetricEvents, Labels{"event": fmt.Sprint(log.Field("event")), "outcome": "error"})
+	}
+}
+
+func getEventOutcome(err error) string {
+	if errors.Is(err, errUnsupportedEvent) {
+		return "unsupported"
+	}
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// verifySignature checks the X-Hub-Signature-256 header value, in the format
+// "sha256=<hex digest>", against the HMAC of the payload.
+// https://docs.github.com/en/webhooks/using-webhooks/validating-webhook-deliveries
+func verifySignature(secret []byte, payload []byte, signature string) bool {
+	const prefix = "sha256="
+	if !strings.HasPrefix(signature, prefix) {
+		return false
+	}
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, prefix))
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(expected, computeSignature(secret, payload))
+}
+
+func computeSignature(secret []byte, payload []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+func serve(addr string, secret string, newClient func(installationId int) (*GithubClient, error)) error {
+	server, err := NewWebhookServer(secret, newClient)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", server)
+	mux.Handle("/metrics", metrics)
+
+	// Payloads can be up to 25MB, so reads are given longer than github's 10 second
+	// delivery timeout. Handling events does not count, as it happens after the response.
+	httpServer := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+	logger.Info(fmt.Sprintf("Listening for github webhooks on %s", addr))
+	return httpServer.ListenAndServe()
+}
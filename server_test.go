@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testWebhookSecret = "test-secret"
+
+func signPayload(payload []byte) string {
+	return "sha256=" + hex.EncodeToString(computeSignature([]byte(testWebhookSecret), payload))
+}
+
+type TestServerCase struct {
+	Description      string
+	Method           string
+	EventType        string
+	Signature        string
+	Payload          []byte
+	ExpectedCode     int
+	ShouldPostStatus bool
+}
+
+func TestWebhookServer(t *testing.T) {
+	assert := assert.New(t)
+	payloads, err := getPayloads()
+	assert.NoError(err)
+	validSignature := signPayload(payloads.CheckSuiteEvent)
+	wrongSecretSignature := "sha256=" + hex.EncodeToString(computeSignature([]byte("wrong"), payloads.CheckSuiteEvent))
+
+	for _, tc := range []TestServerCase{
+		{"check suite event", "POST", "check_suite", validSignature, payloads.CheckSuiteEvent, http.StatusAccepted, true},
+		{"ping event", "POST", "ping", signPayload([]byte("{}")), []byte("{}"), http.StatusOK, false},
+		{"unsupported event", "POST", "push", validSignature, payloads.CheckSuiteEvent, http.StatusAccepted, false},
+		{"mismatched event type", "POST", "issue_comment", validSignature, payloads.CheckSuiteEvent, http.StatusAccepted, false},
+		{"missing event type", "POST", "", validSignature, payloads.CheckSuiteEvent, http.StatusBadRequest, false},
+		{"missing signature", "POST", "check_suite", "", payloads.CheckSuiteEvent, http.StatusUnauthorized, false},
+		{"wrong secret", "POST", "check_suite", wrongSecretSignature, payloads.CheckSuiteEvent, http.StatusUnauthorized, false},
+		{"sha1 signature", "POST", "check_suite", strings.Replace(validSignature, "sha256", "sha1", 1), payloads.CheckSuiteEvent, http.StatusUnauthorized, false},
+		{"invalid hex signature", "POST", "check_suite", "sha256=zz", payloads.CheckSuiteEvent, http.StatusUnauthorized, false},
+		{"modified payload", "POST", "check_suite", validSignature, append(payloads.CheckSuiteEvent, ' '), http.StatusUnauthorized, false},
+		{"GET request", "GET", "check_suite", validSignature, nil, http.StatusMethodNotAllowed, false},
+	} {
+		var postedState CommitState
+		var postedStatus bool
+		githubServer := NewCheckSuiteTestServer(assert, payloads, "", "", &postedState, &postedStatus, tc.Description)
+		defer githubServer.Close()
+
+		webhookServer, err := NewWebhookServer(testWebhookSecret, func(installationId int) (*GithubClient, error) {
+			return NewGithubClient(githubServer.URL, "", "octocoders-linter")
+		})
+		assert.NoError(err)
+		defer webhookServer.Close()
+
+		req := httptest.NewRequest(tc.Method, "/", bytes.NewReader(tc.Payload))
+		req.Header.Set("X-GitHub-Event", tc.EventType)
+		req.Header.Set("X-GitHub-Delivery", "72d3162e-cc78-11e3-81ab-4c9367dc0958")
+		if tc.Signature != "" {
+			req.Header.Set("X-Hub-Signature-256", tc.Signature)
+		}
+		recorder := httptest.NewRecorder()
+
+		fmt.Println(fmt.Sprintf("\n\n========= %s =========", tc.Description))
+		webhookServer.ServeHTTP(recorder, req)
+		webhookServer.Wait()
+
+		assert.Equal(tc.ExpectedCode, recorder.Code, tc.Description)
+		assert.NotContains(recorder.Body.String(), "Error", tc.Description)
+		assert.Equal(tc.ShouldPostStatus, postedStatus, tc.Description)
+		if tc.ShouldPostStatus {
+			assert.Equal(CommitStateSuccess, postedState, tc.Description)
+		}
+	}
+}
+
+func TestNewWebhookServerRequiresSecret(t *testing.T) {
+	_, err := NewWebhookServer("", nil)
+	assert.Error(t, err)
+}
+
+func TestWebhookServerQueue(t *testing.T) {
+	assert := assert.New(t)
+	payload := []byte("{}")
+	started := make(chan bool)
+	release := make(chan bool)
+	webhookServer, err := newWebhookServer(testWebhookSecret, func(installationId int) (*GithubClient, error) {
+		started <- true
+		<-release
+		return nil, errors.New("no client")
+	}, 1, 1)
+	assert.NoError(err)
+	defer webhookServer.Close()
+
+	deliver := func() int {
+		req := httptest.NewRequest("POST", "/", bytes.NewReader(payload))
+		req.Header.Set("X-GitHub-Event", "check_suite")
+		req.Header.Set("X-Hub-Signature-256", signPayload(payload))
+		recorder := httptest.NewRecorder()
+		webhookServer.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	// The delivery is acknowledged before it is handled
+	assert.Equal(http.StatusAccepted, deliver())
+	<-started
+	assert.Equal(http.StatusAccepted, deliver(), "The second delivery is queued")
+	assert.Equal(http.StatusServiceUnavailable, deliver(), "The queue is full")
+
+	close(release)
+	<-started
+	webhookServer.Wait()
+	assert.Equal(http.StatusAccepted, deliver())
+	<-started
+}
+
+func TestWebhookServerRecoversFromPanics(t *testing.T) {
+	assert := assert.New(t)
+	payload := []byte("{}")
+	handled := 0
+	webhookServer, err := newWebhookServer(testWebhookSecret, func(installationId int) (*GithubClient, error) {
+		handled++
+		panic("unexpected payload")
+	}, 1, 1)
+	assert.NoError(err)
+	defer webhookServer.Close()
+
+	labels := Labels{"event": "issue_comment", "outcome": "error"}
+	errors := metrics.Get(MetricEvents, labels)
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("POST", "/", bytes.NewReader(payload))
+		req.Header.Set("X-GitHub-Event", "issue_comment")
+		req.Header.Set("X-Hub-Signature-256", signPayload(payload))
+		webhookServer.ServeHTTP(httptest.NewRecorder(), req)
+		webhookServer.Wait()
+	}
+
+	assert.Equal(2, handled, "The worker keeps handling deliveries after a panic")
+	assert.Equal(errors+2, metrics.Get(MetricEvents, labels))
+}
@@ -0,0 +1,647 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/azure/azure-sdk-actions/fakegithub"
+	"gopkg.in/yaml.v3"
+)
+
+const DefaultTimelineRepo = "octocat/Hello-World"
+const DefaultTimelineUser = "octocat"
+
+// Timeline is a scenario for the simulate command: the initial state of a repository,
+// and steps that change it and deliver webhook events to check enforcer.
+type Timeline struct {
+	Description  string                `yaml:"description"`
+	Repo         string                `yaml:"repo"`
+	Config       string                `yaml:"config"`
+	Files        map[string]string     `yaml:"files"`
+	Permissions  map[string]string     `yaml:"permissions"`
+	Teams        map[string][]string   `yaml:"teams"`
+	PullRequests []TimelinePullRequest `yaml:"pull_requests"`
+	Steps        []TimelineStep        `yaml:"steps"`
+}
+
+type TimelinePullRequest struct {
+	Number  int      `yaml:"number"`
+	HeadRef string   `yaml:"head_ref"`
+	HeadSha string   `yaml:"head_sha"`
+	Files   []string `yaml:"files"`
+	// ExpectStatuses is the expected sequence of published states. Consecutive steps that
+	// publish the same state count once.
+	ExpectStatuses []CommitState `yaml:"expect_statuses"`
+}
+
+// TimelineStep applies its state changes in the order of the fields, then handles its
+// event and checks the expectations. Pull requests default to the first pull request
+// of the timeline, and commits to the head of the pull request.
+type TimelineStep struct {
+	Name        string               `yaml:"name"`
+	Push        *TimelinePush        `yaml:"push"`
+	CheckSuites []TimelineCheckSuite `yaml:"check_suites"`
+	CheckRuns   []TimelineCheckRun   `yaml:"check_runs"`
+	Event       *TimelineEvent       `yaml:"event"`
+	// FireTimers runs delayed checks, such as no_pipelines_grace, before the expectations,
+	// without waiting for their delay.
+	FireTimers bool            `yaml:"fire_timers"`
+	Expect     *TimelineExpect `yaml:"expect"`
+}
+
+// TimelinePush moves the head of a pull request to a new commit.
+type TimelinePush struct {
+	PullNumber int    `yaml:"pr"`
+	Sha        string `yaml:"sha"`
+}
+
+// TimelineCheckSuite adds a check suite, or updates the status and conclusion of the
+// check suite added with the same id in an earlier step.
+type TimelineCheckSuite struct {
+	Id         string `yaml:"id"`
+	App        string `yaml:"app"`
+	PullNumber int    `yaml:"pr"`
+	Sha        string `yaml:"sha"`
+	Status     string `yaml:"status"`
+	Conclusion string `yaml:"conclusion"`
+}
+
+// TimelineCheckRun adds a check run to a check suite of the timeline, or updates the
+// check run added with the same id. Age sets how long ago a new check run started.
+type TimelineCheckRun struct {
+	Id         string        `yaml:"id"`
+	Suite      string        `yaml:"suite"`
+	Name       string        `yaml:"name"`
+	Status     string        `yaml:"status"`
+	Conclusion string        `yaml:"conclusion"`
+	Age        time.Duration `yaml:"age"`
+}
+
+// TimelineEvent is a webhook event built from the state of the fake github.
+type TimelineEvent struct {
+	Type       string `yaml:"type"`
+	Action     string `yaml:"action"`
+	PullNumber int    `yaml:"pr"`
+	Sha        string `yaml:"sha"`
+	Suite      string `yaml:"suite"`
+	// Trigger is the event that triggered a workflow_run, pull_request by default.
+	Trigger string `yaml:"trigger"`
+	User    string `yaml:"user"`
+	Body    string `yaml:"body"`
+	Cron    string `yaml:"cron"`
+}
+
+// TimelineExpect is checked against the status check enforcer published for the pull
+// request of the step, and the comments it posted during the step.
+type TimelineExpect struct {
+	PullNumber  int         `yaml:"pr"`
+	State       CommitState `yaml:"state"`
+	Description string      `yaml:"description"`
+	Comment     string      `yaml:"comment"`
+	Comments    *int        `yaml:"comments"`
+	Error       string      `yaml:"error"`
+}
+
+// SimulationResult holds the published status after each step, by pull request, and
+// the expectations that were not met.
+type SimulationResult struct {
+	Statuses map[int][]*StatusBody
+	Failures []string
+}
+
+// LoadTimeline reads a timeline file. Unknown keys are rejected, as in the config file.
+func LoadTimeline(path string) (Timeline, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Timeline{}, err
+	}
+	timeline := Timeline{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err = decoder.Decode(&timeline); err != nil {
+		return Timeline{}, fmt.Errorf("Invalid timeline '%s': %w", path, err)
+	}
+	if len(timeline.PullRequests) == 0 {
+		return Timeline{}, errors.New(fmt.Sprintf("Invalid timeline '%s': at least one pull request is required", path))
+	}
+	if timeline.Repo == "" {
+		timeline.Repo = DefaultTimelineRepo
+	}
+	return timeline, nil
+}
+
+func runSimulate(options *Options, args []string) error {
+	flags := flag.NewFlagSet("simulate", flag.ExitOnError)
+	if err := parseCommandFlags(flags, options, args); err != nil {
+		return err
+	}
+	if options.cassette != nil {
+		return errors.New("Error: Cassettes are not supported by simulate.")
+	}
+	if flags.NArg() == 0 {
+		return errors.New("Error: No timeline file to simulate.")
+	}
+
+	failed := 0
+	for _, path := range flags.Args() {
+		timeline, err := LoadTimeline(path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "Simulating %s\n", path)
+		result, err := simulate(os.Stdout, timeline, options.DryRun)
+		if err != nil {
+			return err
+		}
+		failed += len(result.Failures)
+	}
+	if failed > 0 {
+		return errors.New(fmt.Sprintf("Error: %d expectation(s) failed.", failed))
+	}
+	return nil
+}
+
+// simulator runs the steps of a timeline against a fake github, with a new client for
+// each event as for webhook deliveries.
+type simulator struct {
+	w         io.Writer
+	timeline  Timeline
+	server    *fakegithub.Server
+	repo      *fakegithub.Repo
+	suites    map[string]int
+	runs      map[string]int
+	timers    *Timers
+	dryRun    bool
+	result    SimulationResult
+	published map[int]*StatusBody
+}
+
+// simulate runs the timeline and reports each step, the status it left published and
+// any unmet expectations to w. Errors are returned for invalid timelines; errors from
+// check enforcer are reported as unmet expectations unless the step expects them.
+func simulate(w io.Writer, timeline Timeline, dryRun bool) (SimulationResult, error) {
+	server := fakegithub.New()
+	defer server.Close()
+
+	s := &simulator{
+		w:         w,
+		timeline:  timeline,
+		server:    server,
+		repo:      server.AddRepo(timeline.Repo),
+		suites:    map[string]int{},
+		runs:      map[string]int{},
+		timers:    NewTimers(),
+		dryRun:    dryRun,
+		result:    SimulationResult{Statuses: map[int][]*StatusBody{}},
+		published: map[int]*StatusBody{},
+	}
+	defer s.timers.Stop()
+
+	if err := s.seed(); err != nil {
+		return s.result, err
+	}
+	if timeline.Description != "" {
+		fmt.Fprintf(w, "%s\n", strings.TrimSpace(timeline.Description))
+	}
+	for i, step := range timeline.Steps {
+		if err := s.runStep(i+1, step); err != nil {
+			return s.result, fmt.Errorf("Step %d '%s': %w", i+1, step.Name, err)
+		}
+	}
+	s.checkSequences()
+	s.report()
+	return s.result, nil
+}
+
+func (s *simulator) seed() error {
+	if s.timeline.Config != "" {
+		s.repo.SetFile(ConfigPath, s.timeline.Config)
+	}
+	for path, content := range s.timeline.Files {
+		s.repo.SetFile(path, content)
+	}
+	for user, role := range s.timeline.Permissions {
+		s.repo.SetPermission(user, role)
+	}
+	for team, users := range s.timeline.Teams {
+		for _, user := range users {
+			s.server.AddTeamMember(team, user)
+		}
+	}
+	for _, pr := range s.timeline.PullRequests {
+		if pr.Number <= 0 {
+			return errors.New("Pull request numbers must be positive")
+		}
+		headRef := pr.HeadRef
+		if headRef == "" {
+			headRef = fmt.Sprintf("pr-%d", pr.Number)
+		}
+		headSha := pr.HeadSha
+		if headSha == "" {
+			headSha = fmt.Sprintf("%040x", pr.Number)
+		}
+		s.repo.AddPullRequest(fakegithub.PullRequest{Number: pr.Number, HeadRef: headRef, HeadSha: headSha, Files: pr.Files})
+	}
+	return nil
+}
+
+// pullNumber returns the number, or the first pull request of the timeline if it is 0.
+func (s *simulator) pullNumber(number int) int {
+	if number == 0 {
+		return s.timeline.PullRequests[0].Number
+	}
+	return number
+}
+
+// headSha returns the sha, or the head of the pull request if it is empty.
+func (s *simulator) headSha(pullNumber int, sha string) (string, error) {
+	if sha != "" {
+		return sha, nil
+	}
+	pr, ok := s.repo.PullRequest(s.pullNumber(pullNumber))
+	if !ok {
+		return "", errors.New(fmt.Sprintf("Pull request #%d is not in the timeline", s.pullNumber(pullNumber)))
+	}
+	return pr.HeadSha, nil
+}
+
+func (s *simulator) runStep(n int, step TimelineStep) error {
+	fmt.Fprintf(s.w, "\n%d. %s\n", n, step.Name)
+
+	if step.Push != nil {
+		number := s.pullNumber(step.Push.PullNumber)
+		if err := s.repo.PushPullRequest(number, step.Push.Sha); err != nil {
+			return err
+		}
+		fmt.Fprintf(s.w, "   push #%d %s\n", number, step.Push.Sha)
+	}
+	for _, suite := range step.CheckSuites {
+		if err := s.applyCheckSuite(suite); err != nil {
+			return err
+		}
+	}
+	for _, run := range step.CheckRuns {
+		if err := s.applyCheckRun(run); err != nil {
+			return err
+		}
+	}
+
+	number := 0
+	if step.Expect != nil {
+		number = step.Expect.PullNumber
+	}
+	if step.Event != nil && number == 0 {
+		number = step.Event.PullNumber
+	}
+	number = s.pullNumber(number)
+	commentsBefore := len(s.ownComments(number))
+
+	var handlerErr error
+	if step.Event != nil {
+		payload, err := s.newPayload(step.Event)
+		if err != nil {
+			return err
+		}
+		handlerErr = s.handle(step.Event.Type, payload)
+	}
+	if step.FireTimers {
+		fmt.Fprintf(s.w, "   fire timers\n")
+		s.timers.Fire()
+	}
+	if handlerErr != nil {
+		fmt.Fprintf(s.w, "   error    %v\n", handlerErr)
+	}
+
+	published, err := s.getPublished(number)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(s.w, "   status   %s\n", formatPublished(published))
+	comments := s.ownComments(number)[commentsBefore:]
+	for _, comment := range comments {
+		fmt.Fprintf(s.w, "   comment  %s\n", firstLine(comment.Body))
+	}
+
+	s.record(number, published)
+	s.checkStep(n, step, published, comments, handlerErr)
+	return nil
+}
+
+func (s *simulator) applyCheckSuite(suite TimelineCheckSuite) error {
+	suite.Status = getTimelineStatus(suite.Status, suite.Conclusion)
+	if id, ok := s.suites[suite.Id]; suite.Id != "" && ok {
+		if err := s.repo.UpdateCheckSuite(id, suite.Status, suite.Conclusion); err != nil {
+			return err
+		}
+		updated, _ := s.repo.CheckSuite(id)
+		fmt.Fprintf(s.w, "   check suite %s (%s) %s\n", suite.Id, updated.App, formatCheckStatus(suite.Status, suite.Conclusion))
+		return nil
+	}
+
+	if suite.App == "" {
+		return errors.New(fmt.Sprintf("Check suite '%s' needs an app", suite.Id))
+	}
+	sha, err := s.headSha(suite.PullNumber, suite.Sha)
+	if err != nil {
+		return err
+	}
+	conclusion := ""
+	if suite.Status == fakegithub.StatusCompleted {
+		conclusion = suite.Conclusion
+	}
+	added := s.repo.AddCheckSuite(fakegithub.CheckSuite{App: suite.App, HeadSha: sha, Status: suite.Status, Conclusion: conclusion})
+	if suite.Id != "" {
+		s.suites[suite.Id] = added.Id
+	}
+	fmt.Fprintf(s.w, "   check suite %s (%s) %s\n", suite.Id, suite.App, formatCheckStatus(added.Status, added.Conclusion))
+	return nil
+}
+
+func (s *simulator) applyCheckRun(run TimelineCheckRun) error {
+	run.Status = getTimelineStatus(run.Status, run.Conclusion)
+	if id, ok := s.runs[run.Id]; run.Id != "" && ok {
+		if err := s.repo.UpdateCheckRun(id, run.Status, run.Conclusion); err != nil {
+			return err
+		}
+		fmt.Fprintf(s.w, "   check run %s %s\n", run.Id, formatCheckStatus(run.Status, run.Conclusion))
+		return nil
+	}
+
+	suiteId, ok := s.suites[run.Suite]
+	if !ok {
+		return errors.New(fmt.Sprintf("Check run '%s' needs the id of a check suite added in an earlier step", run.Id))
+	}
+	name := run.Name
+	if name == "" {
+		name = run.Id
+	}
+	checkRun := fakegithub.CheckRun{SuiteId: suiteId, Name: name, Status: run.Status}
+	if run.Status == fakegithub.StatusCompleted {
+		checkRun.Conclusion = run.Conclusion
+	}
+	if run.Age > 0 {
+		checkRun.StartedAt = time.Now().Add(-run.Age).UTC()
+	}
+	added, err := s.repo.AddCheckRun(checkRun)
+	if err != nil {
+		return err
+	}
+	if run.Id != "" {
+		s.runs[run.Id] = added.Id
+	}
+	fmt.Fprintf(s.w, "   check run %s %s\n", name, formatCheckStatus(added.Status, added.Conclusion))
+	return nil
+}
+
+func (s *simulator) newPayload(event *TimelineEvent) ([]byte, error) {
+	number := s.pullNumber(event.PullNumber)
+	switch event.Type {
+	case "pull_request":
+		action := defaultString(event.Action, string(PullRequestActionOpened))
+		fmt.Fprintf(s.w, "   event    pull_request %s #%d\n", action, number)
+		return s.repo.PullRequestEvent(action, number)
+	case "check_suite":
+		id, ok := s.suites[event.Suite]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("check_suite events need the id of a check suite, got '%s'", event.Suite))
+		}
+		action := defaultString(event.Action, string(CheckSuiteActionCompleted))
+		fmt.Fprintf(s.w, "   event    check_suite %s %s\n", action, event.Suite)
+		return s.repo.CheckSuiteEvent(action, id)
+	case "workflow_run":
+		sha, err := s.headSha(event.PullNumber, event.Sha)
+		if err != nil {
+			return nil, err
+		}
+		action := defaultString(event.Action, "completed")
+		trigger := defaultString(event.Trigger, "pull_request")
+		fmt.Fprintf(s.w, "   event    workflow_run %s for %s\n", action, trigger)
+		return s.repo.WorkflowRunEvent(action, sha, trigger)
+	case "issue_comment":
+		user := defaultString(event.User, DefaultTimelineUser)
+		fmt.Fprintf(s.w, "   event    issue_comment #%d @%s: %s\n", number, user, event.Body)
+		return s.repo.IssueCommentEvent(number, user, event.Body)
+	case "schedule":
+		cron := defaultString(event.Cron, "*/30 * * * *")
+		fmt.Fprintf(s.w, "   event    schedule '%s'\n", cron)
+		return s.repo.ScheduleEvent(cron)
+	}
+	return nil, errors.New(fmt.Sprintf("Unsupported event type '%s'", event.Type))
+}
+
+// handle routes the payload by event type as in server mode. Schedule payloads are
+// only delivered to github actions, so they are detected from the payload instead.
+func (s *simulator) handle(eventType string, payload []byte) error {
+	gh, err := s.newClient()
+	if err != nil {
+		return err
+	}
+	gh.Timers = s.timers
+	gh.Log = gh.Log.With(Fields{"event": eventType})
+	if eventType == "schedule" {
+		return handleEvent(gh, payload)
+	}
+	return handleEventType(gh, eventType, payload)
+}
+
+func (s *simulator) newClient() (*GithubClient, error) {
+	gh, err := NewGithubClientWithAuth(s.server.URL, NewTokenAuthenticator("simulated"), AzurePipelinesAppName, GithubActionsAppName)
+	if err != nil {
+		return nil, err
+	}
+	if s.dryRun {
+		gh.DryRun = NewDryRun()
+	}
+	return gh, nil
+}
+
+// getPublished reads the status published for the head of the pull request with the
+// repository config, the same as the status command.
+func (s *simulator) getPublished(number int) (*StatusBody, error) {
+	gh, err := s.newClient()
+	if err != nil {
+		return nil, err
+	}
+	repo, err := gh.GetRepo(s.timeline.Repo)
+	if err != nil {
+		return nil, err
+	}
+	// An invalid config is reported by the step that loaded it
+	if err = gh.LoadConfig(repo); err != nil {
+		gh.Log.Warn(fmt.Sprintf("Reading the published status with the default config: %v", err))
+	}
+	sha, err := s.headSha(number, "")
+	if err != nil {
+		return nil, err
+	}
+	return getPublishedStatus(gh, CommitTarget{Repo: repo, HeadSha: sha, PullNumber: number})
+}
+
+// ownComments returns the comments check enforcer posted on the pull request.
+func (s *simulator) ownComments(number int) []fakegithub.Comment {
+	comments := []fakegithub.Comment{}
+	for _, comment := range s.repo.Comments(number) {
+		if comment.User == fakegithub.DefaultUser {
+			comments = append(comments, comment)
+		}
+	}
+	return comments
+}
+
+// record adds the published status to the sequence of the pull request if it changed.
+func (s *simulator) record(number int, published *StatusBody) {
+	previous, ok := s.published[number]
+	s.published[number] = published
+	if ok && formatPublished(previous) == formatPublished(published) {
+		return
+	}
+	if published == nil && !ok {
+		return
+	}
+	s.result.Statuses[number] = append(s.result.Statuses[number], published)
+}
+
+func (s *simulator) fail(message string) {
+	s.result.Failures = append(s.result.Failures, message)
+	fmt.Fprintf(s.w, "   FAIL     %s\n", message)
+}
+
+func (s *simulator) checkStep(n int, step TimelineStep, published *StatusBody, comments []fakegithub.Comment, handlerErr error) {
+	expect := step.Expect
+	if expect == nil {
+		expect = &TimelineExpect{}
+	}
+	prefix := fmt.Sprintf("step %d '%s':", n, step.Name)
+
+	switch {
+	case expect.Error != "" && handlerErr == nil:
+		s.fail(fmt.Sprintf("%s expected error '%s'", prefix, expect.Error))
+	case expect.Error != "" && !strings.Contains(handlerErr.Error(), expect.Error):
+		s.fail(fmt.Sprintf("%s expected error '%s', got '%v'", prefix, expect.Error, handlerErr))
+	case expect.Error == "" && handlerErr != nil:
+		s.fail(fmt.Sprintf("%s unexpected error '%v'", prefix, handlerErr))
+	}
+
+	if expect.State != "" && (published == nil || published.State != expect.State) {
+		s.fail(fmt.Sprintf("%s expected state %s, got %s", prefix, expect.State, formatPublished(published)))
+	}
+	if expect.Description != "" && (published == nil || !strings.Contains(published.Description, expect.Description)) {
+		s.fail(fmt.Sprintf("%s expected description '%s', got %s", prefix, expect.Description, formatPublished(published)))
+	}
+	if expect.Comments != nil && len(comments) != *expect.Comments {
+		s.fail(fmt.Sprintf("%s expected %d comment(s), got %d", prefix, *expect.Comments, len(comments)))
+	}
+	if expect.Comment != "" {
+		found := false
+		for _, comment := range comments {
+			found = found || strings.Contains(comment.Body, expect.Comment)
+		}
+		if !found {
+			s.fail(fmt.Sprintf("%s expected a comment containing '%s'", prefix, expect.Comment))
+		}
+	}
+}
+
+func (s *simulator) checkSequences() {
+	for _, pr := range s.timeline.PullRequests {
+		if pr.ExpectStatuses == nil {
+			continue
+		}
+		actual := []CommitState{}
+		for _, status := range s.result.Statuses[pr.Number] {
+			if status != nil && (len(actual) == 0 || actual[len(actual)-1] != status.State) {
+				actual = append(actual, status.State)
+			}
+		}
+		if !equalStates(pr.ExpectStatuses, actual) {
+			s.fail(fmt.Sprintf("#%d expected status sequence %s, got %s", pr.Number, formatStates(pr.ExpectStatuses), formatStates(actual)))
+		}
+	}
+}
+
+func (s *simulator) report() {
+	for _, pr := range s.timeline.PullRequests {
+		fmt.Fprintf(s.w, "\nStatus sequence for %s#%d\n", s.timeline.Repo, pr.Number)
+		for i, status := range s.result.Statuses[pr.Number] {
+			fmt.Fprintf(s.w, "  %d. %s\n", i+1, formatPublished(status))
+		}
+	}
+	if len(s.result.Failures) == 0 {
+		fmt.Fprintf(s.w, "\n%d step(s), all expectations met\n", len(s.timeline.Steps))
+		return
+	}
+	fmt.Fprintf(s.w, "\n%d step(s), %d expectation(s) failed\n", len(s.timeline.Steps), len(s.result.Failures))
+	for _, failure := range s.result.Failures {
+		fmt.Fprintf(s.w, "  %s\n", failure)
+	}
+}
+
+// getTimelineStatus returns completed for checks with a conclusion and no status.
+func getTimelineStatus(status string, conclusion string) string {
+	if status == "" && conclusion != "" {
+		return fakegithub.StatusCompleted
+	}
+	return status
+}
+
+func formatPublished(status *StatusBody) string {
+	if status == nil {
+		return "none"
+	}
+	return fmt.Sprintf("%s: %s", status.State, status.Description)
+}
+
+func formatCheckStatus(status string, conclusion string) string {
+	if status == "" {
+		status = fakegithub.StatusQueued
+	}
+	if conclusion == "" {
+		return status
+	}
+	return status + "/" + conclusion
+}
+
+func formatStates(states []CommitState) string {
+	names := []string{}
+	for _, state := range states {
+		names = append(names, string(state))
+	}
+	return "[" + strings.Join(names, ", ") + "]"
+}
+
+func equalStates(expected []CommitState, actual []CommitState) bool {
+	if len(expected) != len(actual) {
+		return false
+	}
+	for i := range expected {
+		if expected[i] != actual[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// firstLine returns the first line of a comment that is not a hidden marker.
+func firstLine(body string) string {
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "<!--") {
+			return line
+		}
+	}
+	return ""
+}
+
+func defaultString(value string, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
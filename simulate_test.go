@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSimulateTimelines(t *testing.T) {
+	assert := assert.New(t)
+	paths, err := filepath.Glob("./testpayloads/timelines/*.yml")
+	assert.NoError(err)
+	assert.NotEmpty(paths)
+
+	for _, path := range paths {
+		timeline, err := LoadTimeline(path)
+		if !assert.NoError(err, path) {
+			continue
+		}
+		out := bytes.Buffer{}
+		result, err := simulate(&out, timeline, false)
+		assert.NoError(err, path)
+		assert.Empty(result.Failures, out.String())
+	}
+}
+
+type TestSimulateCase struct {
+	Description      string
+	Timeline         string
+	ExpectedFailures []string
+	ExpectedError    string
+}
+
+func TestSimulate(t *testing.T) {
+	assert := assert.New(t)
+	pullRequests := "pull_requests:\n  - number: 1\n"
+	invalidConfig := "config: \"version: 2\"\n"
+
+	for _, tc := range []TestSimulateCase{
+		{"unmet expectations", "config: \"version: 1\\nno_pipelines_grace: 0s\"\n" + pullRequests + `    expect_statuses: [success]
+steps:
+  - name: opened
+    event: {type: pull_request}
+    expect: {state: success, description: All checks passed, comment: hello, comments: 1}
+`, []string{
+			"step 1 'opened': expected state success, got pending: Waiting for pipelines to start",
+			"step 1 'opened': expected description 'All checks passed', got pending: Waiting for pipelines to start",
+			"step 1 'opened': expected 1 comment(s), got 0",
+			"step 1 'opened': expected a comment containing 'hello'",
+			"#1 expected status sequence [success], got [pending]",
+		}, ""},
+		{"expected error", invalidConfig + pullRequests + `steps:
+  - name: invalid config
+    event: {type: pull_request}
+    expect: {error: Invalid config}
+`, []string{}, ""},
+		{"unexpected error", invalidConfig + pullRequests + `steps:
+  - name: invalid config
+    event: {type: pull_request}
+`, []string{"step 1 'invalid config': unexpected error"}, ""},
+		{"skipped branch", `pull_requests:
+  - {number: 1, head_ref: main, expect_statuses: []}
+steps:
+  - name: opened
+    event: {type: pull_request}
+`, []string{}, ""},
+		{"unknown check suite", pullRequests + `steps:
+  - name: completed
+    event: {type: check_suite, suite: pipelines}
+`, nil, "Step 1 'completed': check_suite events need the id of a check suite"},
+		{"unsupported event", pullRequests + `steps:
+  - name: merged
+    event: {type: merge_group}
+`, nil, "Unsupported event type 'merge_group'"},
+	} {
+		path := filepath.Join(t.TempDir(), "timeline.yml")
+		assert.NoError(ioutil.WriteFile(path, []byte(tc.Timeline), 0644))
+		timeline, err := LoadTimeline(path)
+		if !assert.NoError(err, tc.Description) {
+			continue
+		}
+
+		out := bytes.Buffer{}
+		result, err := simulate(&out, timeline, false)
+		if tc.ExpectedError != "" {
+			if assert.Error(err, tc.Description) {
+				assert.Contains(err.Error(), tc.ExpectedError, tc.Description)
+			}
+			continue
+		}
+		assert.NoError(err, tc.Description)
+		if assert.Len(result.Failures, len(tc.ExpectedFailures), out.String()) {
+			for i, expected := range tc.ExpectedFailures {
+				assert.True(strings.HasPrefix(result.Failures[i], expected), result.Failures[i])
+			}
+		}
+	}
+}
+
+func TestLoadTimeline(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "unknown_key.yml")
+	assert.NoError(ioutil.WriteFile(path, []byte("pull_requests:\n  - number: 1\nsteps:\n  - name: opened\n    evnt: {type: pull_request}\n"), 0644))
+	_, err := LoadTimeline(path)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "field evnt not found")
+	}
+
+	path = filepath.Join(dir, "no_pull_requests.yml")
+	assert.NoError(ioutil.WriteFile(path, []byte("steps: []\n"), 0644))
+	_, err = LoadTimeline(path)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "at least one pull request is required")
+	}
+
+	path = filepath.Join(dir, "defaults.yml")
+	assert.NoError(ioutil.WriteFile(path, []byte("pull_requests:\n  - number: 1\n"), 0644))
+	timeline, err := LoadTimeline(path)
+	assert.NoError(err)
+	assert.Equal(DefaultTimelineRepo, timeline.Repo)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const StuckChecksCommentPath = "./comments/stuck_checks.txt"
+
+// StuckCheck is a check run that has been queued or in progress for longer than the
+// configured maximum pending age.
+type StuckCheck struct {
+	App     string
+	Name    string
+	HtmlUrl string
+	Age     time.Duration
+}
+
+func (s StuckCheck) String() string {
+	return fmt.Sprintf("%s/%s", s.App, s.Name)
+}
+
+// findStuckChecks returns the unfinished check runs of unfinished check suites that
+// started more than max_pending_age ago. Check runs are listed for suites evaluated
+// from their conclusion, as only check runs have a start time.
+func findStuckChecks(gh *GithubClient, evaluation *Evaluation, now time.Time) ([]StuckCheck, error) {
+	stuck := []StuckCheck{}
+	if gh.Config.MaxPendingAge == 0 {
+		return stuck, nil
+	}
+
+	for _, result := range evaluation.Results {
+		if result.Suite.Status == CheckSuiteStatusCompleted {
+			continue
+		}
+
+		runs := result.Runs
+		if runs == nil {
+			if gh.shed(fmt.Sprintf("stuck check run lookup for '%s'", result.Suite.App.Name)) {
+				continue
+			}
+			var err error
+			if runs, err = gh.GetCheckRuns(result.Suite.CheckRunsUrl); err != nil {
+				return nil, err
+			}
+			runs = excludeOwnCheckRun(gh.Config, runs)
+		}
+
+		for _, run := range runs {
+			if run.Status == CheckSuiteStatusCompleted || run.StartedAt.IsZero() {
+				continue
+			}
+			age := now.Sub(run.StartedAt)
+			if age > gh.Config.MaxPendingAge {
+				evaluation.trace("Check run '%s' for '%s' has been '%s' for %s.", run.Name, result.Suite.App.Name, run.Status, formatAge(age))
+				stuck = append(stuck, StuckCheck{App: result.Suite.App.Name, Name: run.Name, HtmlUrl: run.HtmlUrl, Age: age})
+			}
+		}
+	}
+
+	return stuck, nil
+}
+
+// newStuckBody returns an error status naming the check run that has been pending the
+// longest, so that the pull request no longer looks like it is just waiting for CI.
+func newStuckBody(context string, stuck []StuckCheck) StatusBody {
+	oldest := stuck[0]
+	for _, check := range stuck {
+		if check.Age > oldest.Age {
+			oldest = check
+		}
+	}
+
+	status := newErrorBody(context)
+	description := fmt.Sprintf("Stuck: '%s' pending for %s", oldest.Name, formatAge(oldest.Age))
+	if len(stuck) > 1 {
+		description += fmt.Sprintf(" (+%d more)", len(stuck)-1)
+	}
+	status.Description = truncateDescription(description)
+	return status
+}
+
+var stuckAgePattern = regexp.MustCompile(` pending for \d+[dhm]( \d+[hm])?`)
+
+// withoutStuckAge removes the age from a stuck status description, as the age changes
+// every time the status is evaluated.
+func withoutStuckAge(description string) string {
+	if !strings.HasPrefix(description, "Stuck: ") {
+		return description
+	}
+	return stuckAgePattern.ReplaceAllString(description, "")
+}
+
+func stuckChecksMarker(headSha string) string {
+	return fmt.Sprintf("<!-- check-enforcer:stuck-checks:%s -->", headSha)
+}
+
+// commentStuckChecks comments on the pull request with the stuck check runs, unless
+// it was already done for the commit.
+func commentStuckChecks(gh *GithubClient, target CommitTarget, stuck []StuckCheck) error {
+	text, err := gh.Config.GetStuckChecksComment()
+	if err != nil {
+		return err
+	}
+
+	body := strings.Builder{}
+	body.WriteString(strings.TrimSpace(text) + "\n\n")
+	for _, check := range stuck {
+		name := fmt.Sprintf("`%s`", check)
+		if check.HtmlUrl != "" {
+			name = fmt.Sprintf("[%s](%s)", name, check.HtmlUrl)
+		}
+		body.WriteString(fmt.Sprintf("- %s, pending for %s\n", name, formatAge(check.Age)))
+	}
+
+	return commentOnce(gh, target, stuckChecksMarker(target.HeadSha), body.String())
+}
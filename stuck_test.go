@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type TestStuckCase struct {
+	Description        string
+	Config             string
+	Runs               []CheckRun
+	ExistingComments   []string
+	ExpectedState      CommitState
+	ExpectedComment    bool
+	ExpectedListedRuns bool
+}
+
+func TestStuckChecks(t *testing.T) {
+	assert := assert.New(t)
+	payloads, err := getPayloads()
+	assert.NoError(err)
+	checkSuite := NewCheckSuiteWebhook(payloads.CheckSuiteEvent)
+	assert.NotEmpty(checkSuite)
+	sha := checkSuite.CheckSuite.HeadSha
+	inProgressEvent := strings.Replace(string(payloads.CheckSuiteEvent), `"status": "completed"`, `"status": "in_progress"`, 1)
+	inProgressEvent = strings.Replace(inProgressEvent, `"conclusion": "success"`, `"conclusion": null`, 1)
+
+	enabled := "version: 1\napps: [octocoders-linter]\nmax_pending_age: 24h"
+	stuckRun := CheckRun{Name: "java - core - ci", Status: CheckSuiteStatusInProgress, StartedAt: time.Now().Add(-50 * time.Hour), HtmlUrl: "https://github.com/runs/1"}
+	recentRun := CheckRun{Name: "java - core - tests", Status: CheckSuiteStatusQueued, StartedAt: time.Now().Add(-time.Hour)}
+	finishedRun := CheckRun{Name: "java - core - lint", Status: CheckSuiteStatusCompleted, Conclusion: CheckSuiteConclusionSuccess, StartedAt: time.Now().Add(-72 * time.Hour)}
+
+	for _, tc := range []TestStuckCase{
+		{"disabled", "version: 1\napps: [octocoders-linter]", []CheckRun{stuckRun}, nil, CommitStatePending, false, false},
+		{"not stuck", enabled, []CheckRun{recentRun, finishedRun}, nil, CommitStatePending, false, true},
+		{"stuck", enabled, []CheckRun{stuckRun, recentRun, finishedRun}, nil, CommitStateError, true, true},
+		{"stuck already commented", enabled, []CheckRun{stuckRun}, []string{stuckChecksMarker(sha)}, CommitStateError, false, true},
+		{"stuck check run policy", enabled + "\ncheck_runs:\n  octocoders-linter:\n    required: ['java - *']", []CheckRun{stuckRun}, nil, CommitStateError, true, true},
+	} {
+		postedState := CommitState("")
+		postedDescription := ""
+		postedComment := false
+		listedRuns := false
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			switch {
+			case isConfigRequest(req):
+				w.Write(newConfigResponse(tc.Config))
+			case req.URL.Path == "/repos/Codertocat/Hello-World/check-suites/118578147/check-runs":
+				listedRuns = true
+				json.NewEncoder(w).Encode(CheckRuns{Count: len(tc.Runs), CheckRuns: tc.Runs})
+			case req.URL.Path == "/repos/Codertocat/Hello-World/statuses/"+sha && req.Method == "POST":
+				status := getStatusBody(assert, req)
+				postedState = status.State
+				postedDescription = status.Description
+				w.Write(payloads.StatusResponse)
+			case req.URL.Path == "/repos/Codertocat/Hello-World/issues/2/comments" && req.Method == "GET":
+				comments := []IssueComment{}
+				for _, c := range tc.ExistingComments {
+					comments = append(comments, IssueComment{Body: c})
+				}
+				json.NewEncoder(w).Encode(comments)
+			case req.URL.Path == "/repos/Codertocat/Hello-World/issues/2/comments" && req.Method == "POST":
+				postedComment = true
+				data, err := ioutil.ReadAll(req.Body)
+				assert.NoError(err)
+				body := IssueCommentBody{}
+				assert.NoError(json.Unmarshal(data, &body))
+				assert.True(strings.HasPrefix(body.Body, stuckChecksMarker(sha)), tc.Description)
+				assert.Contains(body.Body, "/check-enforcer evaluate", tc.Description)
+				assert.Contains(body.Body, "- [`octocoders-linter/java - core - ci`](https://github.com/runs/1), pending for 2d 2h\n", tc.Description)
+				assert.NotContains(body.Body, "java - core - tests", tc.Description)
+				w.Write(payloads.NewCommentResponse)
+			default:
+				assert.Fail(fmt.Sprintf("%s: Unexpected %s request to '%s'", tc.Description, req.Method, req.URL.String()))
+			}
+		}))
+		defer server.Close()
+
+		gh, err := NewGithubClient(server.URL, "", "octocoders-linter")
+		assert.NoError(err)
+		assert.NoError(handleEvent(gh, []byte(inProgressEvent)), tc.Description)
+
+		assert.Equal(tc.ExpectedState, postedState, tc.Description)
+		assert.Equal(tc.ExpectedComment, postedComment, tc.Description)
+		assert.Equal(tc.ExpectedListedRuns, listedRuns, tc.Description)
+		if tc.ExpectedState == CommitStateError {
+			assert.Equal("Stuck: 'java - core - ci' pending for 2d 2h", postedDescription, tc.Description)
+		}
+	}
+}
+
+func TestNewStuckBody(t *testing.T) {
+	assert := assert.New(t)
+	status := newStuckBody(CommitStatusContext, []StuckCheck{
+		{App: "Azure Pipelines", Name: "js - core - ci", Age: 25 * time.Hour},
+		{App: "Azure Pipelines", Name: "java - core - ci", Age: 74 * time.Hour},
+		{App: "GitHub Actions", Name: "lint", Age: 30 * time.Hour},
+	})
+	assert.Equal(CommitStateError, status.State)
+	assert.Equal("Stuck: 'java - core - ci' pending for 3d 2h (+2 more)", status.Description)
+
+	_, err := ParseConfig([]byte("version: 1\nmax_pending_age: -1h"), NewDefaultConfig(AzurePipelinesAppName))
+	assert.Error(err)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Timers runs delayed checks, such as the grace period before reporting that no
+// pipelines were triggered for a pull request. Scheduling a key again replaces the
+// pending timer for that key, e.g. when new commits are pushed to a pull request
+// before its grace period ends.
+type Timers struct {
+	mutex  sync.Mutex
+	timers map[string]*timer
+	wait   sync.WaitGroup
+}
+
+type timer struct {
+	*time.Timer
+	run func()
+}
+
+func NewTimers() *Timers {
+	return &Timers{timers: map[string]*timer{}}
+}
+
+// Schedule runs fn after delay. Errors and panics are logged with log, which has the
+// fields of the event that scheduled the timer.
+func (t *Timers) Schedule(log *Logger, key string, delay time.Duration, fn func() error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if existing, ok := t.timers[key]; ok && existing.Stop() {
+		log.Info(fmt.Sprintf("Replacing timer '%s'.", key))
+		t.wait.Done()
+	}
+
+	t.wait.Add(1)
+	scheduled := &timer{}
+	scheduled.run = func() {
+		defer t.wait.Done()
+		defer recoverPanic(log)
+
+		t.mutex.Lock()
+		if t.timers[key] == scheduled {
+			delete(t.timers, key)
+		}
+		t.mutex.Unlock()
+
+		if err := fn(); err != nil {
+			log.Error(fmt.Sprintf("Error running timer '%s': %v", key, err))
+		}
+	}
+	scheduled.Timer = time.AfterFunc(delay, scheduled.run)
+	t.timers[key] = scheduled
+	log.Info(fmt.Sprintf("Scheduled timer '%s' in %s.", key, delay))
+}
+
+// Fire runs every pending timer now, without waiting for its delay, and waits for
+// all timers to finish. It lets simulations run delayed checks deterministically.
+func (t *Timers) Fire() {
+	t.mutex.Lock()
+	due := []*timer{}
+	for _, pending := range t.timers {
+		if pending.Stop() {
+			due = append(due, pending)
+		}
+	}
+	t.mutex.Unlock()
+
+	for _, pending := range due {
+		pending.run()
+	}
+	t.Wait()
+}
+
+// Wait blocks until every scheduled timer has run or been replaced.
+func (t *Timers) Wait() {
+	t.wait.Wait()
+}
+
+// Stop cancels every pending timer. Timers that are already running are not waited for.
+func (t *Timers) Stop() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for key, pending := range t.timers {
+		if pending.Stop() {
+			logger.Info(fmt.Sprintf("Cancelled timer '%s'.", key))
+			t.wait.Done()
+		}
+		delete(t.timers, key)
+	}
+}
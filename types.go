@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 )
 
 const (
@@ -27,6 +28,7 @@ const (
 	CheckSuiteConclusionTimedOut       CheckSuiteConclusion = "timed_out"
 	CheckSuiteConclusionActionRequired CheckSuiteConclusion = "action_required"
 	CheckSuiteConclusionStale          CheckSuiteConclusion = "stale"
+	CheckSuiteConclusionSkipped        CheckSuiteConclusion = "skipped"
 	CheckSuiteConclusionEmpty          CheckSuiteConclusion = ""
 )
 
@@ -45,29 +47,61 @@ type StatusBody struct {
 type PullRequest struct {
 	Url         string `json:"url"`
 	HtmlUrl     string `json:"html_url"`
+	CommentsUrl string `json:"comments_url"`
 	Id          int    `json:"id"`
 	Number      int    `json:"number"`
 	State       string `json:"state"`
 	Title       string `json:"title"`
 	StatusesUrl string `json:"statuses_url"`
 	Head        struct {
+		Ref  string `json:"ref"`
 		Sha  string `json:"sha"`
 		Repo Repo   `json:"repo"` // Head.Repo is the repository/fork containing the new changes
 	} `json:"head"`
 	Base struct {
-		Repo Repo `json:"repo"` // Base.Repo is the repository that will be updated
+		Ref  string `json:"ref"`
+		Repo Repo   `json:"repo"` // Base.Repo is the repository that will be updated
 	} `json:"base"`
 }
 
+type PullRequestWebhook struct {
+	Action      ActionType  `json:"action"`
+	Number      int         `json:"number"`
+	PullRequest PullRequest `json:"pull_request"`
+	Repo        Repo        `json:"repository"`
+}
+
 type Repo struct {
-	Id          int    `json:"id"`
-	Name        string `json:"name"`
-	Url         string `json:"url"`
-	CommitsUrl  string `json:"commits_url"`
-	HtmlUrl     string `json:"html_url"`
-	IssuesUrl   string `json:"issues_url"`
-	PullsUrl    string `json:"pulls_url"`
-	StatusesUrl string `json:"statuses_url"`
+	Id               int    `json:"id"`
+	Name             string `json:"name"`
+	FullName         string `json:"full_name"`
+	Url              string `json:"url"`
+	CollaboratorsUrl string `json:"collaborators_url"`
+	CommitsUrl       string `json:"commits_url"`
+	ContentsUrl      string `json:"contents_url"`
+	HtmlUrl          string `json:"html_url"`
+	IssuesUrl        string `json:"issues_url"`
+	PullsUrl         string `json:"pulls_url"`
+	StatusesUrl      string `json:"statuses_url"`
+}
+
+// GetOpenPullsUrl returns the url listing the open pull requests of the repository.
+func (r *Repo) GetOpenPullsUrl() string {
+	return strings.ReplaceAll(r.PullsUrl, "{/number}", "") + "?state=open"
+}
+
+func (r *Repo) GetContentsUrl(path string) string {
+	return strings.ReplaceAll(r.ContentsUrl, "{+path}", path)
+}
+
+func (r *Repo) GetCollaboratorPermissionUrl(user string) string {
+	return strings.ReplaceAll(r.CollaboratorsUrl, "{/collaborator}", fmt.Sprintf("/%s", user)) + "/permission"
+}
+
+type FileContent struct {
+	Path     string `json:"path"`
+	Encoding string `json:"encoding"`
+	Content  string `json:"content"`
 }
 
 type CheckSuites struct {
@@ -85,12 +119,121 @@ type CheckSuite struct {
 	CheckRunsUrl        string               `json:"check_runs_url"`
 	LatestCheckRunCount int                  `json:"latest_check_runs_count"`
 	App                 App                  `json:"app"`
+	PullRequests        []PullRequestRef     `json:"pull_requests"`
+}
+
+// PullRequestRef is the minimal pull request object included in check suite and workflow run
+// payloads. Github leaves the list empty for pull requests from forks.
+type PullRequestRef struct {
+	Url    string `json:"url"`
+	Number int    `json:"number"`
+}
+
+// CommitTarget is the commit check enforcer evaluates and posts a status for.
+type CommitTarget struct {
+	Repo        Repo // Repo is the base repository
+	HeadSha     string
+	StatusesUrl string
+	PullNumber  int // PullNumber is 0 if the pull request for the commit is not known
+}
+
+func NewCommitTarget(repo Repo, headSha string, statusesUrl string, pullRequests []PullRequestRef) CommitTarget {
+	target := CommitTarget{Repo: repo, HeadSha: headSha, StatusesUrl: statusesUrl}
+	if len(pullRequests) > 0 {
+		target.PullNumber = pullRequests[0].Number
+	}
+	return target
+}
+
+func (t *CommitTarget) GetPullUrl() string {
+	return strings.ReplaceAll(t.Repo.PullsUrl, "{/number}", fmt.Sprintf("/%d", t.PullNumber))
+}
+
+func (t *CommitTarget) GetCommentsUrl() string {
+	return strings.ReplaceAll(t.Repo.IssuesUrl, "{/number}", fmt.Sprintf("/%d", t.PullNumber)) + "/comments"
+}
+
+// GetCheckRunsUrl returns the url for creating check runs in the base repository.
+func (t *CommitTarget) GetCheckRunsUrl() string {
+	return t.Repo.Url + "/check-runs"
+}
+
+// GetCommitCheckRunsUrl returns the url listing check runs for the commit.
+func (t *CommitTarget) GetCommitCheckRunsUrl() string {
+	return strings.ReplaceAll(t.Repo.CommitsUrl, "{/sha}", fmt.Sprintf("/%s", t.HeadSha)) + "/check-runs"
+}
+
+// GetCombinedStatusUrl returns the url of the latest status for each context of the commit.
+func (t *CommitTarget) GetCombinedStatusUrl() string {
+	return strings.ReplaceAll(t.Repo.CommitsUrl, "{/sha}", fmt.Sprintf("/%s", t.HeadSha)) + "/status"
+}
+
+// GetCommitPullsUrl returns the url listing pull requests associated with the commit.
+func (t *CommitTarget) GetCommitPullsUrl() string {
+	return strings.ReplaceAll(t.Repo.CommitsUrl, "{/sha}", fmt.Sprintf("/%s", t.HeadSha)) + "/pulls"
 }
 
 type App struct {
 	Name string `json:"name"`
 }
 
+type CheckRuns struct {
+	Count     int        `json:"total_count"`
+	CheckRuns []CheckRun `json:"check_runs"`
+}
+
+// CheckRun conclusions and statuses share the check suite values.
+type CheckRun struct {
+	Id          int                  `json:"id"`
+	Name        string               `json:"name"`
+	Url         string               `json:"url"`
+	HeadSha     string               `json:"head_sha"`
+	Status      CheckSuiteStatus     `json:"status"`
+	Conclusion  CheckSuiteConclusion `json:"conclusion"`
+	HtmlUrl     string               `json:"html_url"`
+	DetailsUrl  string               `json:"details_url"`
+	StartedAt   time.Time            `json:"started_at"`
+	CompletedAt *time.Time           `json:"completed_at"`
+	App         App                  `json:"app"`
+	Output      CheckRunOutput       `json:"output"`
+}
+
+// CheckRunBody creates or updates a check run.
+// https://docs.github.com/en/rest/checks/runs#create-a-check-run
+type CheckRunBody struct {
+	Name       string               `json:"name,omitempty"`
+	HeadSha    string               `json:"head_sha,omitempty"`
+	Status     CheckSuiteStatus     `json:"status"`
+	Conclusion CheckSuiteConclusion `json:"conclusion,omitempty"`
+	DetailsUrl string               `json:"details_url,omitempty"`
+	Output     CheckRunOutput       `json:"output"`
+}
+
+type CheckRunOutput struct {
+	Title   string `json:"title"`
+	Summary string `json:"summary"`
+	Text    string `json:"text,omitempty"`
+}
+
+// CombinedStatus holds the latest status for each context of a commit.
+// https://docs.github.com/en/rest/commits/statuses#get-the-combined-status-for-a-specific-reference
+type CombinedStatus struct {
+	State    CommitState  `json:"state"`
+	Sha      string       `json:"sha"`
+	Statuses []StatusBody `json:"statuses"`
+}
+
+type PullRequestFile struct {
+	Filename         string `json:"filename"`
+	Status           string `json:"status"`
+	PreviousFilename string `json:"previous_filename"`
+}
+
+type User struct {
+	Login string `json:"login"`
+	Type  string `json:"type"`
+}
+
 type Issue struct {
 	Url         string `json:"url"`
 	Number      int    `json:"number"`
@@ -104,24 +247,41 @@ type IssueComment struct {
 	HtmlUrl string `json:"html_url"`
 	Id      int    `json:"id"`
 	Body    string `json:"body"`
+	User    User   `json:"user"`
 }
 
 type IssueCommentBody struct {
 	Body string `json:"body"`
 }
 
-type CheckSuiteWebhook struct {
-	Action     ActionType `json:"action"`
-	CheckSuite CheckSuite `json:"check_suite"`
-	Repo       Repo       `json:"repository"`
+type Installation struct {
+	Id int `json:"id"`
+}
+
+// InstallationWebhook holds the installation field that is set on all webhook
+// payloads delivered to a github app.
+type InstallationWebhook struct {
+	Installation Installation `json:"installation"`
+}
+
+func GetInstallationId(payload []byte) int {
+	var webhook InstallationWebhook
+	if err := json.Unmarshal(payload, &webhook); err != nil {
+		return 0
+	}
+	return webhook.Installation.Id
 }
 
-func IsCheckSuiteSucceeded(conclusion CheckSuiteConclusion) bool {
-	return conclusion == CheckSuiteConclusionSuccess
+// ScheduleWebhook is the payload of a github actions workflow run by a schedule trigger.
+type ScheduleWebhook struct {
+	Schedule string `json:"schedule"`
+	Repo     Repo   `json:"repository"`
 }
 
-func IsCheckSuiteFailed(conclusion CheckSuiteConclusion) bool {
-	return conclusion == CheckSuiteConclusionFailure || conclusion == CheckSuiteConclusionTimedOut
+type CheckSuiteWebhook struct {
+	Action     ActionType `json:"action"`
+	CheckSuite CheckSuite `json:"check_suite"`
+	Repo       Repo       `json:"repository"`
 }
 
 func (csw *CheckSuiteWebhook) GetCheckSuiteUrl() string {
@@ -151,11 +311,34 @@ func (ic *IssueCommentWebhook) GetCommentsUrl() string {
 	return ic.Issue.CommentsUrl
 }
 
-type WorkflowRun struct {
-	HtmlUrl string `json:"html_url"`
+type MergeGroup struct {
 	HeadSha string `json:"head_sha"`
-	Event   string `json:"event"`
-	Repo    Repo   `json:"repository"`
+	HeadRef string `json:"head_ref"`
+	BaseSha string `json:"base_sha"`
+	BaseRef string `json:"base_ref"`
+}
+
+type MergeGroupWebhook struct {
+	Action     ActionType `json:"action"`
+	MergeGroup MergeGroup `json:"merge_group"`
+	Repo       Repo       `json:"repository"`
+}
+
+func (mg *MergeGroupWebhook) GetStatusesUrl() string {
+	return strings.ReplaceAll(mg.Repo.StatusesUrl, "{sha}", mg.MergeGroup.HeadSha)
+}
+
+func (mg *MergeGroupWebhook) GetCheckSuiteUrl() string {
+	return strings.ReplaceAll(mg.Repo.CommitsUrl, "{/sha}", fmt.Sprintf("/%s", mg.MergeGroup.HeadSha)) + "/check-suites"
+}
+
+type WorkflowRun struct {
+	HtmlUrl      string           `json:"html_url"`
+	HeadSha      string           `json:"head_sha"`
+	HeadBranch   string           `json:"head_branch"`
+	Event        string           `json:"event"`
+	Repo         Repo             `json:"repository"`
+	PullRequests []PullRequestRef `json:"pull_requests"`
 }
 
 type WorkflowRunWebhook struct {
@@ -182,6 +365,39 @@ func NewWorkflowRunWebhook(payload []byte) *WorkflowRunWebhook {
 	return &wr
 }
 
+func NewScheduleWebhook(payload []byte) *ScheduleWebhook {
+	var sw ScheduleWebhook
+	if err := json.Unmarshal(payload, &sw); err != nil {
+		return nil
+	}
+	if sw.Schedule == "" {
+		return nil
+	}
+	return &sw
+}
+
+func NewMergeGroupWebhook(payload []byte) *MergeGroupWebhook {
+	var mg MergeGroupWebhook
+	if err := json.Unmarshal(payload, &mg); err != nil {
+		return nil
+	}
+	if mg.MergeGroup.HeadSha == "" {
+		return nil
+	}
+	return &mg
+}
+
+func NewPullRequestWebhook(payload []byte) *PullRequestWebhook {
+	var pr PullRequestWebhook
+	if err := json.Unmarshal(payload, &pr); err != nil {
+		return nil
+	}
+	if pr.PullRequest.Number == 0 || pr.PullRequest.Head.Sha == "" {
+		return nil
+	}
+	return &pr
+}
+
 func NewIssueCommentBody(body string) ([]byte, error) {
 	jsonBody, err := json.Marshal(IssueCommentBody{body})
 	if err != nil {